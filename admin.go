@@ -0,0 +1,308 @@
+package main
+
+import (
+	"app/catalog"
+	"app/db"
+	tools "app/handlers"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	adminMenuText     = "🛠 Меню администратора"
+	adminLastLeads    = 10 // сколько заявок показывать по кнопке «Последние заявки»
+	adminMaxLeads     = 50
+	adminMaxWarnings  = 20
+	adminNoFailures   = "✅ Все заявки переданы в CRM."
+	adminRetryPrefix  = "admin_retry_"
+	adminCatalogError = "❌ Каталог не перечитан, работает прежняя версия:\n%v"
+)
+
+// adminIDs читает ADMIN_IDS - Telegram ID администраторов через запятую
+func adminIDs() map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, part := range strings.Split(os.Getenv("ADMIN_IDS"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			log.Printf("admin: invalid id %q in ADMIN_IDS", part)
+			continue
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+// isAdmin проверяет, что пользователь указан в ADMIN_IDS
+func isAdmin(userID int64) bool {
+	return adminIDs()[userID]
+}
+
+// AdminKeyboard - кнопки меню администратора
+func AdminKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Перечитать каталог", "admin_reload"),
+			tgbotapi.NewInlineKeyboardButtonData("🩺 Проверить каталог", "admin_check"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📊 Статистика", "admin_stats"),
+			tgbotapi.NewInlineKeyboardButtonData("🧾 Последние заявки", "admin_leads"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📥 Выгрузка клиентов", "admin_export"),
+			tgbotapi.NewInlineKeyboardButtonData("📣 Рассылка", "admin_broadcast"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⚠️ Ошибки передачи в CRM", "admin_failed"),
+		),
+	)
+}
+
+// handleAdminCommand обрабатывает команды администратора; false - команда не административная
+func handleAdminCommand(bot tools.Sender, message *tgbotapi.Message) bool {
+	chatID := message.Chat.ID
+	switch message.Command() {
+	case "admin":
+		msg := tgbotapi.NewMessage(chatID, adminMenuText)
+		msg.ReplyMarkup = AdminKeyboard()
+		tools.SendAndLog(bot, msg)
+	case "leads":
+		// /leads 20 - последние 20 заявок
+		n, err := strconv.Atoi(strings.TrimSpace(message.CommandArguments()))
+		if err != nil || n <= 0 {
+			n = adminLastLeads
+		}
+		sendLastLeads(bot, chatID, min(n, adminMaxLeads))
+	case "export":
+		// /export 2024-05-01 2024-05-31 Мария - клиенты за период и/или по спикеру
+		filter, err := parseExportArgs(message.CommandArguments())
+		if err != nil {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()+"\n"+exportUsage))
+			break
+		}
+		sendUsersExport(bot, chatID, filter)
+	case "promo":
+		handlePromoCommand(bot, chatID, message.CommandArguments())
+	case "links":
+		for _, part := range tools.SplitMessage(startLinks(botUsername(bot))) {
+			msg := tgbotapi.NewMessage(chatID, part)
+			msg.DisableWebPagePreview = true
+			tools.SendAndLog(bot, msg)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// handleAdminCallback обрабатывает кнопки меню администратора
+func handleAdminCallback(bot tools.Sender, chatID int64, data string) {
+	switch {
+	case data == "admin_reload":
+		if err := reloadCatalog(bot); err != nil {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf(adminCatalogError, err)))
+			return
+		}
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Каталог перечитан: спикеров %d, курсов %d", len(Speakers), countCourses())))
+	case data == "admin_check":
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, catalogReport(catalogPath())))
+	case data == "admin_stats":
+		sendLeadStats(bot, chatID)
+	case data == "admin_leads":
+		sendLastLeads(bot, chatID, adminLastLeads)
+	case data == "admin_export":
+		sendUsersExport(bot, chatID, db.ExportFilter{})
+	case data == "admin_broadcast":
+		startBroadcast(bot, chatID)
+	case data == "admin_failed":
+		sendFailedSyncs(bot, chatID)
+	case strings.HasPrefix(data, adminRetryPrefix):
+		id, err := strconv.ParseInt(strings.TrimPrefix(data, adminRetryPrefix), 10, 64)
+		if err != nil {
+			return
+		}
+		if err := retryFailedSync(id); err != nil {
+			log.Printf("admin: retry of failed sync %d failed: %v", id, err)
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ Заявка не передана: %v", err)))
+			return
+		}
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "✅ Заявка передана в CRM"))
+	}
+}
+
+func countCourses() int {
+	n := 0
+	for _, s := range Speakers {
+		n += len(s.Courses)
+	}
+	return n
+}
+
+// catalogReport проверяет файл каталога без загрузки в бот: ошибки, из-за которых он не загрузится,
+// и предупреждения о недостающих файлах и датах
+func catalogReport(path string) string {
+	file, err := catalog.Read(path)
+	if err == nil {
+		err = file.Validate()
+	}
+	if err != nil {
+		return fmt.Sprintf("❌ Каталог %s не загрузится:\n%v", path, err)
+	}
+
+	courses, sessions := 0, 0
+	for _, s := range file.Speakers {
+		courses += len(s.Courses)
+		for _, c := range s.Courses {
+			sessions += len(c.Sessions)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "✅ Каталог %s: спикеров %d, курсов %d, сессий %d\n", path, len(file.Speakers), courses, sessions)
+	warnings := file.Warnings("data")
+	if len(warnings) == 0 {
+		b.WriteString("Замечаний нет.")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n⚠️ Замечания (%d):\n", len(warnings))
+	for i, w := range warnings {
+		if i == adminMaxWarnings {
+			fmt.Fprintf(&b, "…и ещё %d\n", len(warnings)-i)
+			break
+		}
+		fmt.Fprintf(&b, "• %s\n", w)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+var (
+	bookingStatusNames = map[string]string{
+		db.BookingPending:   "ждут подтверждения",
+		db.BookingConfirmed: "подтверждены",
+		db.BookingCancelled: "отменены",
+	}
+	funnelStepNames = map[string]string{
+		funnelSpeaker: "выбрали спикера",
+		funnelCourse:  "смотрят программу",
+		funnelBooking: "начали запись",
+		funnelDone:    "оставили номер",
+	}
+)
+
+// sendLeadStats отправляет сводку по клиентам, заявкам и воронке
+func sendLeadStats(bot tools.Sender, chatID int64) {
+	st, err := db.GetLeadStats(dbConn, time.Now())
+	if err != nil {
+		log.Printf("admin: failed to load stats: %v", err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось посчитать статистику."))
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Статистика\n\n")
+	fmt.Fprintf(&b, "👥 Пользователей: %d, с телефоном: %d, заблокировали бота: %d\n", st.Users, st.WithPhone, st.Blocked)
+	fmt.Fprintf(&b, "🧾 Заявок: сегодня %d, за 7 дней %d, за 30 дней %d, всего %d\n", st.LeadsToday, st.LeadsWeek, st.LeadsMonth, st.LeadsTotal)
+	for _, status := range []string{db.BookingConfirmed, db.BookingPending, db.BookingCancelled} {
+		if n := st.ByStatus[status]; n > 0 {
+			fmt.Fprintf(&b, "   %s: %d\n", bookingStatusNames[status], n)
+		}
+	}
+	if len(st.FunnelSteps) > 0 {
+		b.WriteString("\n🪜 Воронка:\n")
+		for _, step := range []string{funnelSpeaker, funnelCourse, funnelBooking, funnelDone} {
+			fmt.Fprintf(&b, "   %s: %d\n", funnelStepNames[step], st.FunnelSteps[step])
+		}
+	}
+	if len(st.Sources) > 1 || len(st.Sources) == 1 && st.Sources[0].Source != "" {
+		b.WriteString("\n📣 Источники (пользователи / с заявкой):\n")
+		for _, s := range st.Sources {
+			name := utmDisplayName(db.UTM{Source: s.Source, Campaign: s.Campaign})
+			if name == "" {
+				name = "без метки"
+			}
+			fmt.Fprintf(&b, "   %s: %d / %d\n", name, s.Users, s.Leads)
+		}
+	}
+	if st.FailedSyncs > 0 {
+		fmt.Fprintf(&b, "\n⚠️ Не переданы в CRM: %d", st.FailedSyncs)
+	}
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, strings.TrimRight(b.String(), "\n")))
+}
+
+// sendLastLeads отправляет последние n заявок с контактами
+func sendLastLeads(bot tools.Sender, chatID int64, n int) {
+	leads, err := db.GetLastLeads(dbConn, n)
+	if err != nil {
+		log.Printf("admin: failed to load leads: %v", err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось загрузить заявки."))
+		return
+	}
+	if len(leads) == 0 {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "Заявок пока нет."))
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🧾 Последние заявки (%d):\n", len(leads))
+	for _, l := range leads {
+		name := l.Fio
+		if name == "" {
+			name = "без имени"
+		}
+		fmt.Fprintf(&b, "\n<b>%s</b> %s\n%s\n%s, %s",
+			html.EscapeString(name), html.EscapeString(l.Phone), html.EscapeString(l.Session), l.Date, bookingStatusNames[l.Status])
+		if l.ItemID != "" {
+			fmt.Fprintf(&b, ", сделка %s", html.EscapeString(l.ItemID))
+		}
+		b.WriteString("\n")
+	}
+	for _, part := range tools.SplitHTMLMessage(b.String()) {
+		msg := tgbotapi.NewMessage(chatID, part)
+		msg.ParseMode = tgbotapi.ModeHTML
+		tools.SendAndLog(bot, msg)
+	}
+}
+
+// sendFailedSyncs показывает заявки, не переданные в CRM, с кнопками повторной отправки
+func sendFailedSyncs(bot tools.Sender, chatID int64) {
+	failures, err := db.GetFailedSyncs(dbConn)
+	if err != nil {
+		log.Printf("admin: failed to load sync errors: %v", err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось загрузить ошибки."))
+		return
+	}
+	if len(failures) == 0 {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, adminNoFailures))
+		return
+	}
+	for _, f := range failures {
+		var session bitrixSession
+		if err := json.Unmarshal([]byte(f.Session), &session); err != nil {
+			log.Printf("admin: bad session for chat %d: %v", f.ChatID, err)
+		}
+		name := session.ContactName
+		if name == "" {
+			name = "без имени"
+		}
+		text := fmt.Sprintf("⚠️ %s, %s\n%s\nЧат %d, %s\nОшибка: %s",
+			name, session.Phone, f.SessionKey, f.ChatID, f.Date, f.Error)
+		msg := tgbotapi.NewMessage(chatID, text)
+		// в кнопку помещается только номер записи: ключ сессии может не влезть в 64 байта callback data
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔁 Отправить повторно", adminRetryPrefix+strconv.FormatInt(f.ID, 10)),
+		))
+		tools.SendAndLog(bot, msg)
+	}
+}
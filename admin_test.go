@@ -0,0 +1,213 @@
+package main
+
+import (
+	"app/db"
+	"app/fakes"
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminMenuOnlyForAdmins(t *testing.T) {
+	const admin = 900
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+
+	HandleMessage(bot, messageUpdate(admin, "/admin"))
+	sent := tg.Sent(admin)
+	if len(sent) != 1 || sent[0].Text() != adminMenuText || !strings.Contains(sent[0].ReplyMarkup(), "admin_failed") {
+		t.Fatalf("admin menu = %+v", sent)
+	}
+
+	HandleMessage(bot, messageUpdate(555, "/admin"))
+	if got := sentTexts(tg, 555); len(got) != 1 || got[0] != greetingMessage {
+		t.Errorf("non-admin got %v", got)
+	}
+
+	// кнопки меню не работают у обычных пользователей
+	HandleCallback(bot, callbackUpdate(555, "admin_export"))
+	if got := tg.Sent(555); len(got) != 1 {
+		t.Errorf("non-admin callback sent %d messages", len(got))
+	}
+}
+
+func TestAdminStatsAndLeads(t *testing.T) {
+	const admin = 900
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+
+	if err := db.UpsertUser(dbConn, 101, "+79991234567", "Анна Смирнова", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveBooking(dbConn, 101, "Мария / Казань | 15 июля", "7", db.BookingConfirmed); err != nil {
+		t.Fatal(err)
+	}
+	trackFunnel(102, funnelBooking, "Мария", "Казань | 15 июля")
+
+	HandleCallback(bot, callbackUpdate(admin, "admin_stats"))
+	HandleMessage(bot, messageUpdate(admin, "/leads 5"))
+	HandleCallback(bot, callbackUpdate(admin, "admin_export"))
+
+	sent := tg.Sent(admin)
+	if len(sent) != 3 {
+		t.Fatalf("admin got %v", sentTexts(tg, admin))
+	}
+	stats := sent[0].Text()
+	for _, want := range []string{"Пользователей: 1, с телефоном: 1", "Заявок: сегодня 1", "подтверждены: 1", "начали запись: 1"} {
+		if !strings.Contains(stats, want) {
+			t.Errorf("stats %q does not contain %q", stats, want)
+		}
+	}
+	leads := sent[1].Text()
+	if !strings.Contains(leads, "Анна Смирнова") || !strings.Contains(leads, "Мария / Казань | 15 июля") || !strings.Contains(leads, "сделка 7") {
+		t.Errorf("leads = %q", leads)
+	}
+	if sent[2].Method != "sendDocument" || !strings.HasPrefix(sent[2].Files["document"], "clients-") {
+		t.Errorf("export = %+v", sent[2])
+	}
+}
+
+func TestAdminRetryFailedSync(t *testing.T) {
+	const (
+		admin  = 900
+		client = 42
+	)
+	useTestDB(t)
+	useDemoCatalog(t)
+	fake, bitrix := newTestBitrix(t)
+	useTestCRM(t, bitrix)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+	fake.Fail("crm.contact.list", fakes.BitrixFailure{Status: http.StatusUnauthorized, Error: "expired_token"})
+
+	setSessionCourse(client, "Мария", "Казань | 15 июля")
+	setSessionContact(client, "+79991234567", "Иван")
+	trySyncBitrixDeal(bot, client)
+	if n := len(fake.Items()); n != 0 {
+		t.Fatalf("items = %d, want 0", n)
+	}
+
+	HandleCallback(bot, callbackUpdate(admin, "admin_failed"))
+	sent := tg.Sent(admin)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text(), "Иван") || !strings.Contains(sent[0].ReplyMarkup(), "admin_retry_1") {
+		t.Fatalf("failed syncs = %+v", sent)
+	}
+
+	// клиент уже ушёл, но заявка переживает сброс состояния диалога
+	resetChatState()
+	HandleCallback(bot, callbackUpdate(admin, "admin_retry_1"))
+	if items := fake.Items(); len(items) != 1 || items[0].Title != "Telegram - Мария - Казань | 15 июля" {
+		t.Fatalf("items = %+v", items)
+	}
+	if got := sentTexts(tg, admin); !strings.Contains(got[len(got)-1], "передана в CRM") {
+		t.Errorf("retry reply = %v", got)
+	}
+
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(admin, "admin_failed"))
+	if got := sentTexts(tg, admin); len(got) != 1 || got[0] != adminNoFailures {
+		t.Errorf("failed syncs after retry = %v", got)
+	}
+}
+
+func TestFailedSyncsPerSession(t *testing.T) {
+	const client = 43
+	useTestDB(t)
+	useDemoCatalog(t)
+	fake, bitrix := newTestBitrix(t)
+	useTestCRM(t, bitrix)
+	_, bot := newTestTelegram(t)
+	expired := fakes.BitrixFailure{Status: http.StatusUnauthorized, Error: "expired_token"}
+	fake.Fail("crm.contact.list", expired, expired)
+
+	setSessionContact(client, "+79991234567", "Иван")
+	setSessionCourse(client, "Мария", "Казань | 15 июля")
+	trySyncBitrixDeal(bot, client)
+	setSessionCourse(client, "Иван", "Москва | 12 июня")
+	trySyncBitrixDeal(bot, client)
+
+	failures, err := db.GetFailedSyncs(dbConn)
+	if err != nil || len(failures) != 2 {
+		t.Fatalf("failures = %+v, %v", failures, err)
+	}
+
+	// успешная заявка на одну сессию не стирает ошибку другой
+	if err := retryFailedSync(2); err != nil {
+		t.Fatal(err)
+	}
+	failures, _ = db.GetFailedSyncs(dbConn)
+	if len(failures) != 1 || failures[0].ChatID != client || failures[0].SessionKey != "Мария / Казань | 15 июля" {
+		t.Errorf("failures after retry = %+v", failures)
+	}
+	if items := fake.Items(); len(items) != 1 || items[0].Title != "Telegram - Иван - Москва | 12 июня" {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestFailedSyncsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.db")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+        CREATE TABLE crm_failures (chat_id INTEGER PRIMARY KEY, session TEXT NOT NULL, error TEXT NOT NULL, date TEXT NOT NULL);
+        INSERT INTO crm_failures VALUES (42, '{"SpeakerName":"Мария","City":"Казань | 15 июля"}', 'timeout', '2024-07-01 10:00:00');
+    `)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	failures, err := db.GetFailedSyncs(conn)
+	if err != nil || len(failures) != 1 {
+		t.Fatalf("failures = %+v, %v", failures, err)
+	}
+	if f := failures[0]; f.ChatID != 42 || f.SessionKey != "Мария / Казань | 15 июля" || f.Error != "timeout" {
+		t.Errorf("migrated failure = %+v", f)
+	}
+	if err := db.DeleteFailedSync(conn, 42, "Мария / Казань | 15 июля"); err != nil {
+		t.Fatal(err)
+	}
+	if failures, _ := db.GetFailedSyncs(conn); len(failures) != 0 {
+		t.Errorf("failures after delete = %+v", failures)
+	}
+}
+
+func TestCatalogReport(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeCatalog(t, "courses.yaml", `speakers:
+  - name: Мария
+    courses:
+      - files: [Мария/program.pdf]
+        sessions:
+          - city: Казань
+            date: когда-нибудь
+`)
+	report := catalogReport("courses.yaml")
+	if !strings.Contains(report, "спикеров 1, курсов 1, сессий 1") ||
+		!strings.Contains(report, "нет файла программы Мария/program.pdf") ||
+		!strings.Contains(report, "дата не распознана") {
+		t.Errorf("report = %q", report)
+	}
+
+	writeCatalog(t, "courses.yaml", "speakers:\n  - name: Мария\n    courses:\n      - title: Без дат\n")
+	if report := catalogReport("courses.yaml"); !strings.Contains(report, "не загрузится") {
+		t.Errorf("report = %q", report)
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"unicode"
 
+	"app/db"
 	tools "app/handlers"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -31,35 +33,71 @@ const (
 
 	// Ответственный в Bitrix24 по умолчанию
 	bitrixAssignedUserID = 35
+
+	// Вебхуки Bitrix24 допускают около 2 запросов в секунду
+	bitrixRequestInterval = 500 * time.Millisecond
+
+	// Повторы при QUERY_LIMIT_EXCEEDED и ошибках 5xx
+	bitrixMaxAttempts  = 4
+	bitrixRetryBackoff = time.Second
+	bitrixHTTPTimeout  = 10 * time.Second
 )
 
+// bitrixCallTimeout - сколько может длиться один вызов Bitrix24 со всеми повторами:
+// каждая попытка до таймаута HTTP-клиента плюс задержки между попытками
+func bitrixCallTimeout() time.Duration {
+	total := time.Duration(bitrixMaxAttempts) * bitrixHTTPTimeout
+	for i, backoff := 1, bitrixRetryBackoff; i < bitrixMaxAttempts; i, backoff = i+1, backoff*2 {
+		total += backoff
+	}
+	return total
+}
+
 var (
 	// ленивое создание HTTP-клиента Bitrix24
 	bitrixClientOnce sync.Once
 	bitrixClientInst *BitrixClient
 	bitrixClientErr  error
 
-	// защита от повторной синхронизации одного и того же чата
-	bitrixSyncMu sync.Mutex
-	bitrixSynced = make(map[int64]bool)
+	// защита от повторной синхронизации одной и той же сессии курса чата: (chatID, сессия) -> ID элемента SPA.
+	// На другой курс тот же клиент получает отдельную сделку
+	bitrixSyncMu      sync.Mutex
+	bitrixSyncedItems = make(map[syncedItemKey]string)
 
 	// in-memory состояние чатов для накопления данных перед синком
 	chatStateMu sync.Mutex
 	chatStates  = make(map[int64]*bitrixSession)
 )
 
+// syncedItemKey - чат и сессия курса (sessionKey), по которой создана сделка
+type syncedItemKey struct {
+	chatID  int64
+	session string
+}
+
 // BitrixClient инкапсулирует базовый URL и HTTP-клиент для запросов к Bitrix24
 type BitrixClient struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL      string
+	httpClient   *http.Client
+	limiter      *bitrixRateLimiter // ограничение частоты запросов, nil - без ограничения
+	retryBackoff time.Duration      // задержка перед первым повтором запроса
 }
 
 // bitrixSession - временное состояние чата до синхронизации в Bitrix24
 type bitrixSession struct {
-	Phone       string // номер телефона клиента (в свободной форме, нормализуем позже)
-	SpeakerName string // имя спикера/курса (если есть)
-	City        string // город проведения (если есть)
-	ContactName string // имя контакта
+	Phone          string   // номер телефона клиента (в свободной форме, нормализуем позже)
+	SpeakerName    string   // имя спикера/курса (если есть)
+	City           string   // город проведения (если есть)
+	ContactName    string   // имя контакта
+	Program        string   // программа курса, которую смотрел клиент
+	PaymentViewed  bool     // открывал ли клиент "Как оплатить"
+	ClientMessages []string // сообщения клиента в свободной форме до синхронизации
+	UTM            db.UTM   // метки источника из ссылки /start
+	Referrer       string   // пригласивший клиент: имя, телефон и chat ID
+	PromoCode      string   // промокод, применённый клиентом
+	PromoDiscount  string   // скидка промокода для примечания: "−10%"
+	Price          int      // стоимость курса по каталогу в рублях, 0 - не указана
+	FinalPrice     int      // стоимость с учётом промокода
 }
 
 // getBitrixClient возвращает синглтон клиента Bitrix24, используя переменную окружения B24_BASE
@@ -73,31 +111,30 @@ func getBitrixClient() (*BitrixClient, error) {
 		bitrixClientInst = &BitrixClient{
 			baseURL: base,
 			httpClient: &http.Client{
-				Timeout: 10 * time.Second,
+				Timeout: bitrixHTTPTimeout,
 			},
+			limiter:      newBitrixRateLimiter(bitrixRequestInterval),
+			retryBackoff: bitrixRetryBackoff,
 		}
 	})
 	return bitrixClientInst, bitrixClientErr
 }
 
-// trySyncBitrixDeal пытается единожды синхронизировать контакт и элемент SPA в Bitrix24
+// trySyncBitrixDeal пытается единожды синхронизировать контакт и сделку в CRM (по умолчанию элемент SPA в Bitrix24)
 // когда накоплены необходимые данные в сессии: телефон и город.
-// Функция безопасна к повторным вызовам - второй раз для того же чата синхронизация не запускается.
-func trySyncBitrixDeal(bot *tgbotapi.BotAPI, chatID int64) {
-	// проверка на уже выполненный синк
-	bitrixSyncMu.Lock()
-	if bitrixSynced[chatID] {
-		bitrixSyncMu.Unlock()
-		return
-	}
-	bitrixSyncMu.Unlock()
-
+// Функция безопасна к повторным вызовам - второй раз для той же сессии курса синхронизация не запускается.
+func trySyncBitrixDeal(bot tools.Sender, chatID int64) {
 	// берём срез (snapshot) состояния
 	session := snapshotSession(chatID)
 	if session == nil {
 		return
 	}
 
+	// проверка на уже выполненный синк
+	if syncedBitrixItem(chatID, sessionKey(session.SpeakerName, session.City)) != "" {
+		return
+	}
+
 	phone := strings.TrimSpace(session.Phone)
 	courseCity := strings.TrimSpace(session.City)
 	if phone == "" || courseCity == "" {
@@ -105,30 +142,48 @@ func trySyncBitrixDeal(bot *tgbotapi.BotAPI, chatID int64) {
 		return
 	}
 
-	// нормализуем телефон до международного формата +7XXXXXXXXXX
+	// нормализуем телефон до формата E.164 (+79991234567)
 	formattedPhone, err := normalizePhone(phone)
 	if err != nil {
 		log.Printf("bitrix: phone normalization error (%s): %v", phone, err)
 		msg := tgbotapi.NewMessage(
 			chatID,
-			"Не удалось распознать номер телефона. Введите номер в международном формате, например +79991234567.",
+			fmt.Sprintf(
+				"Не удалось распознать номер телефона: %v.\nВведите номер в международном формате, например %s.",
+				err, phoneExample(),
+			),
 		)
 		tools.SendAndLog(bot, msg)
 		return
 	}
 
-	// инициализируем клиента Bitrix24
-	client, err := getBitrixClient()
+	// инициализируем CRM (по умолчанию Bitrix24)
+	crm, err := getCRM()
 	if err != nil {
-		log.Printf("bitrix: init error: %v", err)
+		log.Printf("crm: init error: %v", err)
+		saveFailedSync(chatID, session, err)
 		msg := tgbotapi.NewMessage(
 			chatID,
-			"Не удалось подключиться к Bitrix24. Попробуйте позже.",
+			"Не удалось подключиться к CRM. Попробуйте позже.",
 		)
 		tools.SendAndLog(bot, msg)
 		return
 	}
 
+	if err := syncSession(chatID, session, crm, formattedPhone); err != nil {
+		log.Printf("crm: sync error for chat %d: %v", chatID, err)
+		saveFailedSync(chatID, session, err)
+		msg := tgbotapi.NewMessage(
+			chatID,
+			"Не удалось передать заявку в CRM. Попробуйте позже.",
+		)
+		tools.SendAndLog(bot, msg)
+		return
+	}
+}
+
+// syncSession создаёт в CRM контакт и сделку по состоянию диалога и помечает чат как синхронизированный
+func syncSession(chatID int64, session *bitrixSession, crm CRM, formattedPhone string) error {
 	contactName := strings.TrimSpace(session.ContactName)
 	if contactName == "" {
 		contactName = "Пользователь Telegram"
@@ -136,27 +191,188 @@ func trySyncBitrixDeal(bot *tgbotapi.BotAPI, chatID int64) {
 
 	courseTitle := buildCourseTitle(session)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// метки хранятся в базе с первого /start, поэтому переживают и перезапуск, и повтор из crm_failures
+	if session.UTM.IsZero() {
+		utm, err := db.GetUserUTM(dbConn, chatID)
+		if err != nil {
+			log.Printf("crm: failed to load utm for chat %d: %v", chatID, err)
+		}
+		session.UTM = utm
+	}
+	if session.Referrer == "" {
+		session.Referrer = referrerDisplayName(chatID)
+	}
+	sessionPricing(chatID, session)
+
+	// поиск контакта и batch-запрос - два вызова, каждый со своими повторами
+	ctx, cancel := context.WithTimeout(context.Background(), 2*bitrixCallTimeout())
 	defer cancel()
 
-	// создаём/находим контакт и создаём элемент SPA
-	contactID, itemID, err := client.syncDeal(ctx, formattedPhone, contactName, courseTitle)
+	// создаём/находим контакт, создаём сделку и примечание с контекстом диалога
+	contactID, itemID, err := syncCRMDeal(
+		ctx,
+		crm,
+		CRMContact{Name: contactName, Phone: formattedPhone},
+		CRMDeal{
+			Title:      courseTitle,
+			UTM:        session.UTM,
+			Referrer:   session.Referrer,
+			PromoCode:  session.PromoCode,
+			Price:      session.Price,
+			FinalPrice: session.FinalPrice,
+		},
+		buildTimelineComment(session),
+	)
 	if err != nil {
-		log.Printf("bitrix: sync error for chat %d: %v", chatID, err)
-		msg := tgbotapi.NewMessage(
-			chatID,
-			"Не удалось синхронизировать данные с Bitrix24. Попробуйте позже.",
-		)
-		tools.SendAndLog(bot, msg)
-		return
+		return err
 	}
 
 	// помечаем чат как синхронизированный
+	key := sessionKey(session.SpeakerName, session.City)
 	bitrixSyncMu.Lock()
-	bitrixSynced[chatID] = true
+	bitrixSyncedItems[syncedItemKey{chatID, key}] = itemID
 	bitrixSyncMu.Unlock()
 
-	log.Printf("bitrix: synced contact %s and item %s for chat %d", contactID, itemID, chatID)
+	log.Printf("crm: synced contact %s and deal %s for chat %d", contactID, itemID, chatID)
+
+	recordBooking(chatID, session, itemID, crm)
+	markPaymentsInCRM(crm, chatID, key, itemID)
+	if session.PromoCode != "" {
+		if err := db.UsePromoCode(dbConn, session.PromoCode, chatID); err != nil {
+			log.Printf("promo: failed to count use of %s by chat %d: %v", session.PromoCode, chatID, err)
+		}
+	}
+	if err := db.DeleteFailedSync(dbConn, chatID, key); err != nil {
+		log.Printf("crm: failed to clear sync error for chat %d: %v", chatID, err)
+	}
+
+	// связь элемента с чатом нужна, чтобы уведомлять клиента о смене стадии в Bitrix24
+	if _, ok := crm.(*BitrixClient); ok {
+		if err := db.SaveBitrixItem(dbConn, itemID, chatID); err != nil {
+			log.Printf("bitrix: failed to save item %s for chat %d: %v", itemID, chatID, err)
+		}
+	}
+	return nil
+}
+
+// appendBitrixClientMessage сохраняет сообщение клиента в сессии, а если сделка в CRM по выбранному курсу
+// уже создана, добавляет сообщение примечанием к ней
+func appendBitrixClientMessage(chatID int64, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	if !addSyncedNote(chatID, "Сообщение клиента:\n"+text) {
+		addSessionMessage(chatID, text)
+	}
+}
+
+// addSyncedNote добавляет примечание к сделке по выбранному в диалоге курсу.
+// false - сделки ещё нет, контекст попадёт в комментарий при её создании
+func addSyncedNote(chatID int64, note string) bool {
+	itemID := ""
+	if session := snapshotSession(chatID); session != nil {
+		itemID = syncedBitrixItem(chatID, sessionKey(session.SpeakerName, session.City))
+	}
+	if itemID == "" {
+		return false
+	}
+
+	crm, err := getCRM()
+	if err != nil {
+		log.Printf("crm: init error: %v", err)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bitrixCallTimeout())
+	defer cancel()
+
+	if err := crm.AddNote(ctx, itemID, note); err != nil {
+		log.Printf("crm: add note error for deal %s: %v", itemID, err)
+	}
+	return true
+}
+
+// syncedBitrixItem возвращает ID элемента SPA, созданного для сессии курса чата, или пустую строку
+func syncedBitrixItem(chatID int64, session string) string {
+	bitrixSyncMu.Lock()
+	defer bitrixSyncMu.Unlock()
+	return bitrixSyncedItems[syncedItemKey{chatID, session}]
+}
+
+// chatSyncedBitrix сообщает, есть ли у чата хотя бы одна сделка, созданная ботом
+func chatSyncedBitrix(chatID int64) bool {
+	bitrixSyncMu.Lock()
+	defer bitrixSyncMu.Unlock()
+	for key := range bitrixSyncedItems {
+		if key.chatID == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTimelineComment собирает комментарий для таймлайна с контекстом диалога клиента
+func buildTimelineComment(session *bitrixSession) string {
+	var b strings.Builder
+	b.WriteString("Заявка из Telegram-бота\n")
+
+	speaker := strings.TrimSpace(session.SpeakerName)
+	if speaker == "" {
+		speaker = "не указан"
+	}
+	fmt.Fprintf(&b, "Спикер: %s\n", speaker)
+
+	city := strings.TrimSpace(session.City)
+	if city == "" {
+		city = "не указан"
+	}
+	fmt.Fprintf(&b, "Город | Дата: %s\n", city)
+	fmt.Fprintf(&b, "Программа: %s\n", programDisplayName(session.Program))
+
+	paymentViewed := "нет"
+	if session.PaymentViewed {
+		paymentViewed = "да"
+	}
+	fmt.Fprintf(&b, "Открывал «Как оплатить»: %s\n", paymentViewed)
+	if source := utmDisplayName(session.UTM); source != "" {
+		fmt.Fprintf(&b, "Источник: %s\n", source)
+	}
+	if session.Referrer != "" {
+		fmt.Fprintf(&b, "Пригласил: %s\n", session.Referrer)
+	}
+	if session.PromoCode != "" {
+		fmt.Fprintf(&b, "Промокод: %s (%s)\n", session.PromoCode, session.PromoDiscount)
+	}
+	switch {
+	case session.Price > 0 && session.FinalPrice != session.Price:
+		fmt.Fprintf(&b, "Стоимость: %s → %s\n", formatPrice(session.Price), formatPrice(session.FinalPrice))
+	case session.Price > 0:
+		fmt.Fprintf(&b, "Стоимость: %s\n", formatPrice(session.Price))
+	}
+
+	if len(session.ClientMessages) > 0 {
+		b.WriteString("\nСообщения клиента:\n")
+		for _, m := range session.ClientMessages {
+			fmt.Fprintf(&b, "- %s\n", m)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// programDisplayName возвращает имя файла программы или пометку о текстовом описании
+func programDisplayName(program string) string {
+	program = strings.TrimSpace(program)
+	switch {
+	case program == "":
+		return "не отправлялась"
+	case filepath.Ext(program) == "":
+		return "текстовое описание"
+	default:
+		return path.Base(strings.ReplaceAll(program, "\\", "/"))
+	}
 }
 
 // buildCourseTitle собирает заголовок курса из спикера и города
@@ -175,47 +391,111 @@ func buildCourseTitle(session *bitrixSession) string {
 	}
 }
 
-// normalizePhone приводит произвольный ввод в международный формат с префиксом "+"
-// Поддерживает кейсы: 10 цифр -> добавляем 7, 11 цифр с 8 -> заменяем на 7.
-// Возвращает ошибку при недостаточной длине.
-func normalizePhone(raw string) (string, error) {
-	var digits []rune
-	for _, r := range raw {
-		if unicode.IsDigit(r) {
-			digits = append(digits, r)
-		}
+// FindOrCreateContact реализует CRM
+func (c *BitrixClient) FindOrCreateContact(ctx context.Context, contact CRMContact) (string, error) {
+	return c.findOrCreateContact(ctx, contact.Phone, contact.Name)
+}
+
+// CreateDeal реализует CRM: создаёт элемент смарт-процесса
+func (c *BitrixClient) CreateDeal(ctx context.Context, contactID string, deal CRMDeal) (string, error) {
+	return c.createSpaItem(ctx, contactID, deal)
+}
+
+// AddNote реализует CRM: добавляет комментарий в таймлайн элемента
+func (c *BitrixClient) AddNote(ctx context.Context, dealID, text string) error {
+	return c.addTimelineComment(ctx, dealID, text)
+}
+
+// MarkPaid реализует crmPaymentMarker: переводит элемент на стадию B24_PAID_STAGE, если она задана
+func (c *BitrixClient) MarkPaid(ctx context.Context, dealID string) error {
+	stage := strings.TrimSpace(os.Getenv("B24_PAID_STAGE"))
+	if stage == "" {
+		return nil
 	}
-	if len(digits) == 0 {
-		return "", errors.New("phone has no digits")
+	payload := map[string]any{
+		"entityTypeId": bitrixSpaEntityTypeID,
+		"id":           dealID,
+		"fields":       map[string]any{"stageId": stage},
 	}
+	return c.post(ctx, "crm.item.update", payload, nil)
+}
 
-	value := string(digits)
-	switch len(value) {
-	case 10:
-		value = "7" + value
-	case 11:
-		if value[0] == '8' {
-			value = "7" + value[1:]
-		}
-	default:
-		if len(value) < 10 {
-			return "", fmt.Errorf("phone length %d too short", len(value))
+// SyncDeal реализует crmDealSyncer через batch-запрос
+func (c *BitrixClient) SyncDeal(ctx context.Context, contact CRMContact, deal CRMDeal, note string) (string, string, error) {
+	return c.syncDeal(ctx, contact.Phone, contact.Name, deal, note)
+}
+
+// syncDeal выполняет полный цикл: поиск/создание контакта, создание элемента смарт-процесса
+// и комментария в его таймлайне. Всё, кроме поиска контакта, отправляется одним batch-запросом.
+func (c *BitrixClient) syncDeal(ctx context.Context, phone, name string, deal CRMDeal, comment string) (string, string, error) {
+	contactID, err := c.findContact(ctx, phone)
+	if err != nil {
+		return "", "", err
+	}
+
+	var cmds []bitrixBatchCmd
+	var contactRef any = bitrixRef("$result[contact]")
+	if contactID == "" {
+		cmds = append(cmds, bitrixBatchCmd{
+			Name:   "contact",
+			Method: "crm.contact.add",
+			Params: map[string]any{"fields": bitrixContactFields(phone, name)},
+		})
+	} else {
+		idInt, err := strconv.Atoi(contactID)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid contact id %s: %w", contactID, err)
 		}
+		contactRef = idInt
 	}
 
-	return "+" + value, nil
-}
+	cmds = append(cmds, bitrixBatchCmd{
+		Name:   "item",
+		Method: "crm.item.add",
+		Params: map[string]any{
+			"entityTypeId": bitrixSpaEntityTypeID,
+			"fields":       bitrixSpaItemFields(contactRef, deal),
+		},
+	})
+	if comment != "" {
+		cmds = append(cmds, bitrixBatchCmd{
+			Name:   "comment",
+			Method: "crm.timeline.comment.add",
+			Params: map[string]any{"fields": bitrixCommentFields(bitrixRef("$result[item][item][id]"), comment)},
+		})
+	}
 
-// syncDeal выполняет полный цикл: поиск/создание контакта и создание элемента смарт-процесса
-func (c *BitrixClient) syncDeal(ctx context.Context, phone, name, courseTitle string) (string, string, error) {
-	contactID, err := c.findOrCreateContact(ctx, phone, name)
+	results, cmdErrs, err := c.batch(ctx, cmds)
 	if err != nil {
 		return "", "", err
 	}
+	for _, name := range []string{"contact", "item"} {
+		if cmdErr, ok := cmdErrs[name]; ok {
+			return "", "", fmt.Errorf("batch %s: %w", name, cmdErr)
+		}
+	}
+	// комментарий не критичен для сделки - ошибку только логируем
+	if cmdErr, ok := cmdErrs["comment"]; ok {
+		log.Printf("bitrix: timeline comment error: %v", cmdErr)
+	}
 
-	itemID, err := c.createSpaItem(ctx, contactID, courseTitle)
+	if contactID == "" {
+		if contactID, err = parseBitrixID(results["contact"]); err != nil {
+			return "", "", fmt.Errorf("unexpected contact add result: %w", err)
+		}
+	}
+
+	var item struct {
+		Item struct {
+			ID json.RawMessage `json:"id"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal(results["item"], &item); err != nil {
+		return "", "", fmt.Errorf("unexpected item add result: %w", err)
+	}
+	itemID, err := parseBitrixID(item.Item.ID)
 	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("unexpected item id type: %w", err)
 	}
 
 	return contactID, itemID, nil
@@ -259,41 +539,25 @@ func (c *BitrixClient) findContact(ctx context.Context, phone string) (string, e
 // createContact создаёт новый контакт в Bitrix24
 func (c *BitrixClient) createContact(ctx context.Context, phone, name string) (string, error) {
 	payload := map[string]any{
-		"fields": map[string]any{
-			"NAME":               name,
-			"OPENED":             "Y",
-			"SOURCE_ID":          bitrixSourceID,
-			"SOURCE_DESCRIPTION": bitrixSourceDesc,
-			"ASSIGNED_BY_ID":     bitrixAssignedUserID,
-			"PHONE": []map[string]string{
-				{
-					"VALUE":      phone,
-					"VALUE_TYPE": "WORK",
-				},
-			},
-		},
+		"fields": bitrixContactFields(phone, name),
 	}
 
 	var response struct {
-		Result any `json:"result"`
+		Result json.RawMessage `json:"result"`
 	}
 	if err := c.post(ctx, "crm.contact.add", payload, &response); err != nil {
 		return "", err
 	}
 
-	switch v := response.Result.(type) {
-	case float64:
-		return strconv.Itoa(int(v)), nil
-	case string:
-		return v, nil
-	default:
-		raw, _ := json.Marshal(response.Result)
-		return "", fmt.Errorf("unexpected contact add result: %s", raw)
+	id, err := parseBitrixID(response.Result)
+	if err != nil {
+		return "", fmt.Errorf("unexpected contact add result: %w", err)
 	}
+	return id, nil
 }
 
 // createSpaItem создаёт элемент смарт-процесса (SPA) и привязывает к нему контакт
-func (c *BitrixClient) createSpaItem(ctx context.Context, contactID, courseTitle string) (string, error) {
+func (c *BitrixClient) createSpaItem(ctx context.Context, contactID string, deal CRMDeal) (string, error) {
 	idInt, err := strconv.Atoi(contactID)
 	if err != nil {
 		return "", fmt.Errorf("invalid contact id %s: %w", contactID, err)
@@ -301,21 +565,13 @@ func (c *BitrixClient) createSpaItem(ctx context.Context, contactID, courseTitle
 
 	payload := map[string]any{
 		"entityTypeId": bitrixSpaEntityTypeID,
-		"fields": map[string]any{
-			// В заголовке избегаем длинного тире, используем короткий дефис
-			"title":             fmt.Sprintf("Telegram - %s", courseTitle),
-			"opened":            "Y",
-			"contactIds":        []int{idInt},
-			"sourceId":          bitrixSourceID,
-			"sourceDescription": bitrixSourceDesc,
-			"assignedById":      bitrixAssignedUserID,
-		},
+		"fields":       bitrixSpaItemFields(idInt, deal),
 	}
 
 	var response struct {
 		Result struct {
 			Item struct {
-				ID any `json:"id"`
+				ID json.RawMessage `json:"id"`
 			} `json:"item"`
 		} `json:"result"`
 	}
@@ -324,24 +580,160 @@ func (c *BitrixClient) createSpaItem(ctx context.Context, contactID, courseTitle
 		return "", err
 	}
 
-	switch v := response.Result.Item.ID.(type) {
+	id, err := parseBitrixID(response.Result.Item.ID)
+	if err != nil {
+		return "", fmt.Errorf("unexpected item id type: %w", err)
+	}
+	return id, nil
+}
+
+// addTimelineComment добавляет комментарий в таймлайн элемента смарт-процесса
+func (c *BitrixClient) addTimelineComment(ctx context.Context, itemID, comment string) error {
+	payload := map[string]any{
+		"fields": bitrixCommentFields(itemID, comment),
+	}
+
+	return c.post(ctx, "crm.timeline.comment.add", payload, nil)
+}
+
+// bitrixContactFields поля нового контакта для crm.contact.add
+func bitrixContactFields(phone, name string) map[string]any {
+	return map[string]any{
+		"NAME":               name,
+		"OPENED":             "Y",
+		"SOURCE_ID":          bitrixSourceID,
+		"SOURCE_DESCRIPTION": bitrixSourceDesc,
+		"ASSIGNED_BY_ID":     bitrixAssignedUserID,
+		"PHONE": []map[string]string{
+			{
+				"VALUE":      phone,
+				"VALUE_TYPE": "WORK",
+			},
+		},
+	}
+}
+
+// bitrixSpaItemFields поля элемента смарт-процесса для crm.item.add.
+// contactID - число или ссылка на результат предыдущей команды batch
+func bitrixSpaItemFields(contactID any, deal CRMDeal) map[string]any {
+	fields := map[string]any{
+		// В заголовке избегаем длинного тире, используем короткий дефис
+		"title":             fmt.Sprintf("Telegram - %s", deal.Title),
+		"opened":            "Y",
+		"contactIds":        []any{contactID},
+		"sourceId":          bitrixSourceID,
+		"sourceDescription": bitrixSourceDesc,
+		"assignedById":      bitrixAssignedUserID,
+	}
+	// стандартные UTM-поля элемента, пустые не передаём
+	for field, value := range map[string]string{
+		"utmSource":   deal.UTM.Source,
+		"utmMedium":   deal.UTM.Medium,
+		"utmCampaign": deal.UTM.Campaign,
+		"utmContent":  deal.UTM.Content,
+	} {
+		if value != "" {
+			fields[field] = value
+		}
+	}
+	// сумма сделки - цена курса с учётом промокода
+	if deal.FinalPrice > 0 {
+		fields["opportunity"] = deal.FinalPrice
+		fields["currencyId"] = "RUB"
+	}
+	if field := strings.TrimSpace(os.Getenv("B24_PROMO_FIELD")); field != "" && deal.PromoCode != "" {
+		fields[field] = deal.PromoCode
+	}
+	// для пригласившего в смарт-процессе нет стандартного поля - можно завести пользовательское
+	if field := strings.TrimSpace(os.Getenv("B24_REFERRER_FIELD")); field != "" && deal.Referrer != "" {
+		fields[field] = deal.Referrer
+	}
+	return fields
+}
+
+// bitrixCommentFields поля комментария таймлайна для crm.timeline.comment.add
+func bitrixCommentFields(itemID any, comment string) map[string]any {
+	return map[string]any{
+		"ENTITY_ID":   itemID,
+		"ENTITY_TYPE": fmt.Sprintf("dynamic_%d", bitrixSpaEntityTypeID),
+		"COMMENT":     comment,
+	}
+}
+
+// parseBitrixID разбирает ID сущности, который Bitrix24 возвращает то числом, то строкой
+func parseBitrixID(raw json.RawMessage) (string, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch id := v.(type) {
 	case float64:
-		return strconv.Itoa(int(v)), nil
+		return strconv.Itoa(int(id)), nil
 	case string:
-		return v, nil
-	default:
-		raw, _ := json.Marshal(response.Result.Item.ID)
-		return "", fmt.Errorf("unexpected item id type: %s", raw)
+		if id != "" {
+			return id, nil
+		}
 	}
+	return "", fmt.Errorf("unexpected id %s", raw)
 }
 
-// post выполняет POST-запрос к REST-методу Bitrix24, обрабатывает HTTP и бизнес-ошибки, распаковывает ответ в out
+// getItemStage возвращает текущую стадию элемента смарт-процесса
+func (c *BitrixClient) getItemStage(ctx context.Context, itemID string) (string, error) {
+	payload := map[string]any{
+		"entityTypeId": bitrixSpaEntityTypeID,
+		"id":           itemID,
+	}
+
+	var response struct {
+		Result struct {
+			Item struct {
+				StageID string `json:"stageId"`
+			} `json:"item"`
+		} `json:"result"`
+	}
+
+	if err := c.post(ctx, "crm.item.get", payload, &response); err != nil {
+		return "", err
+	}
+	return response.Result.Item.StageID, nil
+}
+
+// post выполняет POST-запрос к REST-методу Bitrix24 с учётом лимита запросов.
+// При QUERY_LIMIT_EXCEEDED и ошибках 5xx идемпотентных методов запрос повторяется с экспоненциальной задержкой.
 func (c *BitrixClient) post(ctx context.Context, endpoint string, payload any, out any) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		err = c.do(ctx, endpoint, body, out)
+		var apiErr *bitrixError
+		if err == nil || !errors.As(err, &apiErr) || !apiErr.retryable(endpoint) || attempt >= bitrixMaxAttempts {
+			return err
+		}
+
+		log.Printf("bitrix: %s failed (%v), retry %d in %s", endpoint, err, attempt, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// do выполняет один POST-запрос, обрабатывает HTTP и бизнес-ошибки, распаковывает ответ в out
+func (c *BitrixClient) do(ctx context.Context, endpoint string, body []byte, out any) error {
 	url := c.baseURL + "/" + strings.TrimLeft(endpoint, "/")
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
@@ -360,19 +752,21 @@ func (c *BitrixClient) post(ctx context.Context, endpoint string, payload any, o
 		return err
 	}
 
-	if resp.StatusCode >= http.StatusBadRequest {
-		log.Printf("bitrix: http %d response: %s. request: %s", resp.StatusCode, string(respBody), string(body))
-		return fmt.Errorf("bitrix http %d", resp.StatusCode)
-	}
-
 	// обработка ошибок формата {"error": "...", "error_description": "..."}
 	var apiErr struct {
 		Error            string `json:"error"`
 		ErrorDescription string `json:"error_description"`
 	}
-	if err := json.Unmarshal(respBody, &apiErr); err == nil && apiErr.Error != "" {
+	_ = json.Unmarshal(respBody, &apiErr)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Printf("bitrix: http %d response: %s. request: %s", resp.StatusCode, string(respBody), string(body))
+		return &bitrixError{Status: resp.StatusCode, Code: apiErr.Error, Description: apiErr.ErrorDescription}
+	}
+
+	if apiErr.Error != "" {
 		log.Printf("bitrix: api error %s (%s). request: %s", apiErr.Error, apiErr.ErrorDescription, string(body))
-		return fmt.Errorf("%s: %s", apiErr.Error, apiErr.ErrorDescription)
+		return &bitrixError{Status: resp.StatusCode, Code: apiErr.Error, Description: apiErr.ErrorDescription}
 	}
 
 	if out != nil {
@@ -392,6 +786,7 @@ func snapshotSession(chatID int64) *bitrixSession {
 		return nil
 	}
 	copy := *state
+	copy.ClientMessages = append([]string(nil), state.ClientMessages...)
 	return &copy
 }
 
@@ -421,7 +816,12 @@ func setSessionContact(chatID int64, phone, contactName string) {
 
 // setSessionCourse записывает данные о курсе/событии в сессию чата
 func setSessionCourse(chatID int64, speaker, city string) {
+	released := ""
 	updateSession(chatID, func(s *bitrixSession) {
+		// промокод проверялся для прежнего курса
+		if speaker != "" && speaker != s.SpeakerName || city != "" && city != s.City {
+			released, s.PromoCode = s.PromoCode, ""
+		}
 		if speaker != "" {
 			s.SpeakerName = speaker
 		}
@@ -429,4 +829,40 @@ func setSessionCourse(chatID int64, speaker, city string) {
 			s.City = city
 		}
 	})
+	if released != "" {
+		releasePromoCode(chatID, released)
+	}
+}
+
+// setSessionPromo запоминает промокод, применённый к выбранному курсу
+func setSessionPromo(chatID int64, code string) {
+	updateSession(chatID, func(s *bitrixSession) {
+		s.PromoCode = code
+	})
+}
+
+// setSessionProgram записывает программу курса, которую получил клиент
+func setSessionProgram(chatID int64, program string) {
+	updateSession(chatID, func(s *bitrixSession) {
+		s.Program = program
+	})
+}
+
+// setSessionPaymentViewed отмечает, что клиент открывал "Как оплатить". Если сделка уже создана,
+// менеджер узнаёт об этом из примечания: комментарий с контекстом диалога написан раньше
+func setSessionPaymentViewed(chatID int64) {
+	viewed := false
+	updateSession(chatID, func(s *bitrixSession) {
+		viewed, s.PaymentViewed = s.PaymentViewed, true
+	})
+	if !viewed {
+		addSyncedNote(chatID, "Клиент открыл «Как оплатить»")
+	}
+}
+
+// addSessionMessage добавляет сообщение клиента в сессию чата
+func addSessionMessage(chatID int64, text string) {
+	updateSession(chatID, func(s *bitrixSession) {
+		s.ClientMessages = append(s.ClientMessages, text)
+	})
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Код ошибки Bitrix24 при превышении лимита запросов
+const bitrixQueryLimitExceeded = "QUERY_LIMIT_EXCEEDED"
+
+// bitrixError - HTTP или бизнес-ошибка REST API Bitrix24
+type bitrixError struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *bitrixError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("bitrix http %d", e.Status)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// retryable сообщает, имеет ли смысл повторить запрос к endpoint. QUERY_LIMIT_EXCEEDED отклоняется
+// до выполнения и повторяется всегда. После 5xx запрос мог выполниться на стороне Bitrix24, поэтому
+// повторяются только идемпотентные методы: повтор batch или *.add создал бы дубли контакта и сделки
+func (e *bitrixError) retryable(endpoint string) bool {
+	if e.Code == bitrixQueryLimitExceeded {
+		return true
+	}
+	return e.Status >= http.StatusInternalServerError && bitrixIdempotent(endpoint)
+}
+
+// bitrixIdempotent - можно ли безопасно повторить метод, если неизвестно, выполнился ли он
+func bitrixIdempotent(endpoint string) bool {
+	return endpoint != "batch" && !strings.HasSuffix(endpoint, ".add")
+}
+
+// bitrixRateLimiter равномерно распределяет запросы: не чаще одного за interval
+type bitrixRateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newBitrixRateLimiter(interval time.Duration) *bitrixRateLimiter {
+	return &bitrixRateLimiter{interval: interval}
+}
+
+// wait резервирует слот для запроса и ждёт его наступления
+func (l *bitrixRateLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// bitrixRef - ссылка на результат предыдущей команды batch, например $result[item][item][id].
+// Передаётся в запрос без URL-кодирования, иначе Bitrix24 не выполнит подстановку.
+type bitrixRef string
+
+// bitrixBatchCmd - одна команда пакетного запроса
+type bitrixBatchCmd struct {
+	Name   string
+	Method string
+	Params map[string]any
+}
+
+// bitrixBatchCmds сериализуется в JSON-объект с сохранением порядка команд:
+// Bitrix24 выполняет команды в порядке ключей
+type bitrixBatchCmds []bitrixBatchCmd
+
+func (cmds bitrixBatchCmds) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, cmd := range cmds {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(cmd.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(cmd.Method + "?" + encodeBitrixParams(cmd.Params))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// batch выполняет несколько команд за один запрос. Возвращает результаты и ошибки по именам команд;
+// ошибка третьим значением означает, что сам пакетный запрос не выполнен
+func (c *BitrixClient) batch(ctx context.Context, cmds []bitrixBatchCmd) (map[string]json.RawMessage, map[string]error, error) {
+	payload := map[string]any{
+		"halt": 0,
+		"cmd":  bitrixBatchCmds(cmds),
+	}
+
+	var response struct {
+		Result struct {
+			Result      json.RawMessage `json:"result"`
+			ResultError json.RawMessage `json:"result_error"`
+		} `json:"result"`
+	}
+	if err := c.post(ctx, "batch", payload, &response); err != nil {
+		return nil, nil, err
+	}
+
+	// пустые коллекции PHP отдаёт массивом [], заполненные - объектом
+	results := make(map[string]json.RawMessage)
+	if raw := response.Result.Result; len(raw) > 0 && raw[0] == '{' {
+		if err := json.Unmarshal(raw, &results); err != nil {
+			return nil, nil, fmt.Errorf("unexpected batch result: %w", err)
+		}
+	}
+
+	cmdErrs := make(map[string]error)
+	if raw := response.Result.ResultError; len(raw) > 0 && raw[0] == '{' {
+		var apiErrs map[string]struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if err := json.Unmarshal(raw, &apiErrs); err != nil {
+			return nil, nil, fmt.Errorf("unexpected batch errors: %w", err)
+		}
+		for name, e := range apiErrs {
+			cmdErrs[name] = &bitrixError{Status: http.StatusOK, Code: e.Error, Description: e.ErrorDescription}
+		}
+	}
+
+	return results, cmdErrs, nil
+}
+
+// encodeBitrixParams кодирует параметры в строку запроса в формате PHP http_build_query
+func encodeBitrixParams(params map[string]any) string {
+	var parts []string
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = appendBitrixParam(parts, url.QueryEscape(k), params[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+func appendBitrixParam(parts []string, key string, value any) []string {
+	switch v := value.(type) {
+	case bitrixRef:
+		return append(parts, key+"="+string(v))
+	case string:
+		return append(parts, key+"="+url.QueryEscape(v))
+	case int:
+		return append(parts, key+"="+strconv.Itoa(v))
+	case int64:
+		return append(parts, key+"="+strconv.FormatInt(v, 10))
+	case float64:
+		return append(parts, key+"="+strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return append(parts, key+"=Y")
+		}
+		return append(parts, key+"=N")
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			parts = appendBitrixParam(parts, key+"["+url.QueryEscape(k)+"]", v[k])
+		}
+		return parts
+	case map[string]string:
+		converted := make(map[string]any, len(v))
+		for k, s := range v {
+			converted[k] = s
+		}
+		return appendBitrixParam(parts, key, converted)
+	case []any:
+		for i, item := range v {
+			parts = appendBitrixParam(parts, key+"["+strconv.Itoa(i)+"]", item)
+		}
+		return parts
+	case []string:
+		for i, item := range v {
+			parts = appendBitrixParam(parts, key+"["+strconv.Itoa(i)+"]", item)
+		}
+		return parts
+	case []int:
+		for i, item := range v {
+			parts = appendBitrixParam(parts, key+"["+strconv.Itoa(i)+"]", item)
+		}
+		return parts
+	case []map[string]string:
+		for i, item := range v {
+			parts = appendBitrixParam(parts, key+"["+strconv.Itoa(i)+"]", item)
+		}
+		return parts
+	default:
+		return append(parts, key+"="+url.QueryEscape(fmt.Sprint(v)))
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"app/db"
+	"app/fakes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSyncDealCreatesContactItemAndComment(t *testing.T) {
+	fake, client := newTestBitrix(t)
+
+	contactID, itemID, err := client.syncDeal(context.Background(), "+79991234567", "Иван", CRMDeal{Title: "Мария - Казань"}, "Спикер: Мария")
+	if err != nil {
+		t.Fatalf("syncDeal: %v", err)
+	}
+
+	contacts := fake.Contacts()
+	if len(contacts) != 1 || contacts[0].Phone != "+79991234567" || contacts[0].Name != "Иван" {
+		t.Fatalf("contacts = %+v", contacts)
+	}
+	if contactID != strconv.Itoa(contacts[0].ID) {
+		t.Errorf("contactID = %s, want %d", contactID, contacts[0].ID)
+	}
+
+	items := fake.Items()
+	if len(items) != 1 {
+		t.Fatalf("items = %+v", items)
+	}
+	item := items[0]
+	if itemID != strconv.Itoa(item.ID) {
+		t.Errorf("itemID = %s, want %d", itemID, item.ID)
+	}
+	if item.EntityTypeID != bitrixSpaEntityTypeID || item.Title != "Telegram - Мария - Казань" {
+		t.Errorf("item = %+v", item)
+	}
+	if len(item.ContactIDs) != 1 || item.ContactIDs[0] != contacts[0].ID {
+		t.Errorf("item contacts = %v, want [%d]", item.ContactIDs, contacts[0].ID)
+	}
+
+	comments := fake.Comments()
+	if len(comments) != 1 || comments[0].EntityID != itemID || comments[0].EntityType != "dynamic_1050" {
+		t.Fatalf("comments = %+v", comments)
+	}
+
+	// поиск контакта и создание всего остального - два запроса
+	if calls := fake.Calls(); strings.Join(calls, ",") != "crm.contact.list,batch" {
+		t.Errorf("calls = %v", calls)
+	}
+}
+
+func TestSyncDealReusesExistingContact(t *testing.T) {
+	fake, client := newTestBitrix(t)
+	existing := fake.AddContact("Иван", "+79991234567")
+
+	contactID, _, err := client.syncDeal(context.Background(), "+79991234567", "Иван Петров", CRMDeal{Title: "Мария - Казань"}, "")
+	if err != nil {
+		t.Fatalf("syncDeal: %v", err)
+	}
+	if contactID != strconv.Itoa(existing) {
+		t.Errorf("contactID = %s, want %d", contactID, existing)
+	}
+	if n := len(fake.Contacts()); n != 1 {
+		t.Errorf("contacts = %d, want 1", n)
+	}
+	items := fake.Items()
+	if len(items) != 1 || items[0].ContactIDs[0] != existing {
+		t.Errorf("items = %+v", items)
+	}
+	if n := len(fake.Comments()); n != 0 {
+		t.Errorf("comments = %d, want 0 for empty comment", n)
+	}
+}
+
+func TestSyncDealRetriesOnRateLimit(t *testing.T) {
+	fake, client := newTestBitrix(t)
+	fake.Fail("crm.contact.list", fakes.BitrixRateLimit, fakes.BitrixRateLimit)
+	fake.Fail("batch", fakes.BitrixRateLimit)
+
+	if _, _, err := client.syncDeal(context.Background(), "+79991234567", "Иван", CRMDeal{Title: "Мария - Казань"}, ""); err != nil {
+		t.Fatalf("syncDeal: %v", err)
+	}
+	want := "crm.contact.list,crm.contact.list,crm.contact.list,batch,batch"
+	if calls := strings.Join(fake.Calls(), ","); calls != want {
+		t.Errorf("calls = %s, want %s", calls, want)
+	}
+	if n := len(fake.Items()); n != 1 {
+		t.Errorf("items = %d, want 1", n)
+	}
+}
+
+func TestSyncDealDoesNotRetryBatchOnServerError(t *testing.T) {
+	fake, client := newTestBitrix(t)
+	fake.Fail("batch", fakes.BitrixFailure{Status: http.StatusBadGateway})
+
+	// batch мог выполниться до ошибки - повтор создал бы вторую сделку
+	_, _, err := client.syncDeal(context.Background(), "+79991234567", "Иван", CRMDeal{Title: "Мария - Казань"}, "")
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("err = %v, want http 502", err)
+	}
+	if calls := strings.Join(fake.Calls(), ","); calls != "crm.contact.list,batch" {
+		t.Errorf("calls = %s", calls)
+	}
+}
+
+func TestSyncDealGivesUpAfterMaxAttempts(t *testing.T) {
+	fake, client := newTestBitrix(t)
+	for i := 0; i < bitrixMaxAttempts; i++ {
+		fake.Fail("crm.contact.list", fakes.BitrixFailure{Status: http.StatusInternalServerError})
+	}
+
+	_, _, err := client.syncDeal(context.Background(), "+79991234567", "Иван", CRMDeal{Title: "Мария - Казань"}, "")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("err = %v, want http 500", err)
+	}
+	if n := len(fake.Calls()); n != bitrixMaxAttempts {
+		t.Errorf("calls = %d, want %d", n, bitrixMaxAttempts)
+	}
+}
+
+func TestSyncDealDoesNotRetryAPIError(t *testing.T) {
+	fake, client := newTestBitrix(t)
+	fake.Fail("crm.contact.list", fakes.BitrixFailure{Error: "ACCESS_DENIED", Description: "Access denied"})
+
+	_, _, err := client.syncDeal(context.Background(), "+79991234567", "Иван", CRMDeal{Title: "Мария - Казань"}, "")
+	if err == nil || !strings.Contains(err.Error(), "ACCESS_DENIED") {
+		t.Fatalf("err = %v, want ACCESS_DENIED", err)
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("calls = %d, want 1", n)
+	}
+}
+
+func TestSyncDealFailsOnBatchCommandError(t *testing.T) {
+	fake, client := newTestBitrix(t)
+	fake.Fail("crm.item.add", fakes.BitrixFailure{Error: "ERROR_CORE", Description: "stage is required"})
+
+	_, _, err := client.syncDeal(context.Background(), "+79991234567", "Иван", CRMDeal{Title: "Мария - Казань"}, "комментарий")
+	if err == nil || !strings.Contains(err.Error(), "stage is required") {
+		t.Fatalf("err = %v, want item error", err)
+	}
+	if n := len(fake.Items()); n != 0 {
+		t.Errorf("items = %d, want 0", n)
+	}
+}
+
+func TestTrySyncBitrixDeal(t *testing.T) {
+	const chatID = 42
+
+	t.Run("waits for phone and city", func(t *testing.T) {
+		useTestDB(t)
+		fake, client := newTestBitrix(t)
+		useTestCRM(t, client)
+
+		setSessionCourse(chatID, "Мария", "")
+		setSessionContact(chatID, "+79991234567", "Иван")
+		trySyncBitrixDeal(nil, chatID)
+
+		if calls := fake.Calls(); len(calls) != 0 {
+			t.Errorf("calls = %v, want none", calls)
+		}
+	})
+
+	t.Run("syncs once", func(t *testing.T) {
+		useTestDB(t)
+		fake, client := newTestBitrix(t)
+		useTestCRM(t, client)
+
+		setSessionCourse(chatID, "Мария", "Казань | 15 июля")
+		setSessionContact(chatID, "8 (999) 123-45-67", "Иван")
+		addSessionMessage(chatID, "А скидки есть?")
+		trySyncBitrixDeal(nil, chatID)
+		trySyncBitrixDeal(nil, chatID)
+
+		items := fake.Items()
+		if len(items) != 1 || items[0].Title != "Telegram - Мария - Казань | 15 июля" {
+			t.Fatalf("items = %+v", items)
+		}
+		if contacts := fake.Contacts(); contacts[0].Phone != "+79991234567" {
+			t.Errorf("phone = %s", contacts[0].Phone)
+		}
+		comments := fake.Comments()
+		if len(comments) != 1 || !strings.Contains(comments[0].Comment, "А скидки есть?") {
+			t.Errorf("comments = %+v", comments)
+		}
+
+		itemID := strconv.Itoa(items[0].ID)
+		stored, err := db.GetBitrixItem(dbConn, itemID)
+		if err != nil || stored == nil || stored.ChatID != chatID {
+			t.Errorf("stored item = %+v, %v", stored, err)
+		}
+
+		// сообщения после синхронизации уходят отдельными комментариями
+		appendBitrixClientMessage(chatID, "Можно в рассрочку?")
+		comments = fake.Comments()
+		if len(comments) != 2 || comments[1].EntityID != itemID || !strings.Contains(comments[1].Comment, "Можно в рассрочку?") {
+			t.Errorf("comments = %+v", comments)
+		}
+
+		// «Как оплатить» после создания сделки - отдельное примечание, один раз
+		setSessionPaymentViewed(chatID)
+		setSessionPaymentViewed(chatID)
+		comments = fake.Comments()
+		if len(comments) != 3 || comments[2].EntityID != itemID || comments[2].Comment != "Клиент открыл «Как оплатить»" {
+			t.Errorf("comments = %+v", comments)
+		}
+	})
+
+	t.Run("invalid phone", func(t *testing.T) {
+		useTestDB(t)
+		fake, client := newTestBitrix(t)
+		useTestCRM(t, client)
+		tg, bot := newTestTelegram(t)
+
+		setSessionCourse(chatID, "Мария", "Казань | 15 июля")
+		setSessionContact(chatID, "123", "Иван")
+		trySyncBitrixDeal(bot, chatID)
+
+		if calls := fake.Calls(); len(calls) != 0 {
+			t.Errorf("calls = %v, want none", calls)
+		}
+		if texts := sentTexts(tg, chatID); len(texts) != 1 || !strings.Contains(texts[0], "номер телефона") {
+			t.Errorf("sent = %v", texts)
+		}
+	})
+
+	t.Run("crm error allows retry", func(t *testing.T) {
+		useTestDB(t)
+		fake, client := newTestBitrix(t)
+		useTestCRM(t, client)
+		tg, bot := newTestTelegram(t)
+		fake.Fail("crm.contact.list", fakes.BitrixFailure{Status: http.StatusUnauthorized, Error: "expired_token"})
+
+		setSessionCourse(chatID, "Мария", "Казань | 15 июля")
+		setSessionContact(chatID, "+79991234567", "Иван")
+		trySyncBitrixDeal(bot, chatID)
+
+		if texts := sentTexts(tg, chatID); len(texts) != 1 || !strings.Contains(texts[0], "CRM") {
+			t.Errorf("sent = %v", texts)
+		}
+		if n := len(fake.Items()); n != 0 {
+			t.Fatalf("items = %d, want 0", n)
+		}
+
+		trySyncBitrixDeal(bot, chatID)
+		if n := len(fake.Items()); n != 1 {
+			t.Errorf("items after retry = %d, want 1", n)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Событие Bitrix24 об изменении элемента смарт-процесса
+	bitrixItemUpdateEvent = "ONCRMDYNAMICITEMUPDATE"
+
+	bitrixWebhookPath         = "/bitrix/webhook"
+	defaultStageMessagesPath  = "data/bitrix_stages.json"
+	bitrixWebhookReadTimeout  = 10 * time.Second
+	bitrixWebhookWriteTimeout = 30 * time.Second
+)
+
+// bitrixWebhookHandler принимает исходящие вебхуки Bitrix24 и уведомляет клиентов о смене стадии
+type bitrixWebhookHandler struct {
+	bot           tools.Sender
+	appToken      string
+	stageMessages map[string]string // ID стадии -> шаблон сообщения клиенту
+}
+
+// startBitrixWebhookServer поднимает HTTP-сервер для исходящих вебхуков Bitrix24, если задан B24_WEBHOOK_ADDR
+func startBitrixWebhookServer(bot tools.Sender) {
+	addr := strings.TrimSpace(os.Getenv("B24_WEBHOOK_ADDR"))
+	if addr == "" {
+		return
+	}
+
+	appToken := strings.TrimSpace(os.Getenv("B24_APP_TOKEN"))
+	if appToken == "" {
+		log.Println("bitrix webhook: B24_APP_TOKEN env is empty, server is not started")
+		return
+	}
+
+	messagesPath := strings.TrimSpace(os.Getenv("B24_STAGE_MESSAGES"))
+	if messagesPath == "" {
+		messagesPath = defaultStageMessagesPath
+	}
+	stageMessages, err := loadStageMessages(messagesPath)
+	if err != nil {
+		log.Printf("bitrix webhook: failed to load stage messages: %v", err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(bitrixWebhookPath, &bitrixWebhookHandler{
+		bot:           bot,
+		appToken:      appToken,
+		stageMessages: stageMessages,
+	})
+
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  bitrixWebhookReadTimeout,
+		WriteTimeout: bitrixWebhookWriteTimeout,
+	}
+
+	go func() {
+		log.Printf("bitrix webhook: listening on %s%s", addr, bitrixWebhookPath)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("bitrix webhook: server error: %v", err)
+		}
+	}()
+}
+
+// loadStageMessages читает JSON вида {"ID стадии": "шаблон сообщения"}
+func loadStageMessages(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	messages := make(map[string]string)
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func (h *bitrixWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Bitrix24 присылает токен приложения в каждом исходящем вебхуке
+	token := r.PostForm.Get("auth[application_token]")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.appToken)) != 1 {
+		log.Printf("bitrix webhook: invalid application token from %s", r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	event := strings.ToUpper(r.PostForm.Get("event"))
+	if event != bitrixItemUpdateEvent {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	entityTypeID, _ := strconv.Atoi(r.PostForm.Get("data[FIELDS][ENTITY_TYPE_ID]"))
+	itemID := r.PostForm.Get("data[FIELDS][ID]")
+	if entityTypeID != bitrixSpaEntityTypeID || itemID == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), bitrixCallTimeout())
+	defer cancel()
+
+	if err := h.handleItemUpdate(ctx, itemID); err != nil {
+		log.Printf("bitrix webhook: item %s: %v", itemID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleItemUpdate получает актуальную стадию элемента и, если для неё есть шаблон, отправляет клиенту сообщение
+func (h *bitrixWebhookHandler) handleItemUpdate(ctx context.Context, itemID string) error {
+	item, err := db.GetBitrixItem(dbConn, itemID)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		// элемент создан не ботом - клиента в Telegram у нас нет
+		return nil
+	}
+
+	client, err := getBitrixClient()
+	if err != nil {
+		return err
+	}
+	stageID, err := client.getItemStage(ctx, itemID)
+	if err != nil {
+		return err
+	}
+	if stageID == "" || stageID == item.StageID {
+		return nil
+	}
+
+	if err := db.UpdateBitrixItemStage(dbConn, itemID, stageID); err != nil {
+		return err
+	}
+
+	// стадии из B24_CONFIRMED_STAGES/B24_CANCELLED_STAGES занимают или освобождают место на сессии
+	if status := bookingStatusForStage(stageID); status != "" {
+		if err := db.UpdateBookingStatusByItem(dbConn, itemID, status); err != nil {
+			return err
+		}
+	}
+
+	template, ok := h.stageMessages[stageID]
+	if !ok || strings.TrimSpace(template) == "" {
+		return nil
+	}
+
+	msg := tgbotapi.NewMessage(item.ChatID, renderStageMessage(template, item.ChatID))
+	tools.SendAndLog(h.bot, msg)
+	log.Printf("bitrix webhook: notified chat %d about stage %s of item %s", item.ChatID, stageID, itemID)
+	return nil
+}
+
+// renderStageMessage подставляет в шаблон {name}, {speaker} и {city} из карточки клиента
+func renderStageMessage(template string, chatID int64) string {
+	var name, speaker, city string
+	user, err := db.GetUserByChatID(dbConn, chatID)
+	if err != nil {
+		log.Printf("bitrix webhook: failed to load user %d: %v", chatID, err)
+	}
+	if user != nil {
+		name, speaker, city = user.Fio, user.Speaker, user.City
+	}
+
+	return strings.NewReplacer(
+		"{name}", name,
+		"{speaker}", speaker,
+		"{city}", city,
+	).Replace(template)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const soldOutMessage = "К сожалению, на эту дату мест больше нет 😔\nВыберите другую дату или город."
+
+// sessionKey - идентификатор сессии курса в таблице броней: спикер и "Город | Дата"
+func sessionKey(speakerName, city string) string {
+	return strings.TrimSpace(speakerName) + " / " + strings.TrimSpace(city)
+}
+
+// splitSessionKey разбирает ключ sessionKey обратно на спикера и "Город | Дата"
+func splitSessionKey(key string) (speakerName, city string) {
+	speakerName, city, _ = strings.Cut(key, " / ")
+	return speakerName, city
+}
+
+// seatsLeft возвращает количество свободных мест на сессии; limited=false, если мест не ограничено
+func seatsLeft(speakerName string, course Course) (left int, limited bool) {
+	if course.Seats <= 0 {
+		return 0, false
+	}
+	booked, err := db.CountConfirmedBookings(dbConn, sessionKey(speakerName, course.City))
+	if err != nil {
+		log.Printf("booking: failed to count bookings: %v", err)
+		// не закрываем продажу из-за ошибки базы
+		return course.Seats, true
+	}
+	if booked >= course.Seats {
+		return 0, true
+	}
+	return course.Seats - booked, true
+}
+
+// soldOut сообщает, что все места на сессии заняты
+func soldOut(speakerName string, course Course) bool {
+	left, limited := seatsLeft(speakerName, course)
+	return limited && left == 0
+}
+
+// bookingAutoClose - скрывать заполненные сессии из списка городов (BOOKING_AUTO_CLOSE=true)
+// вместо показа с пометкой «мест нет»
+func bookingAutoClose() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("BOOKING_AUTO_CLOSE")), "true")
+}
+
+// seatsLabel - пометка о свободных местах для кнопки курса
+func seatsLabel(left int) string {
+	if left == 0 {
+		return "мест нет"
+	}
+	return fmt.Sprintf("осталось %d %s", left, plural(left, "место", "места", "мест"))
+}
+
+// findCourse ищет сессию курса в каталоге по имени спикера и "Город | Дата"
+func findCourse(speakerName, city string) (Course, bool) {
+	for _, s := range Speakers {
+		if s.Name != speakerName {
+			continue
+		}
+		for _, c := range s.Courses {
+			if c.City == city {
+				return c, true
+			}
+		}
+	}
+	return Course{}, false
+}
+
+// sessionSoldOut проверяет, не закончились ли места на сессии, выбранной в текущем диалоге
+func sessionSoldOut(chatID int64) bool {
+	session := snapshotSession(chatID)
+	if session == nil || session.City == "" {
+		return false
+	}
+	course, ok := findCourse(session.SpeakerName, session.City)
+	return ok && sessionFull(chatID, session.SpeakerName, course)
+}
+
+// sessionFull - места на сессии закончились, и среди занятых нет места клиента (например, оплаченного предоплатой)
+func sessionFull(chatID int64, speakerName string, course Course) bool {
+	if !soldOut(speakerName, course) {
+		return false
+	}
+	held, err := db.HasConfirmedBooking(dbConn, chatID, sessionKey(speakerName, course.City))
+	if err != nil {
+		log.Printf("booking: failed to check booking of chat %d: %v", chatID, err)
+	}
+	return !held
+}
+
+// sendSessionSoldOut сообщает, что на сессии, выбранной в диалоге, мест не осталось, и предлагает лист ожидания
+func sendSessionSoldOut(bot tools.Sender, chatID int64) {
+	msg := tgbotapi.NewMessage(chatID, soldOutMessage)
+	if session := snapshotSession(chatID); session != nil {
+		for si, s := range Speakers {
+			if s.Name != session.SpeakerName {
+				continue
+			}
+			for ci, c := range s.Courses {
+				if c.City == session.City {
+					msg.ReplyMarkup = WaitlistKeyboard(si, ci)
+				}
+			}
+		}
+	}
+	tools.SendAndLog(bot, msg)
+}
+
+// stageList читает список ID стадий Bitrix24 через запятую из переменной окружения
+func stageList(env string) []string {
+	var stages []string
+	for _, s := range strings.Split(os.Getenv(env), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			stages = append(stages, s)
+		}
+	}
+	return stages
+}
+
+// recordBooking запоминает бронь после создания сделки. Если заданы B24_CONFIRMED_STAGES, место считается
+// занятым только когда сделка Bitrix24 перейдёт в одну из этих стадий, иначе - сразу.
+// Место, оплаченное предоплатой, остаётся занятым
+func recordBooking(chatID int64, session *bitrixSession, itemID string, crm CRM) {
+	key := sessionKey(session.SpeakerName, session.City)
+	status := db.BookingConfirmed
+	if _, ok := crm.(*BitrixClient); ok && len(stageList("B24_CONFIRMED_STAGES")) > 0 {
+		paid, err := db.SessionPaid(dbConn, chatID, key)
+		if err != nil {
+			log.Printf("booking: failed to check payments of chat %d: %v", chatID, err)
+		}
+		if !paid {
+			status = db.BookingPending
+		}
+	}
+	if err := db.SaveBooking(dbConn, chatID, key, itemID, status); err != nil {
+		log.Printf("booking: failed to save booking for chat %d: %v", chatID, err)
+	}
+}
+
+// bookingStatusForStage возвращает статус брони для стадии Bitrix24 или пустую строку, если стадия на места не влияет
+func bookingStatusForStage(stageID string) string {
+	for _, s := range stageList("B24_CONFIRMED_STAGES") {
+		if s == stageID {
+			return db.BookingConfirmed
+		}
+	}
+	for _, s := range stageList("B24_CANCELLED_STAGES") {
+		if s == stageID {
+			return db.BookingCancelled
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"app/db"
+	"strings"
+	"testing"
+)
+
+// useCatalog подменяет каталог курсов
+func useCatalog(t *testing.T, speakers []Speaker) {
+	t.Helper()
+	prev := Speakers
+	Speakers = speakers
+	t.Cleanup(func() { Speakers = prev })
+}
+
+func TestSeatsLabel(t *testing.T) {
+	tests := map[int]string{
+		0:  "мест нет",
+		1:  "осталось 1 место",
+		3:  "осталось 3 места",
+		5:  "осталось 5 мест",
+		11: "осталось 11 мест",
+		21: "осталось 21 место",
+		22: "осталось 22 места",
+	}
+	for n, want := range tests {
+		if got := seatsLabel(n); got != want {
+			t.Errorf("seatsLabel(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestSessionCapacity(t *testing.T) {
+	useTestDB(t)
+	bitrix, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+	useCatalog(t, []Speaker{{Name: "Мария", Courses: []Course{
+		{City: "Казань | 15 июля", Program: "Описание", Seats: 1},
+		{City: "Москва | 20 июля", Program: "Описание"},
+	}}})
+
+	keyboard := func() string {
+		t.Helper()
+		tg.Reset()
+		HandleCallback(bot, callbackUpdate(1, speakerData(0)))
+		return tg.Sent(1)[0].ReplyMarkup()
+	}
+	if kb := keyboard(); !strings.Contains(kb, "Казань | 15 июля · осталось 1 место") || !strings.Contains(kb, `"Москва | 20 июля"`) {
+		t.Fatalf("keyboard = %s", kb)
+	}
+
+	// первый клиент занимает последнее место
+	HandleCallback(bot, callbackUpdate(1, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(1, consentAcceptData))
+	HandleMessage(bot, contactUpdate(1, "+79991234567"))
+	if n := len(bitrix.Items()); n != 1 {
+		t.Fatalf("items = %d, want 1", n)
+	}
+	if kb := keyboard(); !strings.Contains(kb, "Казань | 15 июля · мест нет") {
+		t.Errorf("keyboard after booking = %s", kb)
+	}
+
+	// третий клиент выбрал курс, пока место было свободно, и прислал телефон уже после первого
+	setSessionCourse(3, "Мария", "Казань | 15 июля")
+	HandleCallback(bot, callbackUpdate(3, consentAcceptData))
+	tg.Reset()
+	HandleMessage(bot, contactUpdate(3, "+79991112233"))
+	sent := tg.Sent(3)
+	if len(sent) != 1 || sent[0].Text() != soldOutMessage || !strings.Contains(sent[0].ReplyMarkup(), waitlistData(0, 0)) {
+		t.Fatalf("late contact replies = %+v", sent)
+	}
+	if n := len(bitrix.Items()); n != 1 {
+		t.Fatalf("lead created for late contact: %d items", n)
+	}
+	if key, _ := db.GetBookingItem(dbConn, 3, sessionKey("Мария", "Казань | 15 июля")); key != "" {
+		t.Errorf("booking saved for late contact: %s", key)
+	}
+
+	// второй клиент видит программу, но записаться не может
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(2, courseData(0, 0)))
+	texts := sentTexts(tg, 2)
+	if len(texts) != 3 || texts[2] != soldOutMessage {
+		t.Fatalf("sold out course = %v", texts)
+	}
+	for _, req := range tg.Sent(2) {
+		if strings.Contains(req.ReplyMarkup(), "book_course") {
+			t.Errorf("booking button offered for sold out session: %+v", req)
+		}
+	}
+	HandleCallback(bot, callbackUpdate(2, consentAcceptData))
+	HandleMessage(bot, contactUpdate(2, "+79997654321"))
+	if n := len(bitrix.Items()); n != 1 {
+		t.Errorf("lead created for sold out session: %d items", n)
+	}
+
+	// BOOKING_AUTO_CLOSE скрывает заполненную сессию
+	t.Setenv("BOOKING_AUTO_CLOSE", "true")
+	if kb := keyboard(); strings.Contains(kb, "Казань") || !strings.Contains(kb, "Москва") {
+		t.Errorf("keyboard with auto close = %s", kb)
+	}
+}
+
+func TestBookingConfirmedByStage(t *testing.T) {
+	useTestDB(t)
+	t.Setenv("B24_CONFIRMED_STAGES", "DT1050_10:SUCCESS, DT1050_10:PREPAID")
+	t.Setenv("B24_CANCELLED_STAGES", "DT1050_10:FAIL")
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	course := Course{City: "Казань | 15 июля", Seats: 1}
+	useCatalog(t, []Speaker{{Name: "Мария", Courses: []Course{course}}})
+
+	setSessionCourse(1, "Мария", course.City)
+	setSessionContact(1, "+79991234567", "Иван")
+	trySyncBitrixDeal(nil, 1)
+	itemID := syncedBitrixItem(1, sessionKey("Мария", course.City))
+
+	// заявка без подтверждения место не занимает
+	if left, _ := seatsLeft("Мария", course); left != 1 {
+		t.Fatalf("seats left = %d, want 1 for pending booking", left)
+	}
+
+	for _, step := range []struct {
+		stage string
+		left  int
+	}{
+		{"DT1050_10:NEW", 1},
+		{"DT1050_10:PREPAID", 0},
+		{"DT1050_10:FAIL", 1},
+	} {
+		if status := bookingStatusForStage(step.stage); status != "" {
+			if err := db.UpdateBookingStatusByItem(dbConn, itemID, status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if left, _ := seatsLeft("Мария", course); left != step.left {
+			t.Errorf("stage %s: seats left = %d, want %d", step.stage, left, step.left)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"app/broadcast"
+	"app/db"
+	tools "app/handlers"
+	"fmt"
+	"log"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	broadcastSendData   = "broadcast_send"
+	broadcastCancelData = "broadcast_cancel"
+
+	broadcastTextPrompt = "📣 Пришлите текст рассылки — можно фото с подписью.\n" +
+		"Кнопки добавляются отдельными строками в конце:\n" +
+		"[Подробнее](https://example.com)\n" +
+		"[Курсы спикера](speaker:Имя спикера)\n\n" +
+		"/cancel — отменить"
+	broadcastSegmentPrompt = "Кому отправить? Напишите all или условия через «;»:\n" +
+		"speaker=Имя спикера\n" +
+		"city=Город\n" +
+		"phone=yes или phone=no\n" +
+		"inactive=2024-05-01 — не заходили в бот с этой даты\n" +
+		"stage=speaker, course, booking или done — где остановились в воронке\n\n" +
+		"Например: city=Казань;phone=no"
+	broadcastConfirmPrompt = "Нажмите «Отправить» или «Отменить» под предпросмотром."
+	broadcastCancelled     = "Рассылка отменена."
+	broadcastStarted       = "🚀 Рассылка запущена, пришлю отчёт, когда закончу."
+	broadcastEmptyText     = "Текст рассылки пуст. Пришлите текст или фото с подписью."
+	broadcastNoRecipients  = "В этом сегменте нет получателей. Укажите другие условия или /cancel."
+)
+
+// Шаги составления рассылки
+const (
+	draftText    = iota // ждём текст
+	draftSegment        // ждём сегмент
+	draftPreview        // ждём подтверждения
+)
+
+type broadcastDraft struct {
+	step       int
+	message    broadcast.Message
+	segment    db.Segment
+	recipients []db.User
+}
+
+var (
+	broadcastDraftsMu sync.Mutex
+	broadcastDrafts   = make(map[int64]*broadcastDraft)
+)
+
+// runAsync запускает долгую задачу в фоне, чтобы рассылка не задерживала ответы клиентам; в тестах подменяется
+var runAsync = func(f func()) { go f() }
+
+// handleAdminMessage ведёт администратора по составлению рассылки. Возвращает false,
+// если сообщение к рассылке не относится и его нужно обработать как обычное
+func handleAdminMessage(bot tools.Sender, message *tgbotapi.Message) bool {
+	chatID := message.Chat.ID
+
+	switch message.Command() {
+	case "broadcast":
+		startBroadcast(bot, chatID)
+		return true
+	case "cancel":
+		if takeBroadcastDraft(chatID) == nil {
+			return false
+		}
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastCancelled))
+		return true
+	}
+
+	draft := getBroadcastDraft(chatID)
+	if draft == nil {
+		return false
+	}
+
+	switch draft.step {
+	case draftText:
+		text := message.Text
+		if text == "" {
+			text = message.Caption
+		}
+		body, buttons, err := broadcast.ParseText(text)
+		if err == nil {
+			err = broadcast.ResolveSpeakers(buttons, Speakers)
+		}
+		if err != nil {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()))
+			return true
+		}
+		if body == "" {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastEmptyText))
+			return true
+		}
+
+		draft.message = broadcast.Message{Text: body, Buttons: buttons}
+		if n := len(message.Photo); n > 0 {
+			draft.message.Photo = message.Photo[n-1].FileID
+		}
+		draft.step = draftSegment
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastSegmentPrompt))
+
+	case draftSegment:
+		segment, err := broadcast.ParseSegment(message.Text)
+		if err != nil {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()))
+			return true
+		}
+		users, err := db.GetUsersBySegment(dbConn, segment)
+		if err != nil {
+			log.Printf("broadcast: failed to load recipients: %v", err)
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось получить список получателей."))
+			return true
+		}
+		if len(users) == 0 {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastNoRecipients))
+			return true
+		}
+
+		draft.segment, draft.recipients = segment, users
+		draft.step = draftPreview
+		sendBroadcastPreview(bot, chatID, draft)
+
+	case draftPreview:
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastConfirmPrompt))
+	}
+	return true
+}
+
+// startBroadcast начинает составление рассылки: ждём текст
+func startBroadcast(bot tools.Sender, chatID int64) {
+	setBroadcastDraft(chatID, &broadcastDraft{step: draftText})
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastTextPrompt))
+}
+
+// sendBroadcastPreview показывает сообщение так, как его увидят клиенты, и кнопки подтверждения
+func sendBroadcastPreview(bot tools.Sender, chatID int64, draft *broadcastDraft) {
+	if _, err := bot.Send(draft.message.Chattable(chatID)); err != nil {
+		log.Printf("broadcast: failed to send preview: %v", err)
+	}
+
+	summary := fmt.Sprintf("👆 Так выглядит рассылка.\nСегмент: %s\nПолучателей: %d",
+		broadcast.DescribeSegment(draft.segment), len(draft.recipients))
+	msg := tgbotapi.NewMessage(chatID, summary)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Отправить", broadcastSendData),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Отменить", broadcastCancelData),
+	))
+	tools.SendAndLog(bot, msg)
+}
+
+// handleBroadcastCallback обрабатывает кнопки «Отправить» и «Отменить» под предпросмотром
+func handleBroadcastCallback(bot tools.Sender, chatID int64, data string) {
+	draft := takeBroadcastDraft(chatID)
+	if data == broadcastCancelData || draft == nil || draft.step != draftPreview {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastCancelled))
+		return
+	}
+
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, broadcastStarted))
+	runAsync(func() {
+		sender := &broadcast.Sender{Bot: bot, DB: dbConn, Rate: broadcast.Rate()}
+		report := sender.Send(draft.recipients, draft.message)
+		log.Printf("broadcast: done, delivered %d of %d, failed %d", report.Delivered, report.Total, report.Failed)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "📊 Рассылка завершена\n"+report.String()))
+	})
+}
+
+func getBroadcastDraft(chatID int64) *broadcastDraft {
+	broadcastDraftsMu.Lock()
+	defer broadcastDraftsMu.Unlock()
+	return broadcastDrafts[chatID]
+}
+
+func setBroadcastDraft(chatID int64, draft *broadcastDraft) {
+	broadcastDraftsMu.Lock()
+	defer broadcastDraftsMu.Unlock()
+	broadcastDrafts[chatID] = draft
+}
+
+// takeBroadcastDraft забирает черновик, чтобы повторное нажатие кнопки не запустило рассылку дважды
+func takeBroadcastDraft(chatID int64) *broadcastDraft {
+	broadcastDraftsMu.Lock()
+	defer broadcastDraftsMu.Unlock()
+	draft := broadcastDrafts[chatID]
+	delete(broadcastDrafts, chatID)
+	return draft
+}
@@ -0,0 +1,279 @@
+// Package broadcast отправляет рассылки по сегментам пользователей из базы с учётом лимитов Telegram
+package broadcast
+
+import (
+	"app/catalog"
+	"app/db"
+	tools "app/handlers"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultRate - сообщений в секунду; Telegram допускает около 30 в разные чаты
+const DefaultRate = 25
+
+// Rate читает BROADCAST_RATE - сколько сообщений в секунду отправлять при рассылке
+func Rate() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("BROADCAST_RATE"))); err == nil && n > 0 {
+		return n
+	}
+	return DefaultRate
+}
+
+// Button - кнопка под сообщением: ссылка или переход к спикеру из каталога
+type Button struct {
+	Text    string
+	URL     string
+	Speaker string // имя спикера для кнопки «speaker:Имя»
+	Data    string // callback, заполняется ResolveSpeakers
+}
+
+// Message - текст рассылки с необязательным фото (путь к файлу или file_id) и кнопками
+type Message struct {
+	Text    string
+	Photo   string
+	Buttons []Button
+}
+
+// Report - итоги рассылки
+type Report struct {
+	Total     int
+	Delivered int
+	Failed    int
+	Blocked   int // из них заблокировали бота
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("Получателей: %d\nДоставлено: %d\nНе доставлено: %d (заблокировали бота: %d)",
+		r.Total, r.Delivered, r.Failed, r.Blocked)
+}
+
+// кнопка в тексте: отдельная строка вида [Текст](https://example.com) или [Текст](speaker:Имя)
+var buttonLine = regexp.MustCompile(`^\[([^\]]+)\]\((.+)\)$`)
+
+// ParseText отделяет от текста строки-кнопки
+func ParseText(text string) (string, []Button, error) {
+	var (
+		body    []string
+		buttons []Button
+	)
+	for _, line := range strings.Split(text, "\n") {
+		m := buttonLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			body = append(body, line)
+			continue
+		}
+		b := Button{Text: strings.TrimSpace(m[1])}
+		target := strings.TrimSpace(m[2])
+		switch {
+		case strings.HasPrefix(target, "speaker:"):
+			b.Speaker = strings.TrimSpace(strings.TrimPrefix(target, "speaker:"))
+		case strings.HasPrefix(target, "https://"), strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "tg://"):
+			b.URL = target
+		default:
+			return "", nil, fmt.Errorf("кнопка «%s»: нужна ссылка http(s):// или speaker:Имя спикера", b.Text)
+		}
+		buttons = append(buttons, b)
+	}
+	return strings.TrimSpace(strings.Join(body, "\n")), buttons, nil
+}
+
+// ResolveSpeakers превращает кнопки speaker:Имя в переход к выбору города этого спикера.
+// В кнопку попадает catalog.SpeakerID, поэтому рассылка остаётся рабочей после изменения порядка спикеров
+func ResolveSpeakers(buttons []Button, speakers []catalog.Speaker) error {
+	for i := range buttons {
+		if buttons[i].Speaker == "" {
+			continue
+		}
+		name := ""
+		for _, s := range speakers {
+			if strings.EqualFold(s.Name, buttons[i].Speaker) {
+				name = s.Name
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("спикер «%s» не найден в каталоге", buttons[i].Speaker)
+		}
+		buttons[i].Data = "speaker_" + catalog.SpeakerID(name)
+	}
+	return nil
+}
+
+// ParseSegment разбирает сегмент: "all" или условия через ";" -
+// speaker=Имя, city=Город, phone=yes|no, inactive=2006-01-02 (не заходил с этой даты),
+// stage=speaker|course|booking|done (шаг воронки)
+func ParseSegment(raw string) (db.Segment, error) {
+	var s db.Segment
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.EqualFold(raw, "all") {
+		return s, nil
+	}
+	for _, part := range strings.Split(raw, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return s, fmt.Errorf("условие «%s»: ожидается ключ=значение", strings.TrimSpace(part))
+		}
+		switch key {
+		case "speaker":
+			s.Speaker = value
+		case "city":
+			s.City = value
+		case "phone":
+			switch strings.ToLower(value) {
+			case "yes", "да":
+				s.Phone = "yes"
+			case "no", "нет":
+				s.Phone = "no"
+			default:
+				return s, fmt.Errorf("phone: ожидается yes или no")
+			}
+		case "inactive":
+			t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return s, fmt.Errorf("inactive: ожидается дата ГГГГ-ММ-ДД")
+			}
+			s.InactiveSince = t
+		case "stage":
+			switch value = strings.ToLower(value); value {
+			case "speaker", "course", "booking", "done":
+				s.Stage = value
+			default:
+				return s, fmt.Errorf("stage: ожидается speaker, course, booking или done")
+			}
+		default:
+			return s, fmt.Errorf("неизвестное условие «%s»", key)
+		}
+	}
+	return s, nil
+}
+
+var stageNames = map[string]string{
+	"speaker": "выбрали спикера, но не курс",
+	"course":  "смотрели программу, но не оставили заявку",
+	"booking": "начали запись, но не оставили номер",
+	"done":    "оставили номер",
+}
+
+// DescribeSegment - сегмент словами для предпросмотра
+func DescribeSegment(s db.Segment) string {
+	var parts []string
+	if s.Speaker != "" {
+		parts = append(parts, "спикер «"+s.Speaker+"»")
+	}
+	if s.City != "" {
+		parts = append(parts, "город «"+s.City+"»")
+	}
+	switch s.Phone {
+	case "yes":
+		parts = append(parts, "с телефоном")
+	case "no":
+		parts = append(parts, "без телефона")
+	}
+	if !s.InactiveSince.IsZero() {
+		parts = append(parts, "не заходили с "+s.InactiveSince.Format("02.01.2006"))
+	}
+	if stage, ok := stageNames[s.Stage]; ok {
+		parts = append(parts, stage)
+	}
+	if len(parts) == 0 {
+		return "все пользователи"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Chattable собирает сообщение рассылки для чата
+func (m Message) Chattable(chatID int64) tgbotapi.Chattable {
+	var markup any
+	if len(m.Buttons) > 0 {
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for _, b := range m.Buttons {
+			btn := tgbotapi.NewInlineKeyboardButtonData(b.Text, b.Data)
+			if b.URL != "" {
+				btn = tgbotapi.NewInlineKeyboardButtonURL(b.Text, b.URL)
+			}
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
+		}
+		markup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+
+	if m.Photo == "" {
+		msg := tgbotapi.NewMessage(chatID, m.Text)
+		msg.ReplyMarkup = markup
+		return msg
+	}
+
+	var file tgbotapi.RequestFileData = tgbotapi.FileID(m.Photo)
+	if strings.ContainsAny(m.Photo, "./\\") {
+		file = tgbotapi.FilePath(m.Photo)
+	}
+	photo := tgbotapi.NewPhoto(chatID, file)
+	photo.Caption = m.Text
+	photo.ReplyMarkup = markup
+	return photo
+}
+
+// Sender рассылает сообщение с ограничением скорости
+type Sender struct {
+	Bot   tools.Sender
+	DB    *sql.DB
+	Rate  int                 // сообщений в секунду, 0 - DefaultRate
+	Sleep func(time.Duration) // подменяется в тестах
+}
+
+// Send отправляет сообщение получателям. Пользователи, заблокировавшие бота, отмечаются в базе;
+// при ответе 429 отправка ждёт указанное Telegram время и повторяется один раз.
+// Фото, загруженное с диска, отправляется остальным получателям по file_id
+func (s *Sender) Send(users []db.User, msg Message) Report {
+	rate := s.Rate
+	if rate <= 0 {
+		rate = DefaultRate
+	}
+	sleep := s.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	interval := time.Second / time.Duration(rate)
+
+	report := Report{Total: len(users)}
+	for i, u := range users {
+		if i > 0 {
+			sleep(interval)
+		}
+
+		sent, err := s.Bot.Send(msg.Chattable(u.ChatID))
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			sleep(time.Duration(apiErr.RetryAfter) * time.Second)
+			sent, err = s.Bot.Send(msg.Chattable(u.ChatID))
+		}
+
+		if err != nil {
+			report.Failed++
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+				report.Blocked++
+				if err := db.MarkUserBlocked(s.DB, u.ChatID); err != nil {
+					log.Printf("broadcast: failed to mark chat %d as blocked: %v", u.ChatID, err)
+				}
+			}
+			log.Printf("broadcast: chat %d: %v", u.ChatID, err)
+			continue
+		}
+		report.Delivered++
+
+		if msg.Photo != "" && len(sent.Photo) > 0 {
+			msg.Photo = sent.Photo[len(sent.Photo)-1].FileID
+		}
+	}
+	return report
+}
@@ -0,0 +1,119 @@
+package broadcast
+
+import (
+	"app/catalog"
+	"app/db"
+	"app/fakes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParseText(t *testing.T) {
+	body, buttons, err := ParseText("Новый курс!\nЗапись открыта\n\n[Подробнее](https://example.com)\n[Курсы](speaker:Мария)")
+	if err != nil {
+		t.Fatalf("ParseText: %v", err)
+	}
+	if body != "Новый курс!\nЗапись открыта" {
+		t.Errorf("body = %q", body)
+	}
+	if len(buttons) != 2 || buttons[0].URL != "https://example.com" || buttons[1].Speaker != "Мария" {
+		t.Fatalf("buttons = %+v", buttons)
+	}
+
+	speakers := []catalog.Speaker{{Name: "Анна"}, {Name: "Мария"}}
+	if err := ResolveSpeakers(buttons, speakers); err != nil {
+		t.Fatalf("ResolveSpeakers: %v", err)
+	}
+	if buttons[1].Data != "speaker_"+catalog.SpeakerID("Мария") {
+		t.Errorf("speaker button data = %q", buttons[1].Data)
+	}
+
+	if err := ResolveSpeakers([]Button{{Text: "x", Speaker: "Нет такого"}}, speakers); err == nil {
+		t.Error("expected error for unknown speaker")
+	}
+	if _, _, err := ParseText("[Кнопка](ftp://example.com)"); err == nil {
+		t.Error("expected error for unsupported link")
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	s, err := ParseSegment("speaker=Мария; city=Казань; phone=no; inactive=2024-05-01; stage=booking")
+	if err != nil {
+		t.Fatalf("ParseSegment: %v", err)
+	}
+	want := db.Segment{
+		Speaker:       "Мария",
+		City:          "Казань",
+		Phone:         "no",
+		InactiveSince: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local),
+		Stage:         "booking",
+	}
+	if s != want {
+		t.Errorf("segment = %+v, want %+v", s, want)
+	}
+	if got := DescribeSegment(db.Segment{}); got != "все пользователи" {
+		t.Errorf("DescribeSegment(all) = %q", got)
+	}
+
+	for _, raw := range []string{"phone=maybe", "inactive=вчера", "age=30", "city", "stage=paid"} {
+		if _, err := ParseSegment(raw); err == nil {
+			t.Errorf("ParseSegment(%q): expected error", raw)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	conn, err := db.Open(filepath.Join(t.TempDir(), "clients.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer conn.Close()
+	for _, id := range []int64{1, 2, 3} {
+		if err := db.UpsertUser(conn, id, "", "Клиент", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := fakes.NewTelegram()
+	defer fake.Close()
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("TEST", fake.Endpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.FailChat(2, fakes.TelegramBlocked)
+	fake.FailChat(3, fakes.TelegramFloodWait)
+
+	var slept []time.Duration
+	sender := &Sender{Bot: bot, DB: conn, Rate: 10, Sleep: func(d time.Duration) { slept = append(slept, d) }}
+	users, err := db.GetUsersBySegment(conn, db.Segment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Text: "Новости", Buttons: []Button{{Text: "Сайт", URL: "https://example.com"}}}
+	report := sender.Send(users, msg)
+
+	if report != (Report{Total: 3, Delivered: 2, Failed: 1, Blocked: 1}) {
+		t.Errorf("report = %+v", report)
+	}
+	// две паузы между сообщениями и ожидание после 429
+	wantSleep := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, time.Second}
+	if len(slept) != len(wantSleep) || slept[0] != wantSleep[0] || slept[1] != wantSleep[1] || slept[2] != wantSleep[2] {
+		t.Errorf("slept = %v, want %v", slept, wantSleep)
+	}
+	if sent := fake.Sent(1); len(sent) != 1 || !strings.Contains(sent[0].ReplyMarkup(), "https://example.com") {
+		t.Errorf("sent to chat 1 = %+v", sent)
+	}
+
+	// заблокировавший бота пользователь больше не попадает в рассылки
+	users, err = db.GetUsersBySegment(conn, db.Segment{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ChatID != 1 || users[1].ChatID != 3 {
+		t.Errorf("users after broadcast = %+v", users)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"app/db"
+	"app/fakes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useAdmin назначает чат администратором и выполняет рассылку синхронно
+func useAdmin(t *testing.T, chatID int64) {
+	t.Helper()
+	t.Setenv("ADMIN_IDS", "1, "+strconv.FormatInt(chatID, 10))
+	t.Setenv("BROADCAST_RATE", "1000")
+	prev := runAsync
+	runAsync = func(f func()) { f() }
+	t.Cleanup(func() {
+		runAsync = prev
+		broadcastDraftsMu.Lock()
+		broadcastDrafts = make(map[int64]*broadcastDraft)
+		broadcastDraftsMu.Unlock()
+	})
+}
+
+func TestBroadcastFlow(t *testing.T) {
+	const admin = 900
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+
+	// клиенты: один из Казани без телефона, один с телефоном, один заблокирует бота
+	for id, phone := range map[int64]string{101: "", 102: "+79990000000", 103: ""} {
+		if err := db.UpsertUser(dbConn, id, phone, "Клиент", "Казань | 15 июля", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tg.FailChat(103, fakes.TelegramBlocked)
+
+	HandleMessage(bot, messageUpdate(admin, "/broadcast"))
+	HandleMessage(bot, messageUpdate(admin, "Открыта запись на осень!\n[Записаться](speaker:"+Speakers[0].Name+")"))
+	HandleMessage(bot, messageUpdate(admin, "city=Казань;phone=no"))
+
+	sent := tg.Sent(admin)
+	if len(sent) != 4 {
+		t.Fatalf("admin messages = %v", sentTexts(tg, admin))
+	}
+	preview, summary := sent[2], sent[3]
+	if preview.Text() != "Открыта запись на осень!" || !strings.Contains(preview.ReplyMarkup(), speakerData(0)) {
+		t.Errorf("preview = %q %s", preview.Text(), preview.ReplyMarkup())
+	}
+	if !strings.Contains(summary.Text(), "Получателей: 2") || !strings.Contains(summary.ReplyMarkup(), broadcastSendData) {
+		t.Errorf("summary = %q %s", summary.Text(), summary.ReplyMarkup())
+	}
+	if len(tg.Sent(101)) != 0 {
+		t.Fatal("broadcast sent before confirmation")
+	}
+
+	HandleCallback(bot, callbackUpdate(admin, broadcastSendData))
+
+	if got := sentTexts(tg, 101); len(got) != 1 || got[0] != "Открыта запись на осень!" {
+		t.Errorf("client 101 got %v", got)
+	}
+	if got := tg.Sent(102); len(got) != 0 {
+		t.Errorf("client with phone got %d messages", len(got))
+	}
+	texts := sentTexts(tg, admin)
+	report := texts[len(texts)-1]
+	if !strings.Contains(report, "Доставлено: 1") || !strings.Contains(report, "заблокировали бота: 1") {
+		t.Errorf("report = %q", report)
+	}
+
+	// повторное нажатие не запускает рассылку ещё раз
+	HandleCallback(bot, callbackUpdate(admin, broadcastSendData))
+	if got := tg.Sent(101); len(got) != 1 {
+		t.Errorf("client 101 got %d messages after second click", len(got))
+	}
+}
+
+func TestBroadcastAdminOnly(t *testing.T) {
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	useAdmin(t, 900)
+	tg, bot := newTestTelegram(t)
+
+	HandleMessage(bot, messageUpdate(555, "/broadcast"))
+	if got := sentTexts(tg, 555); len(got) != 1 || got[0] != greetingMessage {
+		t.Errorf("non-admin got %v", got)
+	}
+}
+
+func TestBroadcastCancel(t *testing.T) {
+	const admin = 900
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+
+	HandleMessage(bot, messageUpdate(admin, "/broadcast"))
+	HandleMessage(bot, messageUpdate(admin, "[Сайт](ftp://example.com)"))
+	HandleMessage(bot, messageUpdate(admin, "/cancel"))
+	HandleMessage(bot, messageUpdate(admin, "привет"))
+
+	got := sentTexts(tg, admin)
+	if len(got) != 4 || !strings.HasPrefix(got[1], "⚠️") || got[2] != broadcastCancelled || got[3] != greetingMessage {
+		t.Errorf("admin got %v", got)
+	}
+}
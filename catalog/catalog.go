@@ -0,0 +1,291 @@
+// Package catalog загружает каталог курсов из CSV, YAML или JSON
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Course - одна запись курса (город и дата) в том виде, в каком её показывает бот
+type Course struct {
+	SpeakerIdx int
+	City       string // "Город | Дата"
+	Program    string // текст программы или файлы относительно data/ через ";"
+
+	// Карточка курса, все поля необязательные
+	Title       string // название курса
+	Description string // краткое описание
+	Price       string // стоимость в свободной форме, например "25 000 ₽"
+	Deposit     string // предоплата, например "5 000 ₽"; пусто - без онлайн-предоплаты
+	Duration    string // длительность, например "2 дня"
+	Format      string // онлайн или офлайн
+	Address     string // адрес площадки
+	Cover       string // обложка относительно data/, например "Мария/cover.jpg"
+
+	Seats int       // количество мест, 0 - без ограничения
+	Start time.Time // начало сессии, нулевое - дата не распознана
+}
+
+// HasCard сообщает, что у курса заполнено хоть одно поле карточки
+func (c Course) HasCard() bool {
+	return c.Title != "" || c.Description != "" || c.Price != "" || c.Duration != "" ||
+		c.Format != "" || c.Address != "" || c.Cover != ""
+}
+
+type Speaker struct {
+	Name    string
+	Courses []Course
+}
+
+// SpeakerID - короткий идентификатор спикера для callback-кнопок. Зависит только от имени, поэтому
+// кнопка из старого сообщения после перезагрузки каталога откроет того же спикера или не откроет никого
+func SpeakerID(name string) string {
+	return shortHash(strings.TrimSpace(name))
+}
+
+// CourseID - короткий идентификатор курса спикера в структурированном каталоге для callback-кнопок:
+// название и сессии курса. Если курс изменили, старая кнопка устаревает, а не выбирает соседний курс
+func CourseID(speaker string, c CourseEntry) string {
+	key := strings.TrimSpace(speaker) + " / " + strings.TrimSpace(c.Title)
+	for _, s := range c.Sessions {
+		key += " / " + strings.TrimSpace(s.City) + " | " + strings.TrimSpace(s.Date)
+	}
+	return shortHash(key)
+}
+
+// SessionID - короткий идентификатор сессии курса (спикер и "Город | Дата") для callback-кнопок.
+// Telegram ограничивает callback 64 байтами, поэтому вместо названий в кнопку кладётся хеш
+func SessionID(speaker, city string) string {
+	return shortHash(strings.TrimSpace(speaker) + " / " + strings.TrimSpace(city))
+}
+
+func shortHash(s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// File - структурированный каталог: спикеры -> курсы -> сессии
+type File struct {
+	Speakers []SpeakerEntry `yaml:"speakers" json:"speakers"`
+}
+
+type SpeakerEntry struct {
+	Name    string        `yaml:"name" json:"name"`
+	Courses []CourseEntry `yaml:"courses" json:"courses"`
+}
+
+type CourseEntry struct {
+	Title       string    `yaml:"title,omitempty" json:"title,omitempty"`
+	Description string    `yaml:"description,omitempty" json:"description,omitempty"`
+	Price       string    `yaml:"price,omitempty" json:"price,omitempty"`
+	Deposit     string    `yaml:"deposit,omitempty" json:"deposit,omitempty"`
+	Duration    string    `yaml:"duration,omitempty" json:"duration,omitempty"`
+	Format      string    `yaml:"format,omitempty" json:"format,omitempty"`
+	Address     string    `yaml:"address,omitempty" json:"address,omitempty"`
+	Cover       string    `yaml:"cover,omitempty" json:"cover,omitempty"`
+	Program     string    `yaml:"program,omitempty" json:"program,omitempty"` // текстовое описание программы
+	Files       []string  `yaml:"files,omitempty" json:"files,omitempty"`     // файлы программы относительно data/
+	Sessions    []Session `yaml:"sessions" json:"sessions"`
+}
+
+// Session - проведение курса; цена и адрес, если указаны, заменяют значения курса
+type Session struct {
+	City    string `yaml:"city" json:"city"`
+	Date    string `yaml:"date,omitempty" json:"date,omitempty"`
+	Price   string `yaml:"price,omitempty" json:"price,omitempty"`
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+	Seats   int    `yaml:"seats,omitempty" json:"seats,omitempty"` // количество мест, 0 - без ограничения
+	Start   string `yaml:"start,omitempty" json:"start,omitempty"` // начало: "2006-01-02 15:04" или "2006-01-02"
+}
+
+// Load загружает каталог, формат выбирается по расширению: .csv, .yaml/.yml или .json
+func Load(path string) ([]Speaker, error) {
+	file, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	return file.Flatten(), nil
+}
+
+// Read читает каталог в структурированном виде, формат выбирается по расширению
+func Read(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decode(path, data)
+}
+
+// decode разбирает и проверяет содержимое каталога; path нужен для формата и сообщений об ошибках
+func decode(path string, data []byte) (*File, error) {
+	var (
+		file *File
+		err  error
+	)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		file, err = ParseCSV(bytes.NewReader(data))
+	case ".yaml", ".yml":
+		file = &File{}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(file)
+	case ".json":
+		file = &File{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(file)
+	default:
+		return nil, fmt.Errorf("catalog: unsupported format %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("catalog %s: %w", path, err)
+	}
+	if err := file.Validate(); err != nil {
+		return nil, fmt.Errorf("catalog %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Write сохраняет каталог в YAML или JSON по расширению файла
+func Write(path string, file *File) error {
+	data, err := encode(path, file)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// WriteFileAtomic записывает файл через временный файл в том же каталоге и переименование:
+// читатели видят либо прежнее содержимое, либо новое целиком, но не наполовину записанный файл
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// encode сериализует каталог в YAML или JSON по расширению файла
+func encode(path string, file *File) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(file); err == nil {
+			err = enc.Close()
+		}
+		data = buf.Bytes()
+	case ".json":
+		data, err = json.MarshalIndent(file, "", "  ")
+		data = append(data, '\n')
+	default:
+		return nil, fmt.Errorf("catalog: unsupported output format %q", ext)
+	}
+	return data, err
+}
+
+// Validate проверяет обязательные поля: имя спикера, хотя бы одну сессию у курса и город у сессии
+func (f *File) Validate() error {
+	for i, s := range f.Speakers {
+		if strings.TrimSpace(s.Name) == "" {
+			return fmt.Errorf("speaker #%d: name is required", i+1)
+		}
+		for j, c := range s.Courses {
+			if len(c.Sessions) == 0 {
+				return fmt.Errorf("speaker %q, course #%d: no sessions", s.Name, j+1)
+			}
+			for k, session := range c.Sessions {
+				if strings.TrimSpace(session.City) == "" {
+					return fmt.Errorf("speaker %q, course #%d, session #%d: city is required", s.Name, j+1, k+1)
+				}
+				if session.Seats < 0 {
+					return fmt.Errorf("speaker %q, course #%d, session #%d: seats must not be negative", s.Name, j+1, k+1)
+				}
+				if session.Start != "" {
+					if _, err := parseStart(session.Start); err != nil {
+						return fmt.Errorf("speaker %q, course #%d, session #%d: %w", s.Name, j+1, k+1, err)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Flatten разворачивает каталог в список спикеров, где у каждой сессии своя запись курса
+func (f *File) Flatten() []Speaker {
+	speakers := make([]Speaker, 0, len(f.Speakers))
+	for i, s := range f.Speakers {
+		speaker := Speaker{Name: strings.TrimSpace(s.Name)}
+		for _, c := range s.Courses {
+			program := strings.TrimSpace(c.Program)
+			if len(c.Files) > 0 {
+				program = strings.Join(c.Files, ";")
+			}
+			for _, session := range c.Sessions {
+				course := Course{
+					SpeakerIdx:  i,
+					City:        sessionTitle(session),
+					Program:     program,
+					Title:       c.Title,
+					Description: c.Description,
+					Price:       firstNonEmpty(session.Price, c.Price),
+					Deposit:     c.Deposit,
+					Duration:    c.Duration,
+					Format:      c.Format,
+					Address:     firstNonEmpty(session.Address, c.Address),
+					Cover:       c.Cover,
+					Seats:       session.Seats,
+					Start:       sessionStart(session),
+				}
+				speaker.Courses = append(speaker.Courses, course)
+			}
+		}
+		speakers = append(speakers, speaker)
+	}
+	return speakers
+}
+
+// sessionTitle собирает подпись кнопки "Город | Дата"
+func sessionTitle(s Session) string {
+	city, date := strings.TrimSpace(s.City), strings.TrimSpace(s.Date)
+	if date == "" {
+		return city
+	}
+	return city + " | " + date
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCSV = `Имя,Город | Дата,Программа,Цена
+Мария,Казань | 15 июля;Москва,/Мария/1.jpg;/Мария/2.jpg,10 000 ₽
+Иван,Питер | 13 июня,Описание курса; с точкой с запятой,
+Мария,Сочи | 1 мая,Мария/program.pdf,
+`
+
+// useNow фиксирует текущее время для разбора дат без года
+func useNow(t *testing.T, ref time.Time) {
+	t.Helper()
+	prev := now
+	now = func() time.Time { return ref }
+	t.Cleanup(func() { now = prev })
+}
+
+func TestParseCSV(t *testing.T) {
+	useNow(t, time.Date(2026, time.March, 1, 12, 0, 0, 0, time.Local))
+	file, err := ParseCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	speakers := file.Flatten()
+	if len(speakers) != 2 || speakers[0].Name != "Иван" || speakers[1].Name != "Мария" {
+		t.Fatalf("speakers = %+v", speakers)
+	}
+
+	maria := speakers[1].Courses
+	want := []Course{
+		{SpeakerIdx: 1, City: "Казань | 15 июля", Program: "Мария/1.jpg;Мария/2.jpg", Price: "10 000 ₽",
+			Start: time.Date(2026, time.July, 15, 0, 0, 0, 0, time.Local)},
+		{SpeakerIdx: 1, City: "Москва", Program: "Мария/1.jpg;Мария/2.jpg", Price: "10 000 ₽"},
+		{SpeakerIdx: 1, City: "Сочи | 1 мая", Program: "Мария/program.pdf",
+			Start: time.Date(2026, time.May, 1, 0, 0, 0, 0, time.Local)},
+	}
+	if !reflect.DeepEqual(maria, want) {
+		t.Errorf("courses = %+v, want %+v", maria, want)
+	}
+	if p := speakers[0].Courses[0].Program; p != "Описание курса; с точкой с запятой" {
+		t.Errorf("text program = %q", p)
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "courses.csv")
+	if err := os.WriteFile(csvPath, []byte(testCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := Load(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Read(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"courses.yaml", "courses.json"} {
+		path := filepath.Join(dir, name)
+		if err := Write(path, file); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		got, err := Load(path)
+		if err != nil {
+			t.Fatalf("load %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestReadYAMLSessionOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "courses.yml")
+	yaml := `speakers:
+  - name: Мария
+    courses:
+      - title: Колористика
+        price: 20 000 ₽
+        address: ул. Ленина, 1
+        sessions:
+          - city: Казань
+            date: 15 июля
+          - city: Москва
+            price: 25 000 ₽
+            address: ул. Тверская, 1
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	speakers, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	courses := speakers[0].Courses
+	if len(courses) != 2 || courses[0].Price != "20 000 ₽" || courses[0].City != "Казань | 15 июля" {
+		t.Errorf("first session = %+v", courses)
+	}
+	if courses[1].Price != "25 000 ₽" || courses[1].Address != "ул. Тверская, 1" || courses[1].Title != "Колористика" {
+		t.Errorf("second session = %+v", courses[1])
+	}
+}
+
+func TestReadRejectsInvalidCatalog(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"typo.yaml":      "speakers:\n  - name: Мария\n    cources: []\n",
+		"nosession.json": `{"speakers":[{"name":"Мария","courses":[{"title":"Курс"}]}]}`,
+		"courses.xml":    "<speakers/>",
+	}
+	for name, content := range cases {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseRussianDate(t *testing.T) {
+	ref := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.Local)
+	tests := map[string]time.Time{
+		"15 июля":              time.Date(2027, time.July, 15, 0, 0, 0, 0, time.Local),
+		"1 октября":            time.Date(2026, time.October, 1, 0, 0, 0, 0, time.Local),
+		"12 декабря 10:30":     time.Date(2026, time.December, 12, 10, 30, 0, 0, time.Local),
+		"3 Марта 2026, в 9:00": time.Date(2026, time.March, 3, 9, 0, 0, 0, time.Local),
+		"31 июня":              {},
+		"скоро":                {},
+	}
+	for raw, want := range tests {
+		if got := parseRussianDate(raw, ref); !got.Equal(want) {
+			t.Errorf("parseRussianDate(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestSessionStartOverridesDate(t *testing.T) {
+	got := sessionStart(Session{City: "Казань", Date: "15 июля", Start: "2026-07-16 11:00"})
+	if want := time.Date(2026, time.July, 16, 11, 0, 0, 0, time.Local); !got.Equal(want) {
+		t.Errorf("start = %v, want %v", got, want)
+	}
+	if err := (&File{Speakers: []SpeakerEntry{{Name: "Мария", Courses: []CourseEntry{{
+		Sessions: []Session{{City: "Казань", Start: "16.07.2026"}},
+	}}}}}).Validate(); err == nil {
+		t.Error("expected invalid start error")
+	}
+}
+
+func TestSetProgramFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	csvPath := filepath.Join(dir, "courses.csv")
+	if err := os.WriteFile(csvPath, []byte(testCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// второй курс Марии - строка с Сочи
+	if err := SetProgramFiles(csvPath, "Мария", 1, []string{"Мария/new.pdf"}); err != nil {
+		t.Fatalf("SetProgramFiles csv: %v", err)
+	}
+	file, err := Read(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maria := file.Speakers[1]
+	if !reflect.DeepEqual(maria.Courses[1].Files, []string{"Мария/new.pdf"}) || len(maria.Courses[0].Files) != 2 {
+		t.Errorf("courses = %+v", maria.Courses)
+	}
+	if maria.Courses[0].Price != "10 000 ₽" {
+		t.Errorf("other columns changed: %+v", maria.Courses[0])
+	}
+
+	yamlPath := filepath.Join(dir, "courses.yaml")
+	if err := Write(yamlPath, file); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetProgramFiles(yamlPath, "Иван", 0, []string{"Иван/program.pdf"}); err != nil {
+		t.Fatalf("SetProgramFiles yaml: %v", err)
+	}
+	file, err = Read(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := file.Speakers[0].Courses[0]; c.Program != "" || !reflect.DeepEqual(c.Files, []string{"Иван/program.pdf"}) {
+		t.Errorf("course = %+v", c)
+	}
+
+	if err := SetProgramFiles(yamlPath, "Иван", 5, nil); err == nil {
+		t.Error("expected error for unknown course")
+	}
+}
+
+func TestSetProgramFilesKeepsYAMLComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "courses.yaml")
+	const original = `# каталог курсов
+speakers:
+  - name: Мария # ведущий спикер
+    courses:
+      # осенний поток
+      - title: Брови
+        program: Расскажем на месте # временно
+        sessions:
+          - city: Казань
+            date: 15 июля
+`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetProgramFiles(path, "Мария", 0, []string{"Мария/program.pdf"}); err != nil {
+		t.Fatalf("SetProgramFiles: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, comment := range []string{"# каталог курсов", "# ведущий спикер", "# осенний поток"} {
+		if !strings.Contains(string(data), comment) {
+			t.Errorf("comment %q lost:\n%s", comment, data)
+		}
+	}
+	file, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := file.Speakers[0].Courses[0]; c.Program != "" || !reflect.DeepEqual(c.Files, []string{"Мария/program.pdf"}) {
+		t.Errorf("course = %+v", c)
+	}
+
+	// неподдерживаемый файл отклоняется до записи
+	if err := SetProgramFiles(path, "Мария", 0, []string{"Мария/program.rar"}); err == nil {
+		t.Error("expected error for unsupported file")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		t.Errorf("catalog changed by rejected update:\n%s", after)
+	}
+}
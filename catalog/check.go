@@ -0,0 +1,50 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Warnings ищет в каталоге то, что не мешает загрузке, но испортит показ курса: файлы программы и обложки,
+// которых нет в dataDir, сессии без распознанной даты и уже прошедшие сессии
+func (f *File) Warnings(dataDir string) []string {
+	var warnings []string
+	missing := func(ref string) bool {
+		_, err := os.Stat(filepath.Join(dataDir, filepath.FromSlash(strings.TrimSpace(ref))))
+		return err != nil
+	}
+
+	for _, s := range f.Speakers {
+		for j, c := range s.Courses {
+			course := fmt.Sprintf("%s, курс #%d", s.Name, j+1)
+			if c.Title != "" {
+				course = fmt.Sprintf("%s, «%s»", s.Name, c.Title)
+			}
+
+			if len(c.Files) == 0 && strings.TrimSpace(c.Program) == "" {
+				warnings = append(warnings, course+": нет программы")
+			}
+			for _, file := range c.Files {
+				if missing(file) {
+					warnings = append(warnings, fmt.Sprintf("%s: нет файла программы %s", course, file))
+				}
+			}
+			if c.Cover != "" && missing(c.Cover) {
+				warnings = append(warnings, fmt.Sprintf("%s: нет обложки %s", course, c.Cover))
+			}
+
+			for _, session := range c.Sessions {
+				start := sessionStart(session)
+				switch {
+				case start.IsZero():
+					warnings = append(warnings, fmt.Sprintf("%s, %s: дата не распознана, напоминания не придут", course, sessionTitle(session)))
+				case start.AddDate(0, 0, 1).Before(now()):
+					warnings = append(warnings, fmt.Sprintf("%s, %s: дата прошла", course, sessionTitle(session)))
+				}
+			}
+		}
+	}
+	return warnings
+}
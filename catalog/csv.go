@@ -0,0 +1,103 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseCSV читает courses.csv: "Имя,Город | Дата,Программа" и необязательные столбцы карточки
+// (Название, Описание, Цена, Предоплата, Длительность, Формат, Адрес, Обложка, Мест). Строки одного спикера объединяются,
+// спикеры сортируются по алфавиту
+func ParseCSV(r io.Reader) (*File, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return &File{}, nil
+	}
+	columns := csvColumns(records[0])
+
+	speakers := make(map[string]*SpeakerEntry)
+	for n, rec := range records[1:] { // пропускаем заголовок
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 columns", n+2)
+		}
+		name, cityRaw, program := rec[0], rec[1], strings.TrimSpace(rec[2])
+
+		course := CourseEntry{
+			Title:       columns.get(rec, "Название"),
+			Description: columns.get(rec, "Описание"),
+			Price:       columns.get(rec, "Цена"),
+			Deposit:     columns.get(rec, "Предоплата"),
+			Duration:    columns.get(rec, "Длительность"),
+			Format:      columns.get(rec, "Формат"),
+			Address:     columns.get(rec, "Адрес"),
+			Cover:       columns.get(rec, "Обложка"),
+		}
+		if files := ProgramFiles(program); files != nil {
+			course.Files = files
+		} else {
+			course.Program = program
+		}
+
+		seats := 0
+		if raw := columns.get(rec, "Мест"); raw != "" {
+			if seats, err = strconv.Atoi(raw); err != nil || seats < 0 {
+				return nil, fmt.Errorf("line %d: invalid seats %q", n+2, raw)
+			}
+		}
+
+		// Разбиваем cityRaw по ";" и убираем пробелы
+		for _, c := range strings.Split(cityRaw, ";") {
+			city, date, _ := strings.Cut(c, "|")
+			if city = strings.TrimSpace(city); city == "" {
+				continue // пропустить пустые строки
+			}
+			course.Sessions = append(course.Sessions, Session{City: city, Date: strings.TrimSpace(date), Seats: seats})
+		}
+		if len(course.Sessions) == 0 {
+			continue
+		}
+
+		if speakers[name] == nil {
+			speakers[name] = &SpeakerEntry{Name: name}
+		}
+		speakers[name].Courses = append(speakers[name].Courses, course)
+	}
+
+	file := &File{}
+	for _, s := range speakers {
+		file.Speakers = append(file.Speakers, *s)
+	}
+
+	// Сортировка по алфавиту
+	sort.Slice(file.Speakers, func(i, j int) bool {
+		return file.Speakers[i].Name < file.Speakers[j].Name
+	})
+	return file, nil
+}
+
+// csvHeader - номера необязательных колонок каталога по названию из заголовка
+type csvHeader map[string]int
+
+func csvColumns(header []string) csvHeader {
+	columns := make(csvHeader)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	return columns
+}
+
+// get возвращает значение колонки или пустую строку, если колонки в файле нет
+func (h csvHeader) get(rec []string, name string) string {
+	i, ok := h[name]
+	if !ok || i >= len(rec) {
+		return ""
+	}
+	return strings.TrimSpace(rec[i])
+}
@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now подменяется в тестах
+var now = time.Now
+
+var (
+	startLayouts = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+	// "15 июля", "15 июля 2026", "15 июля 10:00", "15 июля 2026, 10:00"
+	russianDate = regexp.MustCompile(`(?i)^(\d{1,2})\s+([а-яё]+)(?:\s+(\d{4}))?,?(?:\s+(?:в\s+)?(\d{1,2}):(\d{2}))?$`)
+
+	russianMonths = map[string]time.Month{
+		"января": time.January, "февраля": time.February, "марта": time.March, "апреля": time.April,
+		"мая": time.May, "июня": time.June, "июля": time.July, "августа": time.August,
+		"сентября": time.September, "октября": time.October, "ноября": time.November, "декабря": time.December,
+	}
+)
+
+// parseStart разбирает явное начало сессии из YAML/JSON
+func parseStart(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range startLayouts {
+		if t, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid start %q, expected YYYY-MM-DD HH:MM", raw)
+}
+
+// sessionStart возвращает начало сессии: поле start, а если его нет - дату из подписи ("15 июля 10:00")
+func sessionStart(s Session) time.Time {
+	if s.Start != "" {
+		t, _ := parseStart(s.Start)
+		return t
+	}
+	return parseRussianDate(s.Date, now())
+}
+
+// parseRussianDate разбирает дату вида "15 июля" или "15 июля 2026 10:00". Без года берётся ближайшая
+// дата, не раньше чем месяц назад, чтобы только что прошедшие сессии не переезжали на следующий год
+func parseRussianDate(raw string, ref time.Time) time.Time {
+	m := russianDate.FindStringSubmatch(strings.TrimSpace(raw))
+	if m == nil {
+		return time.Time{}
+	}
+	month, ok := russianMonths[strings.ToLower(m[2])]
+	if !ok {
+		return time.Time{}
+	}
+	day, _ := strconv.Atoi(m[1])
+	hour, minute := 0, 0
+	if m[4] != "" {
+		hour, _ = strconv.Atoi(m[4])
+		minute, _ = strconv.Atoi(m[5])
+	}
+
+	year := ref.Year()
+	if m[3] != "" {
+		year, _ = strconv.Atoi(m[3])
+	}
+	t := time.Date(year, month, day, hour, minute, 0, 0, time.Local)
+	if t.Day() != day || hour > 23 || minute > 59 {
+		return time.Time{} // 31 июня, 25:00
+	}
+	if m[3] == "" && t.Before(ref.AddDate(0, -1, 0)) {
+		t = t.AddDate(1, 0, 0)
+	}
+	return t
+}
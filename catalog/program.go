@@ -0,0 +1,239 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProgramExtensions - форматы файлов программы, которые бот умеет отправлять
+var ProgramExtensions = []string{".jpg", ".jpeg", ".png", ".mp4", ".pdf", ".docx", ".pptx", ".md", ".txt"}
+
+// ProgramFileSupported сообщает, что бот умеет отправлять файл программы с таким именем
+func ProgramFileSupported(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range ProgramExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// ProgramFiles возвращает пути к файлам программы относительно data/; для текстовой программы - nil.
+// Программа считается списком файлов, только если у каждого элемента поддерживаемое расширение
+func ProgramFiles(program string) []string {
+	if !isProgramFileList(program) {
+		return nil
+	}
+	var files []string
+	for _, ref := range splitProgram(program) {
+		files = append(files, strings.TrimLeft(strings.ReplaceAll(ref, "\\", "/"), "/"))
+	}
+	return files
+}
+
+// isProgramFileList сообщает, что программа - ссылка на файлы, а не текстовое описание
+func isProgramFileList(program string) bool {
+	if strings.Contains(program, "\n") {
+		return false
+	}
+	refs := splitProgram(program)
+	if len(refs) == 0 {
+		return false
+	}
+	for _, ref := range refs {
+		if !ProgramFileSupported(ref) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitProgram(program string) []string {
+	var refs []string
+	for _, ref := range strings.Split(program, ";") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// SetProgramFiles заменяет программу курса course (номер курса спикера в Read) файлами files и сохраняет каталог.
+// Изменённый каталог проверяется до записи и записывается через временный файл, поэтому при ошибке
+// прежний каталог остаётся целым. В CSV меняется только колонка программы нужной строки, в YAML - только
+// ключи программы курса: остальные строки, порядок ключей и комментарии остаются как были
+func SetProgramFiles(path, speaker string, course int, files []string) error {
+	for _, f := range files {
+		if !ProgramFileSupported(f) {
+			return fmt.Errorf("catalog: unsupported program file %q", f)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		data, err = setCSVProgram(data, speaker, course, strings.Join(files, ";"))
+	case ".yaml", ".yml":
+		data, err = setYAMLProgram(data, speaker, course, files)
+	default:
+		data, err = setEncodedProgram(path, data, speaker, course, files)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := decode(path, data); err != nil {
+		return fmt.Errorf("catalog: update is invalid: %w", err)
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// setEncodedProgram меняет программу через разбор и повторную сериализацию каталога (JSON комментариев не хранит)
+func setEncodedProgram(path string, data []byte, speaker string, course int, files []string) ([]byte, error) {
+	file, err := decode(path, data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range file.Speakers {
+		s := &file.Speakers[i]
+		if s.Name != speaker {
+			continue
+		}
+		if course < 0 || course >= len(s.Courses) {
+			return nil, fmt.Errorf("catalog: speaker %q has no course #%d", speaker, course+1)
+		}
+		s.Courses[course].Files = files
+		s.Courses[course].Program = ""
+		return encode(path, file)
+	}
+	return nil, fmt.Errorf("catalog: speaker %q not found", speaker)
+}
+
+// setYAMLProgram меняет программу курса в дереве документа YAML, а не в структуре File,
+// чтобы не потерять комментарии и форматирование остального каталога
+func setYAMLProgram(data []byte, speaker string, course int, files []string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("catalog: speaker %q not found", speaker)
+	}
+	speakers := yamlValue(doc.Content[0], "speakers")
+	if speakers == nil {
+		return nil, fmt.Errorf("catalog: speaker %q not found", speaker)
+	}
+	for _, s := range speakers.Content {
+		if name := yamlValue(s, "name"); name == nil || name.Value != speaker {
+			continue
+		}
+		courses := yamlValue(s, "courses")
+		if courses == nil || course < 0 || course >= len(courses.Content) {
+			return nil, fmt.Errorf("catalog: speaker %q has no course #%d", speaker, course+1)
+		}
+		setYAMLFiles(courses.Content[course], files)
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("catalog: speaker %q not found", speaker)
+}
+
+// setYAMLFiles записывает files в ключ files курса и убирает текстовую программу.
+// Если были только ключ program, files встаёт на его место
+func setYAMLFiles(course *yaml.Node, files []string) {
+	seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, f := range files {
+		seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: f})
+	}
+
+	if old := yamlValue(course, "files"); old != nil {
+		old.Kind, old.Tag, old.Value, old.Content = seq.Kind, seq.Tag, "", seq.Content
+		yamlDelete(course, "program")
+		return
+	}
+	for i := 0; i+1 < len(course.Content); i += 2 {
+		if course.Content[i].Value == "program" {
+			course.Content[i].Value = "files"
+			seq.LineComment = course.Content[i+1].LineComment
+			course.Content[i+1] = seq
+			return
+		}
+	}
+	course.Content = append(course.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "files"}, seq)
+}
+
+// yamlValue возвращает значение ключа key узла-словаря или nil
+func yamlValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func yamlDelete(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// setCSVProgram меняет ячейку программы в строке курса. Номер курса считается так же, как в ParseCSV:
+// по строкам спикера, в которых есть хотя бы один город
+func setCSVProgram(data []byte, speaker string, course int, program string) ([]byte, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for i, rec := range records {
+		if i == 0 || len(rec) < 3 || rec[0] != speaker || !hasCity(rec[1]) {
+			continue
+		}
+		if n == course {
+			records[i][2] = program
+			var buf bytes.Buffer
+			w := csv.NewWriter(&buf)
+			if err := w.WriteAll(records); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+		n++
+	}
+	return nil, fmt.Errorf("catalog: speaker %q has no course #%d", speaker, course+1)
+}
+
+func hasCity(cityRaw string) bool {
+	for _, c := range strings.Split(cityRaw, ";") {
+		if city, _, _ := strings.Cut(c, "|"); strings.TrimSpace(city) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	tools "app/handlers"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Как часто проверять, не изменился ли файл каталога
+const defaultCatalogReloadInterval = time.Minute
+
+// catalogReloadInterval читает CATALOG_RELOAD_INTERVAL (например 30s, 5m); 0 отключает перезагрузку
+func catalogReloadInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("CATALOG_RELOAD_INTERVAL"))
+	if raw == "" {
+		return defaultCatalogReloadInterval
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Printf("catalog: invalid CATALOG_RELOAD_INTERVAL %q, using %s", raw, defaultCatalogReloadInterval)
+		return defaultCatalogReloadInterval
+	}
+	return d
+}
+
+// catalogWatcher замечает изменение файла каталога по времени модификации
+type catalogWatcher struct {
+	path    string
+	modTime time.Time
+}
+
+func newCatalogWatcher(path string) *catalogWatcher {
+	w := &catalogWatcher{path: path}
+	w.changed()
+	return w
+}
+
+// changed сообщает, изменился ли файл с прошлой проверки
+func (w *catalogWatcher) changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) {
+		return false
+	}
+	w.modTime = info.ModTime()
+	return true
+}
+
+// reloadCatalog перечитывает каталог и уведомляет лист ожидания о новых сессиях.
+// При ошибке в файле остаётся прежний каталог
+func reloadCatalog(bot tools.Sender) error {
+	prev := Speakers
+	if err := LoadSpeakers(catalogPath()); err != nil {
+		log.Printf("catalog: reload failed, keeping previous catalog: %v", err)
+		return err
+	}
+	log.Printf("catalog: reloaded %s, speakers: %d", catalogPath(), len(Speakers))
+
+	notifyWaitlist(bot, prev)
+	return nil
+}
@@ -0,0 +1,98 @@
+// broadcast отправляет рассылку пользователям бота из консоли. Без -send только показывает предпросмотр:
+//
+//	go run ./cmd/broadcast -text-file news.txt -photo data/cover.jpg -segment "city=Казань;phone=no"
+//	go run ./cmd/broadcast -text-file news.txt -segment all -send
+package main
+
+import (
+	"app/broadcast"
+	"app/catalog"
+	"app/db"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	text := flag.String("text", "", "текст рассылки; строки вида [Текст](https://...) или [Текст](speaker:Имя) становятся кнопками")
+	textFile := flag.String("text-file", "", "файл с текстом рассылки вместо -text")
+	photo := flag.String("photo", "", "путь к фото или file_id")
+	segment := flag.String("segment", "all", "получатели: all или условия через «;» - speaker=, city=, phone=yes|no, inactive=ГГГГ-ММ-ДД, stage=")
+	send := flag.Bool("send", false, "отправить рассылку; без флага только предпросмотр")
+	flag.Parse()
+	_ = godotenv.Load()
+
+	raw := *text
+	if *textFile != "" {
+		data, err := os.ReadFile(*textFile)
+		if err != nil {
+			log.Fatalf("Ошибка чтения текста: %v", err)
+		}
+		raw = string(data)
+	}
+
+	body, buttons, err := broadcast.ParseText(raw)
+	if err != nil {
+		log.Fatalf("Ошибка в тексте: %v", err)
+	}
+	if body == "" {
+		log.Fatal("Текст рассылки пуст: укажите -text или -text-file")
+	}
+
+	path := os.Getenv("CATALOG_PATH")
+	if path == "" {
+		path = "data/courses.csv"
+	}
+	speakers, err := catalog.Load(path)
+	if err != nil {
+		log.Fatalf("Ошибка чтения каталога: %v", err)
+	}
+	if err := broadcast.ResolveSpeakers(buttons, speakers); err != nil {
+		log.Fatalf("Ошибка в кнопках: %v", err)
+	}
+
+	seg, err := broadcast.ParseSegment(*segment)
+	if err != nil {
+		log.Fatalf("Ошибка в сегменте: %v", err)
+	}
+
+	conn, err := db.InitDB()
+	if err != nil {
+		log.Fatalf("Ошибка открытия БД: %v", err)
+	}
+	defer conn.Close()
+
+	users, err := db.GetUsersBySegment(conn, seg)
+	if err != nil {
+		log.Fatalf("Ошибка выборки получателей: %v", err)
+	}
+
+	fmt.Printf("%s\n\n", body)
+	for _, b := range buttons {
+		target := b.URL
+		if target == "" {
+			target = "спикер " + b.Speaker
+		}
+		fmt.Printf("[%s] → %s\n", b.Text, target)
+	}
+	if *photo != "" {
+		fmt.Printf("Фото: %s\n", *photo)
+	}
+	fmt.Printf("Сегмент: %s\nПолучателей: %d\n", broadcast.DescribeSegment(seg), len(users))
+	if !*send {
+		fmt.Println("Это предпросмотр. Чтобы отправить, добавьте -send")
+		return
+	}
+
+	bot, err := tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_TOKEN"))
+	if err != nil {
+		log.Fatalf("Ошибка подключения к Telegram: %v", err)
+	}
+	sender := &broadcast.Sender{Bot: bot, DB: conn, Rate: broadcast.Rate()}
+	report := sender.Send(users, broadcast.Message{Text: body, Photo: *photo, Buttons: buttons})
+	fmt.Println(report)
+}
@@ -0,0 +1,31 @@
+// convertCatalog переводит каталог курсов из courses.csv в YAML или JSON:
+//
+//	go run ./cmd/convertCatalog -in data/courses.csv -out data/courses.yaml
+package main
+
+import (
+	"app/catalog"
+	"flag"
+	"fmt"
+	"log"
+)
+
+func main() {
+	in := flag.String("in", "data/courses.csv", "исходный каталог (.csv, .yaml, .yml, .json)")
+	out := flag.String("out", "data/courses.yaml", "файл результата (.yaml, .yml или .json)")
+	flag.Parse()
+
+	file, err := catalog.Read(*in)
+	if err != nil {
+		log.Fatalf("Ошибка чтения каталога: %v", err)
+	}
+	if err := catalog.Write(*out, file); err != nil {
+		log.Fatalf("Ошибка записи каталога: %v", err)
+	}
+
+	courses := 0
+	for _, s := range file.Speakers {
+		courses += len(s.Courses)
+	}
+	fmt.Printf("Каталог сохранён в %s: спикеров %d, курсов %d\n", *out, len(file.Speakers), courses)
+}
@@ -1,70 +1,52 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/csv"
-	"fmt"
+	"app/db"
+	"flag"
 	"log"
 	"os"
-
-	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
 func main() {
-	db, err := sql.Open("sqlite3", "./db/clients.db")
-	if err != nil {
-		log.Fatalf("Ошибка открытия БД: %v", err)
+	from := flag.String("from", "", "клиенты, заходившие в бот с этой даты (ГГГГ-ММ-ДД)")
+	to := flag.String("to", "", "по эту дату включительно (ГГГГ-ММ-ДД)")
+	speaker := flag.String("speaker", "", "только клиенты, выбравшие спикера")
+	out := flag.String("out", "clients.csv", "файл выгрузки")
+	flag.Parse()
+
+	filter := db.ExportFilter{Speaker: *speaker}
+	if *from != "" {
+		t, err := time.ParseInLocation("2006-01-02", *from, time.Local)
+		if err != nil {
+			log.Fatalf("Неверная дата -from: %v", err)
+		}
+		filter.From = t
 	}
-	defer db.Close()
-
-	rows, err := db.Query("SELECT * FROM users")
-	if err != nil {
-		log.Fatalf("Ошибка запроса: %v", err)
+	if *to != "" {
+		t, err := time.ParseInLocation("2006-01-02", *to, time.Local)
+		if err != nil {
+			log.Fatalf("Неверная дата -to: %v", err)
+		}
+		filter.To = t.AddDate(0, 0, 1)
 	}
-	defer rows.Close()
 
-	columns, err := rows.Columns()
+	conn, err := db.InitDB()
 	if err != nil {
-		log.Fatalf("Ошибка получения колонок: %v", err)
+		log.Fatalf("Ошибка открытия БД: %v", err)
 	}
+	defer conn.Close()
 
-	file, err := os.Create("clients.csv")
+	file, err := os.Create(*out)
 	if err != nil {
 		log.Fatalf("Ошибка создания файла: %v", err)
 	}
 	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	// Записываем заголовки
-	if err := writer.Write(columns); err != nil {
-		log.Fatalf("Ошибка записи заголовков: %v", err)
-	}
-
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-
-	for rows.Next() {
-		for i := range columns {
-			valuePtrs[i] = &values[i]
-		}
-		if err := rows.Scan(valuePtrs...); err != nil {
-			log.Fatalf("Ошибка чтения строки: %v", err)
-		}
-
-		record := make([]string, len(columns))
-		for i, val := range values {
-			if val == nil {
-				record[i] = ""
-			} else {
-				record[i] = fmt.Sprintf("%v", val)
-			}
-		}
-		if err := writer.Write(record); err != nil {
-			log.Fatalf("Ошибка записи строки: %v", err)
-		}
+	n, err := db.ExportUsersCSV(conn, file, filter)
+	if err != nil {
+		log.Fatalf("Ошибка экспорта: %v", err)
 	}
 
-	log.Println("Экспорт завершён: clients.csv")
+	log.Printf("Экспорт завершён: %s, клиентов: %d", *out, n)
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	consentAcceptData = "consent_accept"
+
+	defaultPolicyVersion = "1"
+
+	consentRequestText = "🔒 Чтобы оставить заявку, нам нужно ваше согласие на обработку персональных данных " +
+		"(имя и номер телефона) — для записи на курс и связи с вами менеджера.\n\n" +
+		"Нажимая «✅ Согласен», вы подтверждаете, что ознакомились с политикой обработки персональных данных и даёте согласие."
+	consentAcceptedText = "Спасибо! ✅ Согласие сохранено.\nТеперь поделитесь номером телефона кнопкой ниже 👇"
+)
+
+// privacyPolicyURL - ссылка на политику обработки персональных данных из PRIVACY_POLICY_URL
+func privacyPolicyURL() string {
+	return strings.TrimSpace(os.Getenv("PRIVACY_POLICY_URL"))
+}
+
+// privacyPolicyVersion - текущая версия политики из PRIVACY_POLICY_VERSION. После смены версии
+// согласие спрашивается заново
+func privacyPolicyVersion() string {
+	if v := strings.TrimSpace(os.Getenv("PRIVACY_POLICY_VERSION")); v != "" {
+		return v
+	}
+	return defaultPolicyVersion
+}
+
+// hasConsent проверяет, дал ли пользователь согласие на обработку персональных данных по текущей версии политики
+func hasConsent(chatID int64) bool {
+	at, version, err := db.GetConsent(dbConn, chatID)
+	if err != nil {
+		log.Printf("consent: failed to load consent of chat %d: %v", chatID, err)
+		return false
+	}
+	return at != "" && version == privacyPolicyVersion()
+}
+
+// requestContact просит номер телефона. Без согласия на обработку персональных данных
+// вместо кнопки «Поделиться номером» клиент сначала получает запрос согласия
+func requestContact(bot tools.Sender, chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if !hasConsent(chatID) {
+		tools.SendAndLog(bot, msg)
+		sendConsentRequest(bot, chatID)
+		return
+	}
+	msg.ReplyMarkup = ContactKeyboard()
+	tools.SendAndLog(bot, msg)
+}
+
+// sendConsentRequest присылает ссылку на политику и кнопку согласия
+func sendConsentRequest(bot tools.Sender, chatID int64) {
+	text := consentRequestText
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if url := privacyPolicyURL(); url != "" {
+		text += "\n\n📄 Политика: " + url
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("📄 Политика обработки данных", url),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ Согласен", consentAcceptData),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.DisableWebPagePreview = true
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	tools.SendAndLog(bot, msg)
+}
+
+// acceptConsent сохраняет согласие с текущей версией политики и просит номер телефона
+func acceptConsent(bot tools.Sender, chatID int64) {
+	version := privacyPolicyVersion()
+	if err := db.SaveConsent(dbConn, chatID, version, time.Now()); err != nil {
+		log.Printf("consent: failed to save consent of chat %d: %v", chatID, err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось сохранить согласие, попробуйте ещё раз."))
+		return
+	}
+	log.Printf("consent: chat %d accepted privacy policy %s", chatID, version)
+
+	msg := tgbotapi.NewMessage(chatID, consentAcceptedText)
+	msg.ReplyMarkup = ContactKeyboard()
+	tools.SendAndLog(bot, msg)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"app/db"
+	"strings"
+	"testing"
+)
+
+func TestConsentPolicyVersion(t *testing.T) {
+	const chatID = 6001
+	useTestDB(t)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("PRIVACY_POLICY_URL", "https://example.com/privacy")
+	t.Setenv("PRIVACY_POLICY_VERSION", "2024-05")
+
+	requestContact(bot, chatID, "Оставьте номер")
+	sent := tg.Sent(chatID)
+	if len(sent) != 2 || !strings.Contains(sent[1].Text(), "https://example.com/privacy") ||
+		!strings.Contains(sent[1].ReplyMarkup(), consentAcceptData) {
+		t.Fatalf("consent request = %+v", sent)
+	}
+
+	HandleCallback(bot, callbackUpdate(chatID, consentAcceptData))
+	at, version, err := db.GetConsent(dbConn, chatID)
+	if err != nil || at == "" || version != "2024-05" {
+		t.Fatalf("consent = %q, %q, %v", at, version, err)
+	}
+	if !hasConsent(chatID) {
+		t.Error("consent is not recognized")
+	}
+
+	// новая редакция политики - согласие спрашивается заново
+	t.Setenv("PRIVACY_POLICY_VERSION", "2025-01")
+	tg.Reset()
+	requestContact(bot, chatID, "Оставьте номер")
+	if sent := tg.Sent(chatID); len(sent) != 2 || strings.Contains(sent[0].ReplyMarkup(), "request_contact") {
+		t.Errorf("contact requested without current consent: %+v", sent)
+	}
+}
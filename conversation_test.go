@@ -0,0 +1,175 @@
+package main
+
+import (
+	"app/db"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useDemoCatalog загружает демонстрационный каталог курсов
+func useDemoCatalog(t *testing.T) {
+	t.Helper()
+	prev := Speakers
+	if err := LoadSpeakersFromCSV("data/coursesDemo.csv"); err != nil {
+		t.Fatalf("load catalog: %v", err)
+	}
+	t.Cleanup(func() { Speakers = prev })
+}
+
+func testUser(chatID int64) *tgbotapi.User {
+	return &tgbotapi.User{ID: chatID, FirstName: "Иван", LastName: "Петров"}
+}
+
+func messageUpdate(chatID int64, text string) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		From: testUser(chatID),
+		Chat: &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Text: text,
+	}
+	if strings.HasPrefix(text, "/") {
+		cmd := strings.Fields(text)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len([]rune(cmd))}}
+	}
+	return tgbotapi.Update{Message: msg}
+}
+
+func contactUpdate(chatID int64, phone string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		From:    testUser(chatID),
+		Chat:    &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Contact: &tgbotapi.Contact{PhoneNumber: phone, FirstName: "Иван", UserID: chatID},
+	}}
+}
+
+func callbackUpdate(chatID int64, data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "cb",
+		From:    testUser(chatID),
+		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID, Type: "private"}},
+		Data:    data,
+	}}
+}
+
+func TestConversationStartToLead(t *testing.T) {
+	const chatID = 1001
+	useTestDB(t)
+	useDemoCatalog(t)
+	bitrix, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+
+	speakerIdx := -1
+	for i, s := range Speakers {
+		if strings.HasPrefix(s.Name, "Мария") {
+			speakerIdx = i
+		}
+	}
+	if speakerIdx < 0 {
+		t.Fatal("demo speaker not found")
+	}
+
+	// /start -> приветствие с выбором спикера
+	HandleMessage(bot, messageUpdate(chatID, "/start"))
+	sent := tg.Sent(chatID)
+	if len(sent) != 1 || sent[0].Text() != greetingMessage || !strings.Contains(sent[0].ReplyMarkup(), "speaker_") {
+		t.Fatalf("greeting = %+v", sent)
+	}
+
+	// выбор спикера -> список городов
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(chatID, speakerData(speakerIdx)))
+	sent = tg.Sent(chatID)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text(), "Мария Петрова") || !strings.Contains(sent[0].ReplyMarkup(), "Казань") {
+		t.Fatalf("speaker prompt = %+v", sent)
+	}
+
+	// выбор курса -> заголовок, программа в PDF и следующие шаги
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(chatID, courseData(speakerIdx, 0)))
+	sent = tg.Sent(chatID)
+	if len(sent) != 3 {
+		t.Fatalf("course messages = %+v", sent)
+	}
+	if sent[1].Method != "sendDocument" || sent[1].Files["document"] != "dummy.pdf" {
+		t.Errorf("program = %+v", sent[1])
+	}
+	if !strings.Contains(sent[2].ReplyMarkup(), "book_course") {
+		t.Errorf("actions = %+v", sent[2])
+	}
+	if n := len(bitrix.Items()); n != 0 {
+		t.Fatalf("lead synced before contact: %d items", n)
+	}
+
+	// "Оставить заявку" -> инструкция и запрос согласия на обработку персональных данных
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(chatID, "book_course"))
+	sent = tg.Sent(chatID)
+	if len(sent) != 2 || strings.Contains(sent[0].ReplyMarkup(), "request_contact") ||
+		!strings.Contains(sent[1].ReplyMarkup(), consentAcceptData) {
+		t.Fatalf("booking = %+v", sent)
+	}
+
+	// контакт без согласия не сохраняется
+	tg.Reset()
+	HandleMessage(bot, contactUpdate(chatID, "79991234567"))
+	if user, _ := db.GetUserByChatID(dbConn, chatID); user == nil || user.Phone != "" {
+		t.Fatalf("phone saved without consent: %+v", user)
+	}
+	if texts := sentTexts(tg, chatID); len(texts) != 1 || !strings.HasPrefix(texts[0], "🔒") {
+		t.Fatalf("contact without consent = %v", texts)
+	}
+
+	// согласие -> кнопка отправки контакта
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(chatID, consentAcceptData))
+	sent = tg.Sent(chatID)
+	if len(sent) != 1 || !strings.Contains(sent[0].ReplyMarkup(), "request_contact") {
+		t.Fatalf("consent = %+v", sent)
+	}
+
+	// контакт -> подтверждение и лид в Bitrix24
+	tg.Reset()
+	HandleMessage(bot, contactUpdate(chatID, "79991234567"))
+	// подтверждение и личная ссылка для приглашения друзей
+	texts := sentTexts(tg, chatID)
+	if len(texts) != 2 || texts[0] != contactConfirmationMessage || !strings.Contains(texts[1], "?start=ref-") {
+		t.Fatalf("confirmation = %v", texts)
+	}
+
+	items := bitrix.Items()
+	if len(items) != 1 || items[0].Title != "Telegram - Мария Петрова (pdf) - Казань | 15 июля" {
+		t.Fatalf("items = %+v", items)
+	}
+	comments := bitrix.Comments()
+	if len(comments) != 1 || !strings.Contains(comments[0].Comment, "dummy.pdf") {
+		t.Errorf("comments = %+v", comments)
+	}
+
+	user, err := db.GetUserByChatID(dbConn, chatID)
+	if err != nil || user == nil || user.Phone != "+79991234567" || user.Speaker != "Мария Петрова (pdf)" {
+		t.Errorf("user = %+v, %v", user, err)
+	}
+}
+
+func TestConversationFreeTextBeforeCourse(t *testing.T) {
+	const chatID = 1002
+	useTestDB(t)
+	useDemoCatalog(t)
+	bitrix, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+
+	HandleMessage(bot, messageUpdate(chatID, "Здравствуйте, есть курсы в Казани?"))
+
+	if texts := sentTexts(tg, chatID); len(texts) != 1 || texts[0] != greetingMessage {
+		t.Fatalf("sent = %v", texts)
+	}
+	if calls := bitrix.Calls(); len(calls) != 0 {
+		t.Errorf("bitrix calls = %v, want none before contact", calls)
+	}
+	if s := snapshotSession(chatID); s == nil || len(s.ClientMessages) != 1 {
+		t.Errorf("session = %+v", s)
+	}
+}
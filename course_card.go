@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// courseCardText собирает карточку курса в HTML-разметке Telegram
+func courseCardText(speakerName string, c Course) string {
+	var b strings.Builder
+
+	title := c.Title
+	if title == "" {
+		title = speakerName
+	}
+	fmt.Fprintf(&b, "<b>%s</b>\n", html.EscapeString(title))
+	if c.Title != "" {
+		fmt.Fprintf(&b, "🎓 %s\n", html.EscapeString(speakerName))
+	}
+	fmt.Fprintf(&b, "📍 %s\n", html.EscapeString(c.City))
+
+	if c.Description != "" {
+		fmt.Fprintf(&b, "\n%s\n", html.EscapeString(c.Description))
+	}
+
+	details := []struct{ label, value string }{
+		{"💰 Стоимость", c.Price},
+		{"⏱ Длительность", c.Duration},
+		{"💻 Формат", courseFormatName(c.Format)},
+		{"🏠 Адрес", c.Address},
+	}
+	first := true
+	for _, d := range details {
+		if d.value == "" {
+			continue
+		}
+		if first {
+			b.WriteString("\n")
+			first = false
+		}
+		fmt.Fprintf(&b, "%s: %s\n", d.label, html.EscapeString(d.value))
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// courseFormatName приводит формат из каталога к виду для клиента
+func courseFormatName(format string) string {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "online", "онлайн":
+		return "онлайн"
+	case "offline", "офлайн", "оффлайн":
+		return "офлайн"
+	default:
+		return strings.TrimSpace(format)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadSpeakersCardColumns(t *testing.T) {
+	useDemoCatalog(t)
+
+	var ivan *Speaker
+	for i := range Speakers {
+		if strings.HasPrefix(Speakers[i].Name, "Иван") {
+			ivan = &Speakers[i]
+		}
+	}
+	if ivan == nil || len(ivan.Courses) != 2 {
+		t.Fatalf("speaker = %+v", ivan)
+	}
+	for _, c := range ivan.Courses {
+		if c.Title != "Стрижки для начинающих" || c.Price != "25 000 ₽" || c.Address != "ул. Тверская, 1" || c.Cover != "Петр/img.png" {
+			t.Errorf("course = %+v", c)
+		}
+	}
+}
+
+func TestCourseCardText(t *testing.T) {
+	card := courseCardText("Иван <Иванов>", Course{
+		City:     "Москва | 12 июня",
+		Title:    "Стрижки",
+		Price:    "25 000 ₽",
+		Format:   "Online",
+		Duration: "",
+	})
+	want := "<b>Стрижки</b>\n🎓 Иван &lt;Иванов&gt;\n📍 Москва | 12 июня\n\n💰 Стоимость: 25 000 ₽\n💻 Формат: онлайн"
+	if card != want {
+		t.Errorf("card = %q, want %q", card, want)
+	}
+	if (Course{City: "Москва"}).HasCard() {
+		t.Error("course without details should use the plain header")
+	}
+}
+
+func TestConversationCourseCard(t *testing.T) {
+	const chatID = 1003
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+
+	speakerIdx := -1
+	for i, s := range Speakers {
+		if strings.HasPrefix(s.Name, "Иван") {
+			speakerIdx = i
+		}
+	}
+
+	HandleCallback(bot, callbackUpdate(chatID, courseData(speakerIdx, 0)))
+	sent := tg.Sent(chatID)
+	if len(sent) != 3 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	card := sent[0]
+	if card.Method != "sendPhoto" || card.Params.Get("parse_mode") != "HTML" ||
+		!strings.Contains(card.Params.Get("caption"), "<b>Стрижки для начинающих</b>") {
+		t.Errorf("card = %+v", card)
+	}
+	if sent[1].Text() != "Описание курса текстом" {
+		t.Errorf("program = %+v", sent[1])
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"app/db"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CRM - внешняя система, в которую бот передаёт заявки клиентов
+type CRM interface {
+	// FindOrCreateContact ищет контакт по телефону или создаёт новый, возвращает его ID
+	FindOrCreateContact(ctx context.Context, contact CRMContact) (string, error)
+	// CreateDeal создаёт сделку (элемент смарт-процесса, лид) для контакта, возвращает её ID
+	CreateDeal(ctx context.Context, contactID string, deal CRMDeal) (string, error)
+	// AddNote добавляет текстовое примечание к сделке
+	AddNote(ctx context.Context, dealID, text string) error
+}
+
+// crmDealSyncer реализуют CRM, которые умеют создать контакт, сделку и примечание за меньшее число запросов
+type crmDealSyncer interface {
+	SyncDeal(ctx context.Context, contact CRMContact, deal CRMDeal, note string) (string, string, error)
+}
+
+// CRMContact - данные контакта клиента
+type CRMContact struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"` // в международном формате
+}
+
+// CRMDeal - данные заявки на курс
+type CRMDeal struct {
+	Title    string
+	UTM      db.UTM // метки источника клиента
+	Referrer string // кто пригласил клиента по личной ссылке
+
+	PromoCode  string // применённый промокод
+	Price      int    // стоимость курса в рублях, 0 - не указана в каталоге
+	FinalPrice int    // стоимость с учётом промокода
+}
+
+var (
+	// ленивое создание CRM по конфигурации
+	crmOnce sync.Once
+	crmInst CRM
+	crmErr  error
+)
+
+// getCRM возвращает синглтон CRM, выбранной переменной окружения CRM_PROVIDER:
+// bitrix (по умолчанию), amocrm, webhook или none
+func getCRM() (CRM, error) {
+	crmOnce.Do(func() {
+		crmInst, crmErr = newCRM(os.Getenv("CRM_PROVIDER"))
+		if crmErr == nil {
+			log.Printf("crm: using %T", crmInst)
+		}
+	})
+	return crmInst, crmErr
+}
+
+func newCRM(provider string) (CRM, error) {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "", "bitrix", "bitrix24":
+		client, err := getBitrixClient()
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case "amocrm", "amo":
+		return newAmoCRMClient()
+	case "webhook":
+		return newWebhookCRM()
+	case "none", "noop":
+		return noopCRM{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CRM_PROVIDER %q", provider)
+	}
+}
+
+// syncCRMDeal создаёт контакт, сделку и примечание, используя пакетный режим CRM, если он есть
+func syncCRMDeal(ctx context.Context, crm CRM, contact CRMContact, deal CRMDeal, note string) (string, string, error) {
+	if syncer, ok := crm.(crmDealSyncer); ok {
+		return syncer.SyncDeal(ctx, contact, deal, note)
+	}
+
+	contactID, err := crm.FindOrCreateContact(ctx, contact)
+	if err != nil {
+		return "", "", err
+	}
+	dealID, err := crm.CreateDeal(ctx, contactID, deal)
+	if err != nil {
+		return "", "", err
+	}
+	if dealID == "" {
+		return "", "", errors.New("crm returned empty deal id")
+	}
+
+	// примечание не критично для сделки - ошибку только логируем
+	if note != "" {
+		if err := crm.AddNote(ctx, dealID, note); err != nil {
+			log.Printf("crm: add note error for deal %s: %v", dealID, err)
+		}
+	}
+	return contactID, dealID, nil
+}
+
+// noopCRM никуда не передаёт заявки - для ботов без CRM
+type noopCRM struct{}
+
+func (noopCRM) FindOrCreateContact(_ context.Context, contact CRMContact) (string, error) {
+	return contact.Phone, nil
+}
+
+func (noopCRM) CreateDeal(_ context.Context, contactID string, _ CRMDeal) (string, error) {
+	return "noop-" + contactID, nil
+}
+
+func (noopCRM) AddNote(context.Context, string, string) error {
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AmoCRMClient передаёт заявки в amoCRM через REST API v4 с долгосрочным токеном
+type AmoCRMClient struct {
+	baseURL       string
+	token         string
+	pipelineID    int // воронка для новых сделок, 0 - воронка по умолчанию
+	responsibleID int // ответственный за сделки, 0 - по умолчанию
+	httpClient    *http.Client
+}
+
+// newAmoCRMClient создаёт клиента amoCRM по переменным окружения AMO_BASE и AMO_TOKEN
+func newAmoCRMClient() (*AmoCRMClient, error) {
+	base := strings.TrimRight(os.Getenv("AMO_BASE"), "/")
+	if base == "" {
+		return nil, errors.New("AMO_BASE env is empty")
+	}
+	token := strings.TrimSpace(os.Getenv("AMO_TOKEN"))
+	if token == "" {
+		return nil, errors.New("AMO_TOKEN env is empty")
+	}
+
+	client := &AmoCRMClient{
+		baseURL: base,
+		token:   token,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+	if v := os.Getenv("AMO_PIPELINE_ID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AMO_PIPELINE_ID: %w", err)
+		}
+		client.pipelineID = id
+	}
+	if v := os.Getenv("AMO_RESPONSIBLE_USER_ID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AMO_RESPONSIBLE_USER_ID: %w", err)
+		}
+		client.responsibleID = id
+	}
+	return client, nil
+}
+
+// FindOrCreateContact реализует CRM
+func (c *AmoCRMClient) FindOrCreateContact(ctx context.Context, contact CRMContact) (string, error) {
+	var found struct {
+		Embedded struct {
+			Contacts []struct {
+				ID int `json:"id"`
+			} `json:"contacts"`
+		} `json:"_embedded"`
+	}
+	query := url.Values{"query": {strings.TrimPrefix(contact.Phone, "+")}}
+	if err := c.do(ctx, http.MethodGet, "/api/v4/contacts?"+query.Encode(), nil, &found); err != nil {
+		return "", err
+	}
+	if len(found.Embedded.Contacts) > 0 {
+		return strconv.Itoa(found.Embedded.Contacts[0].ID), nil
+	}
+
+	newContact := map[string]any{
+		"name": contact.Name,
+		"custom_fields_values": []map[string]any{
+			{
+				"field_code": "PHONE",
+				"values": []map[string]string{
+					{"value": contact.Phone, "enum_code": "WORK"},
+				},
+			},
+		},
+	}
+	if c.responsibleID != 0 {
+		newContact["responsible_user_id"] = c.responsibleID
+	}
+
+	var created struct {
+		Embedded struct {
+			Contacts []struct {
+				ID int `json:"id"`
+			} `json:"contacts"`
+		} `json:"_embedded"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v4/contacts", []map[string]any{newContact}, &created); err != nil {
+		return "", err
+	}
+	if len(created.Embedded.Contacts) == 0 {
+		return "", errors.New("amocrm: empty contact add result")
+	}
+	return strconv.Itoa(created.Embedded.Contacts[0].ID), nil
+}
+
+// CreateDeal реализует CRM: создаёт сделку с привязанным контактом
+func (c *AmoCRMClient) CreateDeal(ctx context.Context, contactID string, deal CRMDeal) (string, error) {
+	idInt, err := strconv.Atoi(contactID)
+	if err != nil {
+		return "", fmt.Errorf("invalid contact id %s: %w", contactID, err)
+	}
+
+	// источник из ссылки /start попадает тегом, подробные метки - в примечание
+	tags := []map[string]string{{"name": "Telegram"}}
+	if deal.UTM.Source != "" {
+		tags = append(tags, map[string]string{"name": deal.UTM.Source})
+	}
+	lead := map[string]any{
+		"name": fmt.Sprintf("Telegram - %s", deal.Title),
+		"_embedded": map[string]any{
+			"contacts": []map[string]int{{"id": idInt}},
+			"tags":     tags,
+		},
+	}
+	if deal.FinalPrice > 0 {
+		lead["price"] = deal.FinalPrice
+	}
+	if c.pipelineID != 0 {
+		lead["pipeline_id"] = c.pipelineID
+	}
+	if c.responsibleID != 0 {
+		lead["responsible_user_id"] = c.responsibleID
+	}
+
+	var created struct {
+		Embedded struct {
+			Leads []struct {
+				ID int `json:"id"`
+			} `json:"leads"`
+		} `json:"_embedded"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v4/leads", []map[string]any{lead}, &created); err != nil {
+		return "", err
+	}
+	if len(created.Embedded.Leads) == 0 {
+		return "", errors.New("amocrm: empty lead add result")
+	}
+	return strconv.Itoa(created.Embedded.Leads[0].ID), nil
+}
+
+// AddNote реализует CRM: добавляет обычное примечание к сделке
+func (c *AmoCRMClient) AddNote(ctx context.Context, dealID, text string) error {
+	note := map[string]any{
+		"note_type": "common",
+		"params":    map[string]string{"text": text},
+	}
+	return c.do(ctx, http.MethodPost, "/api/v4/leads/"+url.PathEscape(dealID)+"/notes", []map[string]any{note}, nil)
+}
+
+// do выполняет запрос к API amoCRM и распаковывает ответ в out. Ответ 204 означает пустой результат
+func (c *AmoCRMClient) do(ctx context.Context, method, endpoint string, payload any, out any) error {
+	var body io.Reader
+	var rawBody []byte
+	if payload != nil {
+		var err error
+		rawBody, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(rawBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Printf("amocrm: http %d response: %s. request: %s", resp.StatusCode, string(respBody), string(rawBody))
+		return fmt.Errorf("amocrm http %d", resp.StatusCode)
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
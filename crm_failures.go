@@ -0,0 +1,57 @@
+package main
+
+import (
+	"app/db"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// saveFailedSync запоминает заявку, которую не удалось передать в CRM, чтобы администратор мог отправить её повторно.
+// Заявки на разные сессии курсов хранятся отдельно, как и их сделки
+func saveFailedSync(chatID int64, session *bitrixSession, syncErr error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		log.Printf("crm: failed to encode session for chat %d: %v", chatID, err)
+		return
+	}
+	key := sessionKey(session.SpeakerName, session.City)
+	if err := db.SaveFailedSync(dbConn, chatID, key, string(data), syncErr.Error()); err != nil {
+		log.Printf("crm: failed to save sync error for chat %d: %v", chatID, err)
+	}
+}
+
+// retryFailedSync повторно передаёт в CRM сохранённую заявку. Клиенту ничего не отправляется:
+// о проблеме он уже знает, а менеджер свяжется с ним по заявке
+func retryFailedSync(id int64) error {
+	failed, err := db.GetFailedSync(dbConn, id)
+	if err != nil {
+		return err
+	}
+	if failed == nil {
+		return errors.New("заявка не найдена, возможно, уже передана")
+	}
+	chatID := failed.ChatID
+	var session bitrixSession
+	if err := json.Unmarshal([]byte(failed.Session), &session); err != nil {
+		return err
+	}
+	if syncedBitrixItem(chatID, failed.SessionKey) != "" {
+		// клиент успел повторить заявку сам
+		return db.DeleteFailedSync(dbConn, chatID, failed.SessionKey)
+	}
+	phone, err := normalizePhone(session.Phone)
+	if err != nil {
+		return err
+	}
+
+	crm, err := getCRM()
+	if err == nil {
+		err = syncSession(chatID, &session, crm, phone)
+	}
+	if err != nil {
+		saveFailedSync(chatID, &session, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"app/db"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Заголовок с подписью тела запроса: sha256=<hex HMAC-SHA256 с секретом CRM_WEBHOOK_SECRET>
+const crmWebhookSignatureHeader = "X-Signature"
+
+// WebhookCRM отправляет заявки JSON POST-запросами на произвольный адрес партнёра
+type WebhookCRM struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+
+	// контакты между FindOrCreateContact и CreateDeal: ID контакта - телефон, имя хранится здесь
+	contactsMu sync.Mutex
+	contacts   map[string]CRMContact
+}
+
+// crmWebhookEvent - тело запроса к вебхуку партнёра
+type crmWebhookEvent struct {
+	Event     string      `json:"event"` // deal.created или note.added
+	DealID    string      `json:"deal_id"`
+	Contact   *CRMContact `json:"contact,omitempty"`
+	Title     string      `json:"title,omitempty"`
+	UTM       *db.UTM     `json:"utm,omitempty"`
+	Referrer  string      `json:"referrer,omitempty"`
+	PromoCode string      `json:"promo_code,omitempty"`
+	Price     int         `json:"price,omitempty"`  // рубли, по каталогу
+	Amount    int         `json:"amount,omitempty"` // рубли, с учётом промокода
+	Note      string      `json:"note,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// newWebhookCRM создаёт CRM-вебхук по переменным окружения CRM_WEBHOOK_URL и CRM_WEBHOOK_SECRET
+func newWebhookCRM() (*WebhookCRM, error) {
+	url := strings.TrimSpace(os.Getenv("CRM_WEBHOOK_URL"))
+	if url == "" {
+		return nil, errors.New("CRM_WEBHOOK_URL env is empty")
+	}
+	secret := os.Getenv("CRM_WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, errors.New("CRM_WEBHOOK_SECRET env is empty")
+	}
+	return &WebhookCRM{
+		url:    url,
+		secret: secret,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		contacts: make(map[string]CRMContact),
+	}, nil
+}
+
+// FindOrCreateContact реализует CRM: у вебхука нет справочника контактов, ID контакта - телефон.
+// Контакт запоминается, чтобы имя клиента попало в сделку
+func (w *WebhookCRM) FindOrCreateContact(_ context.Context, contact CRMContact) (string, error) {
+	w.contactsMu.Lock()
+	w.contacts[contact.Phone] = contact
+	w.contactsMu.Unlock()
+	return contact.Phone, nil
+}
+
+// CreateDeal реализует CRM: ID сделки генерируется ботом и передаётся партнёру
+func (w *WebhookCRM) CreateDeal(ctx context.Context, contactID string, deal CRMDeal) (string, error) {
+	w.contactsMu.Lock()
+	contact, ok := w.contacts[contactID]
+	delete(w.contacts, contactID)
+	w.contactsMu.Unlock()
+	if !ok {
+		contact = CRMContact{Phone: contactID}
+	}
+	return w.createDeal(ctx, contact, deal, "")
+}
+
+// AddNote реализует CRM
+func (w *WebhookCRM) AddNote(ctx context.Context, dealID, text string) error {
+	return w.send(ctx, crmWebhookEvent{
+		Event:  "note.added",
+		DealID: dealID,
+		Note:   text,
+	})
+}
+
+// SyncDeal реализует crmDealSyncer: заявка целиком уходит одним запросом
+func (w *WebhookCRM) SyncDeal(ctx context.Context, contact CRMContact, deal CRMDeal, note string) (string, string, error) {
+	dealID, err := w.createDeal(ctx, contact, deal, note)
+	if err != nil {
+		return "", "", err
+	}
+	return contact.Phone, dealID, nil
+}
+
+func (w *WebhookCRM) createDeal(ctx context.Context, contact CRMContact, deal CRMDeal, note string) (string, error) {
+	dealID, err := newWebhookDealID()
+	if err != nil {
+		return "", err
+	}
+	event := crmWebhookEvent{
+		Event:     "deal.created",
+		DealID:    dealID,
+		Contact:   &contact,
+		Title:     deal.Title,
+		Referrer:  deal.Referrer,
+		PromoCode: deal.PromoCode,
+		Price:     deal.Price,
+		Amount:    deal.FinalPrice,
+		Note:      note,
+	}
+	if !deal.UTM.IsZero() {
+		event.UTM = &deal.UTM
+	}
+	err = w.send(ctx, event)
+	if err != nil {
+		return "", err
+	}
+	return dealID, nil
+}
+
+// send подписывает и отправляет событие, любой ответ кроме 2xx считается ошибкой
+func (w *WebhookCRM) send(ctx context.Context, event crmWebhookEvent) error {
+	event.Timestamp = time.Now().Unix()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(crmWebhookSignatureHeader, "sha256="+signWebhookBody(w.secret, body))
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		log.Printf("crm webhook: http %d response: %s. event: %s", resp.StatusCode, string(respBody), event.Event)
+		return fmt.Errorf("crm webhook http %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhookBody возвращает hex HMAC-SHA256 тела запроса
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookDealID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "tg-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookCRMDealKeepsContactName(t *testing.T) {
+	var events []crmWebhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event crmWebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("CRM_WEBHOOK_URL", server.URL)
+	t.Setenv("CRM_WEBHOOK_SECRET", "s3cret")
+	crm, err := newWebhookCRM()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// общий путь CRM: контакт, затем сделка
+	ctx := context.Background()
+	contactID, err := crm.FindOrCreateContact(ctx, CRMContact{Name: "Иван Петров", Phone: "+79991234567"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := crm.CreateDeal(ctx, contactID, CRMDeal{Title: "Telegram - Мария - Казань | 15 июля"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Contact == nil ||
+		*events[0].Contact != (CRMContact{Name: "Иван Петров", Phone: "+79991234567"}) {
+		t.Errorf("events = %+v", events)
+	}
+}
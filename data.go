@@ -1,15 +1,35 @@
 package main
 
 import (
-	"encoding/csv"
+	"app/catalog"
 	"log"
 	"os"
-	"sort"
-	"strings"
 )
 
+// Путь к каталогу по умолчанию
+const defaultCatalogPath = "data/courses.csv"
+
 var Speakers []Speaker
 
+// catalogPath возвращает путь к каталогу курсов (CATALOG_PATH), формат определяется по расширению
+func catalogPath() string {
+	if path := os.Getenv("CATALOG_PATH"); path != "" {
+		return path
+	}
+	return defaultCatalogPath
+}
+
+// LoadSpeakers загружает каталог из CSV, YAML или JSON в зависимости от расширения файла
+func LoadSpeakers(path string) error {
+	speakers, err := catalog.Load(path)
+	if err != nil {
+		return err
+	}
+	Speakers = speakers
+	return nil
+}
+
+// LoadSpeakersFromCSV загружает каталог из courses.csv независимо от расширения файла
 func LoadSpeakersFromCSV(path string) error {
 	file, err := os.Open(path)
 	if err != nil {
@@ -22,46 +42,13 @@ func LoadSpeakersFromCSV(path string) error {
 		}
 	}(file)
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	catalogFile, err := catalog.ParseCSV(file)
 	if err != nil {
 		return err
 	}
-
-	speakersMap := make(map[string]*Speaker)
-	for _, rec := range records[1:] { // пропускаем заголовок
-		name, cityRaw, program := rec[0], rec[1], rec[2]
-
-		// Разбиваем cityRaw по ";" и убираем пробелы
-		cities := strings.Split(cityRaw, ";")
-		for _, c := range cities {
-			city := strings.TrimSpace(c)
-			if city == "" {
-				continue // пропустить пустые строки
-			}
-
-			if speakersMap[name] == nil {
-				speakersMap[name] = &Speaker{Name: name}
-			}
-
-			course := Course{
-				City:    city,
-				Program: program,
-			}
-
-			speakersMap[name].Courses = append(speakersMap[name].Courses, course)
-		}
-	}
-
-	Speakers = nil
-	for _, s := range speakersMap {
-		Speakers = append(Speakers, *s)
+	if err := catalogFile.Validate(); err != nil {
+		return err
 	}
-
-	// Сортировка по алфавиту
-	sort.Slice(Speakers, func(i, j int) bool {
-		return Speakers[i].Name < Speakers[j].Name
-	})
-
+	Speakers = catalogFile.Flatten()
 	return nil
 }
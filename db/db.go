@@ -2,13 +2,21 @@ package db
 
 import (
 	"database/sql"
+	"encoding/csv"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"io"
 	"log"
 	"time"
 )
 
 func InitDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./db/clients.db")
+	return Open("./db/clients.db")
+}
+
+// Open открывает базу по указанному пути и создаёт недостающие таблицы
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
@@ -22,21 +30,264 @@ func InitDB() (*sql.DB, error) {
             date TEXT
         )
     `)
-	return db, err
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS bitrix_items (
+            item_id TEXT PRIMARY KEY,
+            chat_id INTEGER NOT NULL,
+            stage_id TEXT,
+            date TEXT
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS file_cache (
+            path TEXT PRIMARY KEY,
+            hash TEXT NOT NULL,
+            file_id TEXT NOT NULL,
+            date TEXT
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS bookings (
+            chat_id INTEGER NOT NULL,
+            session TEXT NOT NULL,
+            item_id TEXT,
+            status TEXT NOT NULL,
+            date TEXT,
+            PRIMARY KEY (chat_id, session)
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS waitlist (
+            chat_id INTEGER NOT NULL,
+            speaker TEXT NOT NULL,
+            city TEXT NOT NULL,
+            date TEXT,
+            PRIMARY KEY (chat_id, speaker, city)
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS reminders (
+            chat_id INTEGER NOT NULL,
+            session TEXT NOT NULL,
+            remind_before TEXT NOT NULL,
+            date TEXT,
+            PRIMARY KEY (chat_id, session, remind_before)
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS funnel (
+            chat_id INTEGER PRIMARY KEY,
+            step TEXT NOT NULL,
+            speaker TEXT,
+            city TEXT,
+            updated_at TEXT NOT NULL,
+            followed_up INTEGER NOT NULL DEFAULT 0,
+            followups INTEGER NOT NULL DEFAULT 0,
+            opted_out INTEGER NOT NULL DEFAULT 0
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS crm_failures (
+            id INTEGER PRIMARY KEY,
+            chat_id INTEGER NOT NULL,
+            session_key TEXT NOT NULL,
+            session TEXT NOT NULL,
+            error TEXT NOT NULL,
+            date TEXT NOT NULL,
+            UNIQUE (chat_id, session_key)
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	if err := migrateFailedSyncs(db); err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS jobs (
+            name TEXT PRIMARY KEY,
+            last_run TEXT NOT NULL
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS promo_codes (
+            code TEXT PRIMARY KEY COLLATE NOCASE,
+            percent INTEGER NOT NULL,
+            amount INTEGER NOT NULL,
+            valid_from TEXT NOT NULL DEFAULT '',
+            valid_to TEXT NOT NULL DEFAULT '',
+            max_uses INTEGER NOT NULL DEFAULT 0,
+            speaker TEXT NOT NULL DEFAULT '',
+            city TEXT NOT NULL DEFAULT '',
+            date TEXT
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS promo_uses (
+            code TEXT NOT NULL COLLATE NOCASE,
+            chat_id INTEGER NOT NULL,
+            date TEXT,
+            PRIMARY KEY (code, chat_id)
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS payments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            chat_id INTEGER NOT NULL,
+            session TEXT NOT NULL,
+            provider TEXT NOT NULL,
+            amount INTEGER NOT NULL,
+            currency TEXT NOT NULL,
+            status TEXT NOT NULL,
+            charge_id TEXT NOT NULL DEFAULT '',
+            provider_charge_id TEXT NOT NULL DEFAULT '',
+            item_id TEXT NOT NULL DEFAULT '',
+            date TEXT,
+            paid_at TEXT NOT NULL DEFAULT ''
+        )
+    `)
+	if err != nil {
+		return db, err
+	}
+	for _, column := range []string{"blocked", "referred_by"} {
+		if err := addColumn(db, "users", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return db, err
+		}
+	}
+	// параметр ссылки t.me/<бот>?start=<payload> и метки источника, запоминаются при первом переходе
+	// согласие на обработку персональных данных: когда дано и какой версии политики
+	// first_seen - первый заход клиента в бот, в отличие от date не меняется при следующих визитах
+	// referral_code - случайный код личной ссылки для приглашения друзей
+	for _, column := range []string{"start_payload", "utm_source", "utm_medium", "utm_campaign", "utm_content",
+		"consent_at", "consent_version", "first_seen", "referral_code"} {
+		if err := addColumn(db, "users", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return db, err
+		}
+	}
+	// confirmed=0 - клиент применил промокод, но заявка ещё не передана в CRM: использование зарезервировано.
+	// Старые записи появлялись только при передаче заявки
+	if err := addColumn(db, "promo_uses", "confirmed", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return db, err
+	}
+	// размер и время изменения файла, для которого посчитан хеш: пока они те же, файл не перечитываем
+	if err := addColumn(db, "file_cache", "size", "INTEGER NOT NULL DEFAULT -1"); err != nil {
+		return db, err
+	}
+	if err := addColumn(db, "file_cache", "mod_time", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return db, err
+	}
+	// в старых базах первого захода нет - берём последний известный
+	if _, err := db.Exec("UPDATE users SET first_seen = COALESCE(date, '') WHERE first_seen = ''"); err != nil {
+		return db, err
+	}
+	return db, nil
+}
+
+// addColumn добавляет колонку в существующую таблицу, если её ещё нет (миграция старых баз)
+func addColumn(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// hasColumn сообщает, есть ли колонка в таблице
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	return n > 0, err
+}
+
+// migrateFailedSyncs переносит ошибки синхронизации из старой таблицы, где на чат была одна запись,
+// в таблицу с записью на каждую сессию курса. Ключ сессии берётся из сохранённого состояния диалога
+func migrateFailedSyncs(db *sql.DB) error {
+	migrated, err := hasColumn(db, "crm_failures", "session_key")
+	if err != nil || migrated {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, query := range []string{
+		"ALTER TABLE crm_failures RENAME TO crm_failures_old",
+		`CREATE TABLE crm_failures (
+            id INTEGER PRIMARY KEY,
+            chat_id INTEGER NOT NULL,
+            session_key TEXT NOT NULL,
+            session TEXT NOT NULL,
+            error TEXT NOT NULL,
+            date TEXT NOT NULL,
+            UNIQUE (chat_id, session_key)
+        )`,
+		`INSERT INTO crm_failures (chat_id, session_key, session, error, date)
+        SELECT chat_id,
+            trim(COALESCE(json_extract(session, '$.SpeakerName'), '')) || ' / ' ||
+            trim(COALESCE(json_extract(session, '$.City'), '')),
+            session, error, date
+        FROM crm_failures_old`,
+		"DROP TABLE crm_failures_old",
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UserExists сообщает, писал ли пользователь боту раньше
+func UserExists(db *sql.DB, chatID int64) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE chat_id = ?", chatID).Scan(&n)
+	return n > 0, err
 }
 
 func UpsertUser(db *sql.DB, chatID int64, phone, fio, city, speaker string) error {
 	now := time.Now().Format("2006-01-02 15:04:05")
 	_, err := db.Exec(`
-        INSERT INTO users (chat_id, phone, fio, city, speaker, date)
-        VALUES (?, ?, ?, ?, ?, ?)
+        INSERT INTO users (chat_id, phone, fio, city, speaker, date, first_seen)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
         ON CONFLICT(chat_id) DO UPDATE SET
             phone=COALESCE(NULLIF(excluded.phone, ''), users.phone),
             fio=COALESCE(NULLIF(excluded.fio, ''), users.fio),
             city=COALESCE(NULLIF(excluded.city, ''), users.city),
             speaker=COALESCE(NULLIF(excluded.speaker, ''), users.speaker),
-            date=excluded.date
-    `, chatID, phone, fio, city, speaker, now)
+            date=excluded.date,
+            blocked=0
+    `, chatID, phone, fio, city, speaker, now, now)
 
 	log.Printf("Сохраняем (%d, '%s', '%s', '%s', '%s', '%s')", chatID, phone, fio, city, speaker, now)
 	return err
@@ -80,3 +331,1108 @@ func GetUserByChatID(db *sql.DB, chatID int64) (*User, error) {
 	}
 	return &u, nil
 }
+
+// UTM - метки рекламного источника, из которого пользователь пришёл в бота
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// IsZero сообщает, что меток нет
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+// SaveUserSource запоминает параметр /start и метки пользователя, если они ещё не сохранены:
+// источником считается первый переход по ссылке. false - у пользователя уже есть источник
+func SaveUserSource(db *sql.DB, chatID int64, payload string, utm UTM) (bool, error) {
+	res, err := db.Exec(`
+        UPDATE users SET start_payload = ?, utm_source = ?, utm_medium = ?, utm_campaign = ?, utm_content = ?
+        WHERE chat_id = ? AND start_payload = ''
+    `, payload, utm.Source, utm.Medium, utm.Campaign, utm.Content, chatID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetUserUTM возвращает метки источника пользователя; пустые, если он пришёл без ссылки с меткой
+func GetUserUTM(db *sql.DB, chatID int64) (UTM, error) {
+	var u UTM
+	err := db.QueryRow(
+		"SELECT utm_source, utm_medium, utm_campaign, utm_content FROM users WHERE chat_id = ?", chatID,
+	).Scan(&u.Source, &u.Medium, &u.Campaign, &u.Content)
+	if err == sql.ErrNoRows {
+		return UTM{}, nil
+	}
+	return u, err
+}
+
+// SaveReferrer запоминает, кто пригласил пользователя. Пригласить может только клиент с бронью,
+// и только один раз. Что пользователь новый, проверяет вызывающий (см. UserExists).
+// false - приглашение не засчитано
+func SaveReferrer(db *sql.DB, chatID, referrer int64) (bool, error) {
+	res, err := db.Exec(`
+        UPDATE users SET referred_by = ?
+        WHERE chat_id = ? AND chat_id != ? AND referred_by = 0
+            AND EXISTS (SELECT 1 FROM bookings WHERE chat_id = ?)
+    `, referrer, chatID, referrer, referrer)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EnsureReferralCode сохраняет код приглашения пользователя, если его ещё нет, и возвращает действующий код
+func EnsureReferralCode(db *sql.DB, chatID int64, code string) (string, error) {
+	if _, err := db.Exec("UPDATE users SET referral_code = ? WHERE chat_id = ? AND referral_code = ''", code, chatID); err != nil {
+		return "", err
+	}
+	var saved string
+	err := db.QueryRow("SELECT referral_code FROM users WHERE chat_id = ?", chatID).Scan(&saved)
+	return saved, err
+}
+
+// FindReferralCode возвращает chat ID владельца кода приглашения или 0, если такого кода нет
+func FindReferralCode(db *sql.DB, code string) (int64, error) {
+	if code == "" {
+		return 0, nil
+	}
+	var chatID int64
+	err := db.QueryRow("SELECT chat_id FROM users WHERE referral_code = ?", code).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return chatID, err
+}
+
+// GetReferrer возвращает пользователя, пригласившего chatID, или nil
+func GetReferrer(db *sql.DB, chatID int64) (*User, error) {
+	row := db.QueryRow(`
+        SELECT r.chat_id, COALESCE(r.phone, ''), COALESCE(r.fio, ''), COALESCE(r.city, ''), COALESCE(r.speaker, ''), r.date
+        FROM users u
+        JOIN users r ON r.chat_id = u.referred_by
+        WHERE u.chat_id = ?
+    `, chatID)
+	var u User
+	if err := row.Scan(&u.ChatID, &u.Phone, &u.Fio, &u.City, &u.Speaker, &u.Date); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ReferralStats возвращает, сколько пользователей пригласил chatID и сколько из них записались на курс
+func ReferralStats(db *sql.DB, chatID int64) (invited, booked int, err error) {
+	err = db.QueryRow(`
+        SELECT COUNT(*), COALESCE(SUM(chat_id IN (SELECT chat_id FROM bookings WHERE status != ?)), 0)
+        FROM users
+        WHERE referred_by = ?
+    `, BookingCancelled, chatID).Scan(&invited, &booked)
+	return invited, booked, err
+}
+
+type BitrixItem struct {
+	ItemID  string
+	ChatID  int64
+	StageID string
+	Date    string
+}
+
+// Segment - условия выборки получателей рассылки, пустые поля не ограничивают выборку
+type Segment struct {
+	Speaker       string
+	City          string    // город без даты: "Казань" подходит и к "Казань | 15 июля"
+	Phone         string    // "yes" - только с телефоном, "no" - только без
+	InactiveSince time.Time // последняя активность не позже этой даты
+	Stage         string    // шаг воронки из таблицы funnel
+}
+
+// GetUsersBySegment возвращает незаблокировавших бота пользователей, подходящих под сегмент
+func GetUsersBySegment(db *sql.DB, s Segment) ([]User, error) {
+	query := "SELECT chat_id, phone, fio, city, speaker, date FROM users WHERE blocked = 0"
+	var args []any
+	if s.Speaker != "" {
+		query += " AND speaker = ?"
+		args = append(args, s.Speaker)
+	}
+	if s.City != "" {
+		query += " AND (city = ? OR city LIKE ?)"
+		args = append(args, s.City, s.City+" |%")
+	}
+	switch s.Phone {
+	case "yes":
+		query += " AND COALESCE(phone, '') != ''"
+	case "no":
+		query += " AND COALESCE(phone, '') = ''"
+	}
+	if !s.InactiveSince.IsZero() {
+		query += " AND date <= ?"
+		args = append(args, s.InactiveSince.Format("2006-01-02 15:04:05"))
+	}
+	if s.Stage != "" {
+		query += " AND chat_id IN (SELECT chat_id FROM funnel WHERE step = ?)"
+		args = append(args, s.Stage)
+	}
+	query += " ORDER BY chat_id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ChatID, &u.Phone, &u.Fio, &u.City, &u.Speaker, &u.Date); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// MarkUserBlocked отмечает пользователя, заблокировавшего бота; отметка снимается, когда он снова пишет боту
+func MarkUserBlocked(db *sql.DB, chatID int64) error {
+	_, err := db.Exec("UPDATE users SET blocked = 1 WHERE chat_id = ?", chatID)
+	return err
+}
+
+// SaveBitrixItem связывает элемент смарт-процесса Bitrix24 с чатом клиента
+func SaveBitrixItem(db *sql.DB, itemID string, chatID int64) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO bitrix_items (item_id, chat_id, stage_id, date)
+        VALUES (?, ?, '', ?)
+        ON CONFLICT(item_id) DO UPDATE SET chat_id=excluded.chat_id
+    `, itemID, chatID, now)
+	return err
+}
+
+func GetBitrixItem(db *sql.DB, itemID string) (*BitrixItem, error) {
+	row := db.QueryRow("SELECT item_id, chat_id, stage_id, date FROM bitrix_items WHERE item_id = ?", itemID)
+	var it BitrixItem
+	if err := row.Scan(&it.ItemID, &it.ChatID, &it.StageID, &it.Date); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &it, nil
+}
+
+// UpdateBitrixItemStage запоминает последнюю известную стадию элемента, чтобы не уведомлять клиента повторно
+func UpdateBitrixItemStage(db *sql.DB, itemID, stageID string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec("UPDATE bitrix_items SET stage_id = ?, date = ? WHERE item_id = ?", stageID, now, itemID)
+	return err
+}
+
+// CachedFile - file_id Telegram для файла и состояние файла на момент загрузки
+type CachedFile struct {
+	FileID  string
+	Hash    string // SHA-256 содержимого
+	Size    int64
+	ModTime int64 // время изменения, наносекунды Unix
+}
+
+// GetCachedFile возвращает сохранённый file_id файла или nil
+func GetCachedFile(db *sql.DB, path string) (*CachedFile, error) {
+	var c CachedFile
+	err := db.QueryRow("SELECT file_id, hash, size, mod_time FROM file_cache WHERE path = ?", path).
+		Scan(&c.FileID, &c.Hash, &c.Size, &c.ModTime)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SaveCachedFile запоминает file_id Telegram для файла с указанным хешем, размером и временем изменения
+func SaveCachedFile(db *sql.DB, path string, c CachedFile) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO file_cache (path, hash, file_id, size, mod_time, date)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(path) DO UPDATE SET
+            hash=excluded.hash,
+            file_id=excluded.file_id,
+            size=excluded.size,
+            mod_time=excluded.mod_time,
+            date=excluded.date
+    `, path, c.Hash, c.FileID, c.Size, c.ModTime, now)
+	return err
+}
+
+// DeleteCachedFileID забывает file_id файла, например если Telegram его больше не принимает
+func DeleteCachedFileID(db *sql.DB, path string) error {
+	_, err := db.Exec("DELETE FROM file_cache WHERE path = ?", path)
+	return err
+}
+
+// Статусы бронирования места на сессии курса
+const (
+	BookingPending   = "pending"   // заявка создана, ждём подтверждения менеджером
+	BookingConfirmed = "confirmed" // место занято
+	BookingCancelled = "cancelled" // заявка отменена, место свободно
+)
+
+// SaveBooking записывает бронь клиента на сессию; повторная заявка на ту же сессию обновляет сделку и статус
+func SaveBooking(db *sql.DB, chatID int64, session, itemID, status string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO bookings (chat_id, session, item_id, status, date)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT(chat_id, session) DO UPDATE SET
+            item_id=excluded.item_id,
+            status=excluded.status,
+            date=excluded.date
+    `, chatID, session, itemID, status, now)
+	return err
+}
+
+type Booking struct {
+	ChatID  int64
+	Session string
+	ItemID  string
+	Status  string
+	Date    string
+}
+
+// GetActiveBookings возвращает неотменённые брони
+func GetActiveBookings(db *sql.DB) ([]Booking, error) {
+	rows, err := db.Query(
+		"SELECT chat_id, session, COALESCE(item_id, ''), status, date FROM bookings WHERE status != ?", BookingCancelled,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookings []Booking
+	for rows.Next() {
+		var b Booking
+		if err := rows.Scan(&b.ChatID, &b.Session, &b.ItemID, &b.Status, &b.Date); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, b)
+	}
+	return bookings, rows.Err()
+}
+
+// HasBooking сообщает, есть ли у клиента неотменённая бронь
+func HasBooking(db *sql.DB, chatID int64) (bool, error) {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM bookings WHERE chat_id = ? AND status != ?", chatID, BookingCancelled,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// HoldBooking занимает место на сессии за клиентом, например после предоплаты: создаёт подтверждённую бронь
+// без сделки или подтверждает существующую, не трогая её сделку
+func HoldBooking(db *sql.DB, chatID int64, session string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO bookings (chat_id, session, item_id, status, date)
+        VALUES (?, ?, '', ?, ?)
+        ON CONFLICT(chat_id, session) DO UPDATE SET
+            status=excluded.status,
+            date=excluded.date
+    `, chatID, session, BookingConfirmed, now)
+	return err
+}
+
+// HasConfirmedBooking сообщает, занимает ли клиент место на сессии
+func HasConfirmedBooking(db *sql.DB, chatID int64, session string) (bool, error) {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM bookings WHERE chat_id = ? AND session = ? AND status = ?", chatID, session, BookingConfirmed,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// CountConfirmedBookings возвращает количество занятых мест на сессии
+func CountConfirmedBookings(db *sql.DB, session string) (int, error) {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM bookings WHERE session = ? AND status = ?", session, BookingConfirmed,
+	).Scan(&n)
+	return n, err
+}
+
+// UpdateBookingStatusByItem меняет статус брони, связанной со сделкой в CRM
+func UpdateBookingStatusByItem(db *sql.DB, itemID, status string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	// брони без сделки (место за предоплатой) стадиями CRM не меняются
+	_, err := db.Exec("UPDATE bookings SET status = ?, date = ? WHERE item_id = ? AND item_id != ''", status, now, itemID)
+	return err
+}
+
+type WaitlistEntry struct {
+	ChatID  int64
+	Speaker string
+	City    string // пусто - любой город
+	Date    string
+}
+
+// AddToWaitlist подписывает чат на новые даты спикера; city пустой - на любой город
+func AddToWaitlist(db *sql.DB, chatID int64, speaker, city string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO waitlist (chat_id, speaker, city, date)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(chat_id, speaker, city) DO UPDATE SET date=excluded.date
+    `, chatID, speaker, city, now)
+	return err
+}
+
+// GetWaitlist возвращает подписки на новые даты спикера
+func GetWaitlist(db *sql.DB, speaker string) ([]WaitlistEntry, error) {
+	rows, err := db.Query("SELECT chat_id, speaker, city, date FROM waitlist WHERE speaker = ? ORDER BY date", speaker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		var e WaitlistEntry
+		if err := rows.Scan(&e.ChatID, &e.Speaker, &e.City, &e.Date); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteWaitlistEntry удаляет подписку после уведомления
+func DeleteWaitlistEntry(db *sql.DB, chatID int64, speaker, city string) error {
+	_, err := db.Exec("DELETE FROM waitlist WHERE chat_id = ? AND speaker = ? AND city = ?", chatID, speaker, city)
+	return err
+}
+
+// ReminderSent сообщает, отправлялось ли напоминание за offset до начала сессии
+func ReminderSent(db *sql.DB, chatID int64, session, offset string) (bool, error) {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM reminders WHERE chat_id = ? AND session = ? AND remind_before = ?", chatID, session, offset,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// SaveReminderSent отмечает напоминание отправленным, чтобы не повторять его после перезапуска
+func SaveReminderSent(db *sql.DB, chatID int64, session, offset string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO reminders (chat_id, session, remind_before, date)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT(chat_id, session, remind_before) DO NOTHING
+    `, chatID, session, offset, now)
+	return err
+}
+
+// FunnelState - шаг воронки, на котором остановился клиент
+type FunnelState struct {
+	ChatID    int64
+	Step      string
+	Speaker   string
+	City      string
+	UpdatedAt time.Time
+	FollowUps int // сколько напоминаний уже отправлено
+}
+
+// SetFunnelStep запоминает шаг воронки клиента; пустые speaker/city не затирают сохранённые
+func SetFunnelStep(db *sql.DB, chatID int64, step, speaker, city string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO funnel (chat_id, step, speaker, city, updated_at)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT(chat_id) DO UPDATE SET
+            step=excluded.step,
+            speaker=COALESCE(NULLIF(excluded.speaker, ''), funnel.speaker),
+            city=CASE WHEN excluded.speaker != '' THEN excluded.city ELSE funnel.city END,
+            updated_at=excluded.updated_at,
+            followed_up=0
+    `, chatID, step, speaker, city, now)
+	return err
+}
+
+// TouchFunnel отмечает активность клиента без смены шага
+func TouchFunnel(db *sql.DB, chatID int64) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec("UPDATE funnel SET updated_at = ?, followed_up = 0 WHERE chat_id = ?", now, chatID)
+	return err
+}
+
+// GetStalledFunnels возвращает клиентов, которые с before не двигаются дальше шага step и ещё не получили
+// напоминание на этом шаге, не отказались от напоминаний и получили их меньше maxFollowUps
+func GetStalledFunnels(db *sql.DB, step string, before time.Time, maxFollowUps int) ([]FunnelState, error) {
+	rows, err := db.Query(`
+        SELECT chat_id, step, COALESCE(speaker, ''), COALESCE(city, ''), updated_at, followups
+        FROM funnel
+        WHERE step = ? AND updated_at <= ? AND followed_up = 0 AND opted_out = 0 AND followups < ?
+    `, step, before.Format("2006-01-02 15:04:05"), maxFollowUps)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []FunnelState
+	for rows.Next() {
+		var (
+			st        FunnelState
+			updatedAt string
+		)
+		if err := rows.Scan(&st.ChatID, &st.Step, &st.Speaker, &st.City, &updatedAt, &st.FollowUps); err != nil {
+			return nil, err
+		}
+		st.UpdatedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", updatedAt, time.Local)
+		states = append(states, st)
+	}
+	return states, rows.Err()
+}
+
+// MarkFollowUpSent учитывает отправленное напоминание
+func MarkFollowUpSent(db *sql.DB, chatID int64) error {
+	_, err := db.Exec("UPDATE funnel SET followed_up = 1, followups = followups + 1 WHERE chat_id = ?", chatID)
+	return err
+}
+
+// OptOutFollowUps отключает напоминания о незавершённой записи для клиента
+func OptOutFollowUps(db *sql.DB, chatID int64) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO funnel (chat_id, step, updated_at, opted_out)
+        VALUES (?, '', ?, 1)
+        ON CONFLICT(chat_id) DO UPDATE SET opted_out=1
+    `, chatID, now)
+	return err
+}
+
+// FailedSync - заявка, которую не удалось передать в CRM. Session - состояние диалога в JSON для повторной отправки
+type FailedSync struct {
+	ID         int64
+	ChatID     int64
+	SessionKey string // сессия курса, как в таблице bookings
+	Session    string
+	Error      string
+	Date       string
+}
+
+// SaveFailedSync запоминает неудачную синхронизацию заявки на сессию курса; повторная ошибка обновляет запись
+func SaveFailedSync(db *sql.DB, chatID int64, sessionKey, session, errText string) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO crm_failures (chat_id, session_key, session, error, date)
+        VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT(chat_id, session_key) DO UPDATE SET
+            session=excluded.session,
+            error=excluded.error,
+            date=excluded.date
+    `, chatID, sessionKey, session, errText, now)
+	return err
+}
+
+const failedSyncSelect = "SELECT id, chat_id, session_key, session, error, date FROM crm_failures"
+
+func scanFailedSync(row interface{ Scan(...any) error }) (*FailedSync, error) {
+	var f FailedSync
+	if err := row.Scan(&f.ID, &f.ChatID, &f.SessionKey, &f.Session, &f.Error, &f.Date); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// GetFailedSyncs возвращает неудачные синхронизации, новые первыми
+func GetFailedSyncs(db *sql.DB) ([]FailedSync, error) {
+	rows, err := db.Query(failedSyncSelect + " ORDER BY date DESC, chat_id, session_key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []FailedSync
+	for rows.Next() {
+		f, err := scanFailedSync(rows)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, *f)
+	}
+	return failures, rows.Err()
+}
+
+// GetFailedSync возвращает неудачную синхронизацию по номеру записи или nil
+func GetFailedSync(db *sql.DB, id int64) (*FailedSync, error) {
+	f, err := scanFailedSync(db.QueryRow(failedSyncSelect+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return f, err
+}
+
+// DeleteFailedSync убирает запись о сессии курса после успешной синхронизации
+func DeleteFailedSync(db *sql.DB, chatID int64, sessionKey string) error {
+	_, err := db.Exec("DELETE FROM crm_failures WHERE chat_id = ? AND session_key = ?", chatID, sessionKey)
+	return err
+}
+
+// LeadStats - сводка по клиентам и заявкам для администратора
+type LeadStats struct {
+	Users       int
+	WithPhone   int
+	Blocked     int
+	LeadsToday  int
+	LeadsWeek   int
+	LeadsMonth  int
+	LeadsTotal  int
+	ByStatus    map[string]int // брони по статусам
+	FunnelSteps map[string]int // клиенты по шагам воронки
+	FailedSyncs int
+	Sources     []SourceStats // пользователи по источникам, самые крупные первыми
+}
+
+// SourceStats - пользователи, пришедшие из одного источника и кампании, и сколько из них оставили заявку
+type SourceStats struct {
+	Source   string // пусто - без метки
+	Campaign string
+	Users    int
+	Leads    int
+}
+
+// GetLeadStats считает статистику; заявки - брони, созданные после передачи в CRM
+func GetLeadStats(db *sql.DB, now time.Time) (*LeadStats, error) {
+	st := &LeadStats{ByStatus: make(map[string]int), FunnelSteps: make(map[string]int)}
+
+	err := db.QueryRow(`
+        SELECT COUNT(*), COALESCE(SUM(COALESCE(phone, '') != ''), 0), COALESCE(SUM(blocked), 0) FROM users
+    `).Scan(&st.Users, &st.WithPhone, &st.Blocked)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	err = db.QueryRow(`
+        SELECT COUNT(*), COALESCE(SUM(date >= ?), 0), COALESCE(SUM(date >= ?), 0), COALESCE(SUM(date >= ?), 0)
+        FROM bookings
+    `,
+		today.Format("2006-01-02 15:04:05"),
+		today.AddDate(0, 0, -6).Format("2006-01-02 15:04:05"),
+		today.AddDate(0, 0, -29).Format("2006-01-02 15:04:05"),
+	).Scan(&st.LeadsTotal, &st.LeadsToday, &st.LeadsWeek, &st.LeadsMonth)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := countBy(db, "SELECT status, COUNT(*) FROM bookings GROUP BY status", st.ByStatus); err != nil {
+		return nil, err
+	}
+	if err := countBy(db, "SELECT step, COUNT(*) FROM funnel WHERE step != '' GROUP BY step", st.FunnelSteps); err != nil {
+		return nil, err
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM crm_failures").Scan(&st.FailedSyncs); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+        SELECT utm_source, utm_campaign, COUNT(*), COALESCE(SUM(chat_id IN (SELECT chat_id FROM bookings)), 0)
+        FROM users
+        GROUP BY utm_source, utm_campaign
+        ORDER BY COUNT(*) DESC, utm_source, utm_campaign
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s SourceStats
+		if err := rows.Scan(&s.Source, &s.Campaign, &s.Users, &s.Leads); err != nil {
+			return nil, err
+		}
+		st.Sources = append(st.Sources, s)
+	}
+	return st, rows.Err()
+}
+
+func countBy(db *sql.DB, query string, out map[string]int) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key string
+			n   int
+		)
+		if err := rows.Scan(&key, &n); err != nil {
+			return err
+		}
+		out[key] = n
+	}
+	return rows.Err()
+}
+
+// Lead - заявка клиента с контактами
+type Lead struct {
+	ChatID  int64
+	Fio     string
+	Phone   string
+	Session string
+	Status  string
+	ItemID  string
+	Date    string
+}
+
+// GetLastLeads возвращает последние limit заявок
+func GetLastLeads(db *sql.DB, limit int) ([]Lead, error) {
+	rows, err := db.Query(`
+        SELECT b.chat_id, COALESCE(u.fio, ''), COALESCE(u.phone, ''), b.session, b.status, COALESCE(b.item_id, ''), b.date
+        FROM bookings b
+        LEFT JOIN users u ON u.chat_id = b.chat_id
+        ORDER BY b.date DESC, b.rowid DESC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leads []Lead
+	for rows.Next() {
+		var l Lead
+		if err := rows.Scan(&l.ChatID, &l.Fio, &l.Phone, &l.Session, &l.Status, &l.ItemID, &l.Date); err != nil {
+			return nil, err
+		}
+		leads = append(leads, l)
+	}
+	return leads, rows.Err()
+}
+
+// ExportFilter - условия выгрузки клиентов, пустые поля не ограничивают выборку
+type ExportFilter struct {
+	From    time.Time // первый заход в бот не раньше
+	To      time.Time // и раньше этого момента
+	Speaker string
+}
+
+// ExportUsersCSV выгружает таблицу users в CSV со всеми колонками и возвращает число выгруженных клиентов
+func ExportUsersCSV(db *sql.DB, w io.Writer, f ExportFilter) (int, error) {
+	query := "SELECT * FROM users WHERE 1 = 1"
+	var args []any
+	if !f.From.IsZero() {
+		query += " AND first_seen >= ?"
+		args = append(args, f.From.Format("2006-01-02 15:04:05"))
+	}
+	if !f.To.IsZero() {
+		query += " AND first_seen < ?"
+		args = append(args, f.To.Format("2006-01-02 15:04:05"))
+	}
+	if f.Speaker != "" {
+		query += " AND speaker = ?"
+		args = append(args, f.Speaker)
+	}
+	query += " ORDER BY first_seen"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	values := make([]any, len(columns))
+	valuePtrs := make([]any, len(columns))
+	for i := range columns {
+		valuePtrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return 0, err
+		}
+		record := make([]string, len(columns))
+		for i, val := range values {
+			if val != nil {
+				record[i] = fmt.Sprintf("%v", val)
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	writer.Flush()
+	return n, writer.Error()
+}
+
+// LastRun возвращает время последнего запуска периодической задачи, нулевое - ещё не запускалась
+func LastRun(db *sql.DB, job string) (time.Time, error) {
+	var raw string
+	err := db.QueryRow("SELECT last_run FROM jobs WHERE name = ?", job).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", raw, time.Local)
+}
+
+// SaveRun запоминает запуск периодической задачи
+func SaveRun(db *sql.DB, job string, at time.Time) error {
+	_, err := db.Exec(`
+        INSERT INTO jobs (name, last_run) VALUES (?, ?)
+        ON CONFLICT(name) DO UPDATE SET last_run=excluded.last_run
+    `, job, at.Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// PromoCode - промокод на скидку. Пустые ограничения не действуют
+type PromoCode struct {
+	Code    string
+	Percent bool // скидка в процентах, иначе фиксированная сумма в рублях
+	Amount  int
+	From    time.Time // первый день действия
+	To      time.Time // последний день действия включительно
+	MaxUses int       // 0 - без ограничения
+	Used    int       // сколько раз применён в заявках, вместе с непросроченными резервами
+	Speaker string
+	City    string // город без даты
+}
+
+// SavePromoCode создаёт промокод или заменяет условия существующего
+func SavePromoCode(db *sql.DB, p PromoCode) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO promo_codes (code, percent, amount, valid_from, valid_to, max_uses, speaker, city, date)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(code) DO UPDATE SET
+            percent=excluded.percent,
+            amount=excluded.amount,
+            valid_from=excluded.valid_from,
+            valid_to=excluded.valid_to,
+            max_uses=excluded.max_uses,
+            speaker=excluded.speaker,
+            city=excluded.city,
+            date=excluded.date
+    `, p.Code, p.Percent, p.Amount, formatDay(p.From), formatDay(p.To), p.MaxUses, p.Speaker, p.City, now)
+	return err
+}
+
+const promoSelect = `
+    SELECT p.code, p.percent, p.amount, p.valid_from, p.valid_to, p.max_uses, p.speaker, p.city,
+        (SELECT COUNT(*) FROM promo_uses u WHERE u.code = p.code AND (u.confirmed = 1 OR u.date >= ?))
+    FROM promo_codes p`
+
+// PromoReservationTTL - сколько держится резерв промокода без заявки; более старые
+// неподтверждённые резервы не занимают лимит использований
+const PromoReservationTTL = 24 * time.Hour
+
+// promoReservationCutoff - дата, раньше которой неподтверждённый резерв считается брошенным
+func promoReservationCutoff() string {
+	return time.Now().Add(-PromoReservationTTL).Format("2006-01-02 15:04:05")
+}
+
+// GetPromoCode ищет промокод без учёта регистра; nil - такого нет
+func GetPromoCode(db *sql.DB, code string) (*PromoCode, error) {
+	p, err := scanPromoCode(db.QueryRow(promoSelect+" WHERE p.code = ?", promoReservationCutoff(), code))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetPromoCodes возвращает все промокоды
+func GetPromoCodes(db *sql.DB) ([]PromoCode, error) {
+	rows, err := db.Query(promoSelect+" ORDER BY p.code", promoReservationCutoff())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []PromoCode
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, *p)
+	}
+	return codes, rows.Err()
+}
+
+func scanPromoCode(row interface{ Scan(...any) error }) (*PromoCode, error) {
+	var (
+		p        PromoCode
+		from, to string
+	)
+	if err := row.Scan(&p.Code, &p.Percent, &p.Amount, &from, &to, &p.MaxUses, &p.Speaker, &p.City, &p.Used); err != nil {
+		return nil, err
+	}
+	p.From, _ = time.ParseInLocation("2006-01-02", from, time.Local)
+	p.To, _ = time.ParseInLocation("2006-01-02", to, time.Local)
+	return &p, nil
+}
+
+// DeletePromoCode удаляет промокод; false - такого не было
+func DeletePromoCode(db *sql.DB, code string) (bool, error) {
+	res, err := db.Exec("DELETE FROM promo_codes WHERE code = ?", code)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// HasActivePromoCodes сообщает, есть ли промокоды, которые действуют в день now
+func HasActivePromoCodes(db *sql.DB, now time.Time) (bool, error) {
+	day := formatDay(now)
+	var n int
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM promo_codes
+        WHERE (valid_from = '' OR valid_from <= ?) AND (valid_to = '' OR valid_to >= ?)
+    `, day, day).Scan(&n)
+	return n > 0, err
+}
+
+// PromoCodeUsed сообщает, применял ли клиент промокод в заявке
+func PromoCodeUsed(db *sql.DB, code string, chatID int64) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM promo_uses WHERE code = ? AND chat_id = ? AND confirmed = 1", code, chatID).Scan(&n)
+	return n > 0, err
+}
+
+// ReservePromoCode резервирует использование промокода за клиентом, если лимит ещё не исчерпан.
+// Проверка лимита и запись идут одним запросом, поэтому два клиента не займут последнее использование.
+// Повторный вызов для того же клиента возвращает его резерв
+func ReservePromoCode(db *sql.DB, code string, chatID int64) (bool, error) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	cutoff := promoReservationCutoff()
+	// просроченный резерв самого клиента продлевается, только если лимит ещё позволяет
+	res, err := db.Exec(`
+        INSERT INTO promo_uses (code, chat_id, date, confirmed)
+        SELECT p.code, ?, ?, 0 FROM promo_codes p
+        WHERE p.code = ? AND (p.max_uses = 0 OR (
+            SELECT COUNT(*) FROM promo_uses u
+            WHERE u.code = p.code AND (u.confirmed = 1 OR u.date >= ?)
+        ) < p.max_uses)
+        ON CONFLICT(code, chat_id) DO UPDATE SET date = excluded.date WHERE confirmed = 0
+    `, chatID, now, code, cutoff)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return n > 0, err
+	}
+	var n int
+	err = db.QueryRow(`
+        SELECT COUNT(*) FROM promo_uses
+        WHERE code = ? AND chat_id = ? AND confirmed = 0 AND date >= ?
+    `, code, chatID, cutoff).Scan(&n)
+	return n > 0, err
+}
+
+// ReleasePromoCode снимает резерв промокода, если клиент так и не отправил с ним заявку
+func ReleasePromoCode(db *sql.DB, code string, chatID int64) error {
+	_, err := db.Exec("DELETE FROM promo_uses WHERE code = ? AND chat_id = ? AND confirmed = 0", code, chatID)
+	return err
+}
+
+// UsePromoCode учитывает применение промокода в заявке клиента и подтверждает резерв;
+// повторная заявка с тем же кодом не считается
+func UsePromoCode(db *sql.DB, code string, chatID int64) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO promo_uses (code, chat_id, date, confirmed) VALUES (?, ?, ?, 1)
+        ON CONFLICT(code, chat_id) DO UPDATE SET confirmed = 1
+    `, code, chatID, now)
+	return err
+}
+
+// formatDay - дата без времени, пустая строка для нулевой
+func formatDay(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// Статусы оплаты
+const (
+	PaymentPending = "pending" // счёт выставлен, оплаты ещё не было
+	PaymentPaid    = "paid"
+)
+
+// Payment - предоплата клиента за место на сессии курса
+type Payment struct {
+	ID               int64
+	ChatID           int64
+	Session          string // сессия курса, как в таблице bookings
+	Provider         string // telegram или платёжный сервис
+	Amount           int    // в минимальных единицах валюты (копейках)
+	Currency         string
+	Status           string
+	ChargeID         string // ID платежа в Telegram или у платёжного сервиса
+	ProviderChargeID string // ID платежа у провайдера Telegram Payments
+	ItemID           string // сделка в CRM, в которой отмечена оплата
+	Date             string
+	PaidAt           string
+}
+
+// CreatePayment записывает выставленный счёт и возвращает его ID
+func CreatePayment(db *sql.DB, p Payment) (int64, error) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	res, err := db.Exec(`
+        INSERT INTO payments (chat_id, session, provider, amount, currency, status, charge_id, date)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, p.ChatID, p.Session, p.Provider, p.Amount, p.Currency, PaymentPending, p.ChargeID, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+const paymentSelect = `
+    SELECT id, chat_id, session, provider, amount, currency, status, charge_id, provider_charge_id, item_id, date, paid_at
+    FROM payments`
+
+func scanPayment(row interface{ Scan(...any) error }) (*Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, &p.ChatID, &p.Session, &p.Provider, &p.Amount, &p.Currency, &p.Status,
+		&p.ChargeID, &p.ProviderChargeID, &p.ItemID, &p.Date, &p.PaidAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPayment возвращает платёж по ID или nil
+func GetPayment(db *sql.DB, id int64) (*Payment, error) {
+	p, err := scanPayment(db.QueryRow(paymentSelect+" WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// MarkPaymentPaid отмечает платёж оплаченным; false - он уже был оплачен раньше
+func MarkPaymentPaid(db *sql.DB, id int64, chargeID, providerChargeID string) (bool, error) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	res, err := db.Exec(`
+        UPDATE payments SET status = ?, charge_id = ?, provider_charge_id = ?, paid_at = ?
+        WHERE id = ? AND status != ?
+    `, PaymentPaid, chargeID, providerChargeID, now, id, PaymentPaid)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetUnsyncedPayments возвращает оплаченные платежи сессии, ещё не отмеченные в CRM
+func GetUnsyncedPayments(db *sql.DB, chatID int64, session string) ([]Payment, error) {
+	rows, err := db.Query(paymentSelect+" WHERE chat_id = ? AND session = ? AND status = ? AND item_id = '' ORDER BY id",
+		chatID, session, PaymentPaid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+	return payments, rows.Err()
+}
+
+// SessionPaid сообщает, внёс ли клиент предоплату за сессию
+func SessionPaid(db *sql.DB, chatID int64, session string) (bool, error) {
+	var n int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM payments WHERE chat_id = ? AND session = ? AND status = ?", chatID, session, PaymentPaid,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// SetPaymentItem запоминает сделку CRM, в которой отмечена оплата
+func SetPaymentItem(db *sql.DB, id int64, itemID string) error {
+	_, err := db.Exec("UPDATE payments SET item_id = ? WHERE id = ?", itemID, id)
+	return err
+}
+
+// GetBookingItem возвращает сделку CRM, созданную по брони клиента на сессию, или пустую строку
+func GetBookingItem(db *sql.DB, chatID int64, session string) (string, error) {
+	var itemID string
+	err := db.QueryRow(
+		"SELECT COALESCE(item_id, '') FROM bookings WHERE chat_id = ? AND session = ?", chatID, session,
+	).Scan(&itemID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return itemID, err
+}
+
+// SetPaymentCharge запоминает ID платежа, созданного у платёжного сервиса
+func SetPaymentCharge(db *sql.DB, id int64, chargeID string) error {
+	_, err := db.Exec("UPDATE payments SET charge_id = ? WHERE id = ?", chargeID, id)
+	return err
+}
+
+// GetPaymentByCharge возвращает платёж по ID у платёжного сервиса или nil
+func GetPaymentByCharge(db *sql.DB, provider, chargeID string) (*Payment, error) {
+	p, err := scanPayment(db.QueryRow(paymentSelect+" WHERE provider = ? AND charge_id = ?", provider, chargeID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// SaveConsent запоминает согласие пользователя на обработку персональных данных по версии политики
+func SaveConsent(db *sql.DB, chatID int64, version string, at time.Time) error {
+	now := at.Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+        INSERT INTO users (chat_id, phone, fio, city, speaker, date, consent_at, consent_version)
+        VALUES (?, '', '', '', '', ?, ?, ?)
+        ON CONFLICT(chat_id) DO UPDATE SET
+            consent_at=excluded.consent_at,
+            consent_version=excluded.consent_version
+    `, chatID, now, now, version)
+	return err
+}
+
+// GetConsent возвращает время согласия и версию политики; пустые строки - согласия не было
+func GetConsent(db *sql.DB, chatID int64) (at, version string, err error) {
+	err = db.QueryRow("SELECT consent_at, consent_version FROM users WHERE chat_id = ?", chatID).Scan(&at, &version)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return at, version, err
+}
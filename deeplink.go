@@ -0,0 +1,211 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Telegram принимает в start не больше 64 символов A-Z, a-z, 0-9, _ и -
+	startPayloadMaxLen = 64
+	// части параметра разделяются двойным подчёркиванием, ключ и значение - дефисом: src-vk__cmp-autumn__sp-mariya
+	startPartSep = "__"
+)
+
+// startLink - разобранный параметр ссылки t.me/<бот>?start=<payload>
+type startLink struct {
+	UTM      db.UTM
+	Speaker  string // slug имени спикера
+	Course   string // slug города (с датой или без) или названия курса
+	Referrer string // код приглашения клиента, см. referralCode
+}
+
+// parseStartPayload разбирает параметр /start. Ключи: src, med, cmp, cnt - UTM-метки, sp - спикер, c - курс,
+// ref - приглашение клиента. Часть без известного ключа считается источником, поэтому работает и короткое ?start=instagram
+func parseStartPayload(payload string) startLink {
+	var link startLink
+	for _, part := range strings.Split(strings.TrimSpace(payload), startPartSep) {
+		if part == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "-")
+		switch key {
+		case "src":
+			link.UTM.Source = value
+		case "med":
+			link.UTM.Medium = value
+		case "cmp":
+			link.UTM.Campaign = value
+		case "cnt":
+			link.UTM.Content = value
+		case "sp":
+			link.Speaker = value
+		case "c":
+			link.Course = value
+		case "ref":
+			link.Referrer = value
+		default:
+			if link.UTM.Source == "" {
+				link.UTM.Source = part
+			}
+		}
+	}
+	if link.Referrer != "" && link.UTM.Source == "" {
+		link.UTM.Source = referralSource
+	}
+	return link
+}
+
+// findStartCourse ищет в каталоге спикера и курс из ссылки; -1 - не указан или не найден
+func findStartCourse(link startLink) (speakerIdx, courseIdx int) {
+	if link.Speaker == "" && link.Course == "" {
+		return -1, -1
+	}
+	for i, s := range Speakers {
+		if link.Speaker != "" && slugify(s.Name) != link.Speaker {
+			continue
+		}
+		if link.Course != "" {
+			if j := findCourseBySlug(s.Courses, link.Course); j >= 0 {
+				return i, j
+			}
+		}
+		if link.Speaker != "" {
+			// курс не указан или не нашёлся (например, дата прошла) - открываем спикера
+			return i, -1
+		}
+	}
+	return -1, -1
+}
+
+// findCourseBySlug сравнивает slug сначала с "Город | Дата", затем с городом и с названием курса
+func findCourseBySlug(courses []Course, slug string) int {
+	for _, match := range []func(Course) string{
+		func(c Course) string { return slugify(c.City) },
+		func(c Course) string { city, _, _ := strings.Cut(c.City, "|"); return slugify(city) },
+		func(c Course) string { return slugify(c.Title) },
+	} {
+		for j, c := range courses {
+			if s := match(c); s != "" && s == slug {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// handleStartLink запоминает источник из параметра /start и открывает спикера или курс из ссылки.
+// newUser - пользователь впервые пишет боту. false - в ссылке нет спикера и курса, нужно показать обычное приветствие
+func handleStartLink(bot tools.Sender, chatID int64, user *tgbotapi.User, payload string, newUser bool) bool {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return false
+	}
+	link := parseStartPayload(payload)
+
+	saved, err := db.SaveUserSource(dbConn, chatID, payload, link.UTM)
+	if err != nil {
+		log.Printf("start: failed to save source for chat %d: %v", chatID, err)
+	} else if saved {
+		log.Printf("start: chat %d came with payload %q", chatID, payload)
+	}
+
+	if link.Referrer != "" {
+		if newUser {
+			saveReferral(chatID, link.Referrer)
+		} else {
+			log.Printf("referral: chat %d is not a new user, invite %q is not counted", chatID, link.Referrer)
+		}
+	}
+
+	speakerIdx, courseIdx := findStartCourse(link)
+	switch {
+	case courseIdx >= 0:
+		pickCourse(courseData(speakerIdx, courseIdx), bot, chatID, user)
+	case speakerIdx >= 0:
+		pickSpeaker(speakerData(speakerIdx), bot, chatID, user)
+	default:
+		return false
+	}
+	return true
+}
+
+// startLinks собирает ссылки, открывающие спикеров и курсы, для команды администратора /links
+func startLinks(botName string) string {
+	var b strings.Builder
+	b.WriteString("🔗 Ссылки на спикеров и курсы\n")
+	b.WriteString("Метку источника добавьте в начало: src-instagram__cmp-autumn__sp-…\n")
+	for _, s := range Speakers {
+		speaker := "sp-" + slugify(s.Name)
+		fmt.Fprintf(&b, "\n%s\nhttps://t.me/%s?start=%s\n", s.Name, botName, speaker)
+		for _, c := range s.Courses {
+			payload := speaker + startPartSep + "c-" + slugify(c.City)
+			if len(payload) > startPayloadMaxLen {
+				fmt.Fprintf(&b, "• %s: ссылка длиннее 64 символов, используйте ссылку на спикера\n", c.City)
+				continue
+			}
+			fmt.Fprintf(&b, "• %s: https://t.me/%s?start=%s\n", c.City, botName, payload)
+		}
+	}
+	return b.String()
+}
+
+// botUsername возвращает имя бота для ссылок t.me
+func botUsername(bot tools.Sender) string {
+	if api, ok := bot.(*tgbotapi.BotAPI); ok && api.Self.UserName != "" {
+		return api.Self.UserName
+	}
+	return "<бот>"
+}
+
+// utmDisplayName - источник и кампания для примечания в CRM и статистики
+func utmDisplayName(utm db.UTM) string {
+	var parts []string
+	for _, v := range []string{utm.Source, utm.Medium, utm.Campaign, utm.Content} {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// транслитерация для slug, чтобы ссылки на русские имена оставались читаемыми
+var slugTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// slugify превращает имя спикера или город в латиницу для параметра /start: "Казань | 15 июля" -> "kazan-15-iyulya"
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		var part string
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			part = string(r)
+		default:
+			var ok bool
+			if part, ok = slugTranslit[r]; !ok {
+				dash = b.Len() > 0
+				continue
+			}
+		}
+		if part == "" {
+			continue
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
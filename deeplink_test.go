@@ -0,0 +1,115 @@
+package main
+
+import (
+	"app/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStartPayload(t *testing.T) {
+	cases := map[string]startLink{
+		"instagram": {UTM: db.UTM{Source: "instagram"}},
+		"src-vk__med-cpc__cmp-autumn__cnt-video__sp-mariya__c-kazan": {
+			UTM:     db.UTM{Source: "vk", Medium: "cpc", Campaign: "autumn", Content: "video"},
+			Speaker: "mariya",
+			Course:  "kazan",
+		},
+		"sp-ivan__tg-channel": {UTM: db.UTM{Source: "tg-channel"}, Speaker: "ivan"},
+		"":                    {},
+	}
+	for payload, want := range cases {
+		if got := parseStartPayload(payload); got != want {
+			t.Errorf("parseStartPayload(%q) = %+v, want %+v", payload, got, want)
+		}
+	}
+
+	if got := slugify("Казань | 15 июля"); got != "kazan-15-iyulya" {
+		t.Errorf("slugify = %q", got)
+	}
+	if got := slugify("Мария Петрова (pdf)"); got != "mariya-petrova-pdf" {
+		t.Errorf("slugify = %q", got)
+	}
+}
+
+func TestFindStartCourse(t *testing.T) {
+	useDemoCatalog(t)
+
+	cases := []struct {
+		link            startLink
+		speaker, course int
+	}{
+		{startLink{Speaker: "mariya-petrova-pdf", Course: "kazan-15-iyulya"}, 1, 0},
+		{startLink{Speaker: "ivan-ivanov-tekst", Course: "piter"}, 0, 1},
+		{startLink{Course: "strizhki-dlya-nachinayushchikh"}, 0, 0},
+		{startLink{Speaker: "ivan-ivanov-tekst", Course: "riga"}, 0, -1},
+		{startLink{Speaker: "nobody"}, -1, -1},
+		{startLink{UTM: db.UTM{Source: "vk"}}, -1, -1},
+	}
+	for _, c := range cases {
+		speaker, course := findStartCourse(c.link)
+		if speaker != c.speaker || course != c.course {
+			t.Errorf("findStartCourse(%+v) = %d, %d, want %d, %d", c.link, speaker, course, c.speaker, c.course)
+		}
+	}
+}
+
+func TestStartLinkOpensCourseAndTagsLead(t *testing.T) {
+	const chatID = 1101
+	useTestDB(t)
+	useDemoCatalog(t)
+	bitrix, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+
+	// ссылка из рекламы сразу открывает курс
+	HandleMessage(bot, messageUpdate(chatID, "/start src-instagram__cmp-autumn__sp-mariya-petrova-pdf__c-kazan"))
+	sent := tg.Sent(chatID)
+	if len(sent) != 3 || sent[1].Files["document"] != "dummy.pdf" {
+		t.Fatalf("deep link messages = %v", sentTexts(tg, chatID))
+	}
+
+	// повторный переход по другой ссылке не меняет первый источник
+	HandleMessage(bot, messageUpdate(chatID, "/start vk"))
+	if utm, err := db.GetUserUTM(dbConn, chatID); err != nil || utm != (db.UTM{Source: "instagram", Campaign: "autumn"}) {
+		t.Errorf("utm = %+v, %v", utm, err)
+	}
+
+	HandleCallback(bot, callbackUpdate(chatID, "book_course"))
+	HandleCallback(bot, callbackUpdate(chatID, consentAcceptData))
+	HandleMessage(bot, contactUpdate(chatID, "79991234567"))
+
+	items := bitrix.Items()
+	if len(items) != 1 || items[0].Fields["utmSource"] != "instagram" || items[0].Fields["utmCampaign"] != "autumn" {
+		t.Fatalf("items = %+v", items)
+	}
+	if comments := bitrix.Comments(); len(comments) != 1 || !strings.Contains(comments[0].Comment, "Источник: instagram / autumn") {
+		t.Errorf("comments = %+v", comments)
+	}
+
+	// пользователь без метки попадает в отдельную строку статистики
+	if err := db.UpsertUser(dbConn, 1102, "", "Клиент", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	st, err := db.GetLeadStats(dbConn, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []db.SourceStats{{Users: 1}, {Source: "instagram", Campaign: "autumn", Users: 1, Leads: 1}}
+	if len(st.Sources) != 2 || st.Sources[0] != want[0] || st.Sources[1] != want[1] {
+		t.Errorf("sources = %+v", st.Sources)
+	}
+}
+
+func TestStartLinksForAdmin(t *testing.T) {
+	useDemoCatalog(t)
+	links := startLinks("fake_bot")
+	for _, want := range []string{
+		"https://t.me/fake_bot?start=sp-mariya-petrova-pdf\n",
+		"• Казань | 15 июля: https://t.me/fake_bot?start=sp-mariya-petrova-pdf__c-kazan-15-iyulya",
+	} {
+		if !strings.Contains(links, want) {
+			t.Errorf("links have no %q:\n%s", want, links)
+		}
+	}
+}
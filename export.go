@@ -0,0 +1,208 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	exportUsage = "Формат: /export [с ГГГГ-ММ-ДД] [по ГГГГ-ММ-ДД] [спикер], например /export 2024-05-01 2024-05-31 Мария"
+
+	defaultExportTime = "09:00"
+	exportJob         = "export"
+	// неудачная попытка регулярной выгрузки, чтобы не слать её на каждой проверке
+	exportAttemptJob = "export_attempt"
+	exportRetryDelay = time.Hour
+)
+
+// exportDateLayouts - форматы дат в /export
+var exportDateLayouts = []string{"2006-01-02", "02.01.2006"}
+
+// parseExportArgs разбирает аргументы /export: до двух дат (с и по, включительно), остальное - имя спикера
+func parseExportArgs(args string) (db.ExportFilter, error) {
+	var (
+		filter  db.ExportFilter
+		speaker []string
+		dates   int
+	)
+	for _, token := range strings.Fields(args) {
+		day, ok := parseExportDate(token)
+		if !ok {
+			speaker = append(speaker, token)
+			continue
+		}
+		switch dates {
+		case 0:
+			filter.From = day
+		case 1:
+			filter.To = day.AddDate(0, 0, 1)
+		default:
+			return filter, errors.New("указано больше двух дат")
+		}
+		dates++
+	}
+	if !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("дата «по» раньше даты «с»")
+	}
+
+	if name := strings.Join(speaker, " "); name != "" {
+		for _, s := range Speakers {
+			if strings.EqualFold(s.Name, name) {
+				filter.Speaker = s.Name
+			}
+		}
+		if filter.Speaker == "" {
+			return filter, fmt.Errorf("спикер «%s» не найден в каталоге", name)
+		}
+	}
+	return filter, nil
+}
+
+func parseExportDate(raw string) (time.Time, bool) {
+	for _, layout := range exportDateLayouts {
+		if t, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// exportCaption описывает выгрузку: период, спикер и число клиентов
+func exportCaption(f db.ExportFilter, n int) string {
+	caption := "📥 Клиенты"
+	switch {
+	case !f.From.IsZero() && !f.To.IsZero():
+		caption += fmt.Sprintf(" с %s по %s", f.From.Format("02.01.2006"), f.To.AddDate(0, 0, -1).Format("02.01.2006"))
+	case !f.From.IsZero():
+		caption += " с " + f.From.Format("02.01.2006")
+	case !f.To.IsZero():
+		caption += " по " + f.To.AddDate(0, 0, -1).Format("02.01.2006")
+	}
+	if f.Speaker != "" {
+		caption += ", спикер " + f.Speaker
+	}
+	return fmt.Sprintf("%s: %d", caption, n)
+}
+
+// exportFileName - имя файла выгрузки с датами периода
+func exportFileName(f db.ExportFilter, now time.Time) string {
+	name := "clients"
+	if !f.From.IsZero() {
+		name += "-" + f.From.Format("2006-01-02")
+	}
+	if !f.To.IsZero() {
+		name += "-" + f.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if f.From.IsZero() && f.To.IsZero() {
+		name += "-" + now.Format("2006-01-02")
+	}
+	return name + ".csv"
+}
+
+// sendUsersExport отправляет выгрузку клиентов файлом в том же формате, что cmd/exportDb
+func sendUsersExport(bot tools.Sender, chatID int64, filter db.ExportFilter) error {
+	var buf bytes.Buffer
+	n, err := db.ExportUsersCSV(dbConn, &buf, filter)
+	if err != nil {
+		log.Printf("export: failed to export users: %v", err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось выгрузить клиентов."))
+		return err
+	}
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: exportFileName(filter, time.Now()), Bytes: buf.Bytes()})
+	doc.Caption = exportCaption(filter, n)
+	if _, err := bot.Send(doc); err != nil {
+		log.Printf("export: failed to send export to chat %d: %v", chatID, err)
+		return err
+	}
+	return nil
+}
+
+// exportSchedule читает настройки регулярной выгрузки: EXPORT_SCHEDULE (daily или weekly),
+// EXPORT_CHAT_ID - чат менеджера, EXPORT_TIME - время отправки. ok=false - выгрузка выключена
+func exportSchedule() (schedule string, chatID int64, at time.Duration, ok bool) {
+	schedule = strings.ToLower(strings.TrimSpace(os.Getenv("EXPORT_SCHEDULE")))
+	if schedule != "daily" && schedule != "weekly" {
+		if schedule != "" && schedule != "off" {
+			log.Printf("export: unknown EXPORT_SCHEDULE %q", schedule)
+		}
+		return "", 0, 0, false
+	}
+
+	chatID, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("EXPORT_CHAT_ID")), 10, 64)
+	if err != nil {
+		log.Printf("export: EXPORT_CHAT_ID is not set, scheduled export disabled")
+		return "", 0, 0, false
+	}
+
+	raw := strings.TrimSpace(os.Getenv("EXPORT_TIME"))
+	if raw == "" {
+		raw = defaultExportTime
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		log.Printf("export: invalid EXPORT_TIME %q, using %s", raw, defaultExportTime)
+		t, _ = time.Parse("15:04", defaultExportTime)
+	}
+	at = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	return schedule, chatID, at, true
+}
+
+// sendScheduledExport отправляет менеджеру выгрузку за прошлые сутки (daily) или прошлую неделю (weekly, по понедельникам).
+// Время отправки хранится в базе, поэтому после перезапуска выгрузка не дублируется
+func sendScheduledExport(bot tools.Sender, now time.Time) {
+	schedule, chatID, at, ok := exportSchedule()
+	if !ok {
+		return
+	}
+	if schedule == "weekly" && now.Weekday() != time.Monday {
+		return
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	due := today.Add(at)
+	if now.Before(due) {
+		return
+	}
+	last, err := db.LastRun(dbConn, exportJob)
+	if err != nil {
+		log.Printf("export: failed to load last run: %v", err)
+		return
+	}
+	if !last.Before(due) {
+		return
+	}
+	attempt, err := db.LastRun(dbConn, exportAttemptJob)
+	if err != nil {
+		log.Printf("export: failed to load last attempt: %v", err)
+		return
+	}
+	if !attempt.Before(due) && now.Sub(attempt) < exportRetryDelay {
+		return
+	}
+
+	filter := db.ExportFilter{From: today.AddDate(0, 0, -1), To: today}
+	if schedule == "weekly" {
+		filter.From = today.AddDate(0, 0, -7)
+	}
+	if err := sendUsersExport(bot, chatID, filter); err != nil {
+		// повторим не раньше чем через exportRetryDelay
+		if err := db.SaveRun(dbConn, exportAttemptJob, now); err != nil {
+			log.Printf("export: failed to save export attempt: %v", err)
+		}
+		return
+	}
+	if err := db.SaveRun(dbConn, exportJob, now); err != nil {
+		log.Printf("export: failed to save last run: %v", err)
+	}
+	log.Printf("export: sent %s export to chat %d", schedule, chatID)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"app/db"
+	"app/fakes"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExportArgs(t *testing.T) {
+	useDemoCatalog(t)
+	speaker := Speakers[0].Name
+
+	f, err := parseExportArgs("2024-05-01 31.05.2024 " + strings.ToUpper(speaker))
+	if err != nil {
+		t.Fatalf("parseExportArgs: %v", err)
+	}
+	want := db.ExportFilter{
+		From:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local),
+		To:      time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local),
+		Speaker: speaker,
+	}
+	if f != want {
+		t.Errorf("filter = %+v, want %+v", f, want)
+	}
+	if got := exportCaption(f, 3); got != "📥 Клиенты с 01.05.2024 по 31.05.2024, спикер "+speaker+": 3" {
+		t.Errorf("caption = %q", got)
+	}
+
+	for _, args := range []string{"2024-05-31 2024-05-01", "Нет Такого", "2024-01-01 2024-02-01 2024-03-01"} {
+		if _, err := parseExportArgs(args); err == nil {
+			t.Errorf("parseExportArgs(%q): expected error", args)
+		}
+	}
+}
+
+func TestExportUsersFiltered(t *testing.T) {
+	useTestDB(t)
+	for id, speaker := range map[int64]string{1: "Мария", 2: "Иван"} {
+		if err := db.UpsertUser(dbConn, id, "", "Клиент", "", speaker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dbConn.Exec("UPDATE users SET first_seen = '2024-05-10 12:00:00' WHERE chat_id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	// новый визит не переносит клиента из периода, в котором он пришёл
+	if err := db.UpsertUser(dbConn, 2, "+79990000000", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := db.ExportUsersCSV(dbConn, &buf, db.ExportFilter{
+		From: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local),
+		To:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if n != 1 || len(lines) != 2 || !strings.HasPrefix(lines[0], "chat_id,") || !strings.Contains(lines[1], "Иван") {
+		t.Errorf("export (%d) = %q", n, buf.String())
+	}
+
+	buf.Reset()
+	if n, err := db.ExportUsersCSV(dbConn, &buf, db.ExportFilter{Speaker: "Мария"}); err != nil || n != 1 {
+		t.Errorf("speaker export = %d, %v", n, err)
+	}
+}
+
+func TestScheduledExport(t *testing.T) {
+	const manager = -100500
+	useTestDB(t)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("EXPORT_SCHEDULE", "weekly")
+	t.Setenv("EXPORT_CHAT_ID", "-100500")
+	t.Setenv("EXPORT_TIME", "09:30")
+
+	monday := time.Date(2026, time.October, 19, 9, 0, 0, 0, time.Local)
+	sendScheduledExport(bot, monday) // рано
+	sendScheduledExport(bot, monday.Add(24*time.Hour+time.Hour))
+	if n := len(tg.Sent(manager)); n != 0 {
+		t.Fatalf("sent %d exports before schedule", n)
+	}
+
+	sendScheduledExport(bot, monday.Add(time.Hour))
+	sendScheduledExport(bot, monday.Add(2*time.Hour))
+	sent := tg.Sent(manager)
+	if len(sent) != 1 || sent[0].Method != "sendDocument" {
+		t.Fatalf("sent = %+v", sent)
+	}
+	if got := sent[0].Text(); got != "📥 Клиенты с 12.10.2026 по 18.10.2026: 0" {
+		t.Errorf("caption = %q", got)
+	}
+	if got := sent[0].Files["document"]; got != "clients-2026-10-12-2026-10-18.csv" {
+		t.Errorf("file name = %q", got)
+	}
+
+	// неделю спустя - снова
+	sendScheduledExport(bot, monday.AddDate(0, 0, 7).Add(time.Hour))
+	if n := len(tg.Sent(manager)); n != 2 {
+		t.Errorf("exports after a week = %d", n)
+	}
+}
+
+func TestScheduledExportBacksOffAfterFailure(t *testing.T) {
+	const manager = -100500
+	useTestDB(t)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("EXPORT_SCHEDULE", "daily")
+	t.Setenv("EXPORT_CHAT_ID", "-100500")
+	t.Setenv("EXPORT_TIME", "09:30")
+
+	tg.FailChat(manager, fakes.TelegramBlocked)
+	due := time.Date(2026, time.October, 19, 9, 30, 0, 0, time.Local)
+	sendScheduledExport(bot, due)
+	sendScheduledExport(bot, due.Add(5*time.Minute))
+	sendScheduledExport(bot, due.Add(30*time.Minute))
+	if n := len(tg.Sent(manager)); n != 1 {
+		t.Fatalf("attempts before retry delay = %d", n)
+	}
+
+	sendScheduledExport(bot, due.Add(exportRetryDelay))
+	sendScheduledExport(bot, due.Add(exportRetryDelay+5*time.Minute))
+	if n := len(tg.Sent(manager)); n != 2 {
+		t.Errorf("attempts after retry delay = %d", n)
+	}
+}
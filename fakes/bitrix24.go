@@ -0,0 +1,456 @@
+// Package fakes содержит in-process заглушки внешних API для интеграционных тестов бота
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BitrixContact - контакт, созданный в фейковом Bitrix24
+type BitrixContact struct {
+	ID    int
+	Name  string
+	Phone string
+}
+
+// BitrixItem - элемент смарт-процесса, созданный в фейковом Bitrix24
+type BitrixItem struct {
+	ID           int
+	EntityTypeID int
+	Title        string
+	ContactIDs   []int
+	StageID      string
+	Fields       map[string]any // все переданные поля элемента
+}
+
+// BitrixComment - комментарий таймлайна
+type BitrixComment struct {
+	EntityID   string
+	EntityType string
+	Comment    string
+}
+
+// BitrixFailure описывает ошибочный ответ: HTTP-статус и/или тело {"error": ...}
+type BitrixFailure struct {
+	Status      int
+	Error       string
+	Description string
+}
+
+// BitrixRateLimit - ответ Bitrix24 при превышении лимита запросов
+var BitrixRateLimit = BitrixFailure{
+	Status:      http.StatusServiceUnavailable,
+	Error:       "QUERY_LIMIT_EXCEEDED",
+	Description: "Too many requests",
+}
+
+// Bitrix24 - фейковый REST API Bitrix24 (входящий вебхук). Поддерживает crm.contact.list,
+// crm.contact.add, crm.item.add, crm.item.get, crm.item.update, crm.timeline.comment.add и batch
+type Bitrix24 struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	contacts []BitrixContact
+	items    []BitrixItem
+	comments []BitrixComment
+	calls    []string
+	failures map[string][]BitrixFailure
+	nextID   int
+}
+
+// NewBitrix24 запускает фейковый Bitrix24, сервер останавливается через Close
+func NewBitrix24() *Bitrix24 {
+	f := &Bitrix24{
+		failures: make(map[string][]BitrixFailure),
+		nextID:   1,
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// URL - базовый адрес вебхука для BitrixClient
+func (f *Bitrix24) URL() string {
+	return f.server.URL + "/rest/1/token"
+}
+
+// Client возвращает HTTP-клиент, настроенный на фейковый сервер
+func (f *Bitrix24) Client() *http.Client {
+	return f.server.Client()
+}
+
+func (f *Bitrix24) Close() {
+	f.server.Close()
+}
+
+// Fail ставит в очередь ошибочные ответы для метода (в том числе внутри batch)
+func (f *Bitrix24) Fail(method string, failures ...BitrixFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], failures...)
+}
+
+// AddContact добавляет существующий контакт
+func (f *Bitrix24) AddContact(name, phone string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addContact(name, phone)
+}
+
+// SetItemStage меняет стадию элемента, как это сделал бы менеджер
+func (f *Bitrix24) SetItemStage(id int, stageID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.items {
+		if f.items[i].ID == id {
+			f.items[i].StageID = stageID
+		}
+	}
+}
+
+func (f *Bitrix24) Contacts() []BitrixContact {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]BitrixContact(nil), f.contacts...)
+}
+
+func (f *Bitrix24) Items() []BitrixItem {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]BitrixItem(nil), f.items...)
+}
+
+func (f *Bitrix24) Comments() []BitrixComment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]BitrixComment(nil), f.comments...)
+}
+
+// Calls возвращает вызванные HTTP-методы в порядке поступления
+func (f *Bitrix24) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *Bitrix24) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	var params map[string]any
+	body, _ := io.ReadAll(r.Body)
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &params); err != nil {
+			writeBitrixJSON(w, http.StatusBadRequest, map[string]any{"error": "INVALID_REQUEST", "error_description": err.Error()})
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, method)
+
+	if failure, ok := f.popFailure(method); ok {
+		writeBitrixFailure(w, failure)
+		return
+	}
+
+	if method == "batch" {
+		writeBitrixJSON(w, http.StatusOK, map[string]any{"result": f.batch(params, batchOrder(body))})
+		return
+	}
+
+	result, err := f.call(method, params)
+	if err != nil {
+		writeBitrixJSON(w, http.StatusBadRequest, map[string]any{"error": "ERROR_CORE", "error_description": err.Error()})
+		return
+	}
+	writeBitrixJSON(w, http.StatusOK, map[string]any{"result": result})
+}
+
+func (f *Bitrix24) popFailure(method string) (BitrixFailure, bool) {
+	queue := f.failures[method]
+	if len(queue) == 0 {
+		return BitrixFailure{}, false
+	}
+	f.failures[method] = queue[1:]
+	return queue[0], true
+}
+
+// call выполняет метод над params в виде вложенных map (из JSON или разобранной строки запроса batch)
+func (f *Bitrix24) call(method string, params map[string]any) (any, error) {
+	switch method {
+	case "crm.contact.list":
+		phone := lookupString(params, "filter", "PHONE")
+		result := []map[string]any{}
+		for _, c := range f.contacts {
+			if phone == "" || c.Phone == phone {
+				result = append(result, map[string]any{"ID": strconv.Itoa(c.ID), "NAME": c.Name})
+			}
+		}
+		return result, nil
+	case "crm.contact.add":
+		name := lookupString(params, "fields", "NAME")
+		phone := lookupString(params, "fields", "PHONE", "0", "VALUE")
+		if phone == "" {
+			return nil, fmt.Errorf("phone is required")
+		}
+		return f.addContact(name, phone), nil
+	case "crm.item.add":
+		fields, _ := lookup(params, "fields").(map[string]any)
+		item := BitrixItem{
+			ID:           f.nextID,
+			EntityTypeID: lookupInt(params, "entityTypeId"),
+			Title:        lookupString(params, "fields", "title"),
+			Fields:       fields,
+		}
+		for _, v := range listValues(lookup(params, "fields", "contactIds")) {
+			id, err := strconv.Atoi(fmt.Sprint(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid contact id %v", v)
+			}
+			item.ContactIDs = append(item.ContactIDs, id)
+		}
+		if len(item.ContactIDs) == 0 {
+			return nil, fmt.Errorf("contactIds is required")
+		}
+		f.nextID++
+		f.items = append(f.items, item)
+		return map[string]any{"item": map[string]any{"id": item.ID, "title": item.Title}}, nil
+	case "crm.item.get", "crm.item.update":
+		id := lookupInt(params, "id")
+		for i := range f.items {
+			if f.items[i].ID != id {
+				continue
+			}
+			if method == "crm.item.update" {
+				fields, _ := lookup(params, "fields").(map[string]any)
+				if f.items[i].Fields == nil {
+					f.items[i].Fields = make(map[string]any)
+				}
+				for k, v := range fields {
+					f.items[i].Fields[k] = v
+				}
+				if stage := lookupString(params, "fields", "stageId"); stage != "" {
+					f.items[i].StageID = stage
+				}
+			}
+			it := f.items[i]
+			return map[string]any{"item": map[string]any{"id": it.ID, "title": it.Title, "stageId": it.StageID}}, nil
+		}
+		return nil, fmt.Errorf("item %d not found", id)
+	case "crm.timeline.comment.add":
+		comment := BitrixComment{
+			EntityID:   lookupString(params, "fields", "ENTITY_ID"),
+			EntityType: lookupString(params, "fields", "ENTITY_TYPE"),
+			Comment:    lookupString(params, "fields", "COMMENT"),
+		}
+		if comment.EntityID == "" || comment.Comment == "" {
+			return nil, fmt.Errorf("ENTITY_ID and COMMENT are required")
+		}
+		f.comments = append(f.comments, comment)
+		id := f.nextID
+		f.nextID++
+		return id, nil
+	default:
+		return nil, fmt.Errorf("method %s is not supported by fake", method)
+	}
+}
+
+func (f *Bitrix24) addContact(name, phone string) int {
+	id := f.nextID
+	f.nextID++
+	f.contacts = append(f.contacts, BitrixContact{ID: id, Name: name, Phone: phone})
+	return id
+}
+
+var bitrixResultRef = regexp.MustCompile(`\$result((?:\[[^\]]+\])+)`)
+
+// batch выполняет команды в порядке order, подставляя $result[...] из предыдущих результатов
+func (f *Bitrix24) batch(params map[string]any, order []string) map[string]any {
+	results := make(map[string]any)
+	errs := make(map[string]any)
+
+	cmds, _ := params["cmd"].(map[string]any)
+	for _, name := range order {
+		raw := fmt.Sprint(cmds[name])
+		raw = bitrixResultRef.ReplaceAllStringFunc(raw, func(ref string) string {
+			path := strings.Split(strings.Trim(strings.TrimPrefix(ref, "$result"), "[]"), "][")
+			var v any = results
+			for _, key := range path {
+				v = lookup(v, key)
+			}
+			if v == nil {
+				return ""
+			}
+			return fmt.Sprint(v)
+		})
+
+		method, query, _ := strings.Cut(raw, "?")
+		if failure, ok := f.popFailure(method); ok {
+			errs[name] = map[string]any{"error": failure.Error, "error_description": failure.Description}
+			continue
+		}
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			errs[name] = map[string]any{"error": "INVALID_REQUEST", "error_description": err.Error()}
+			continue
+		}
+		result, err := f.call(method, parsePHPQuery(values))
+		if err != nil {
+			errs[name] = map[string]any{"error": "ERROR_CORE", "error_description": err.Error()}
+			continue
+		}
+		// результат отдаётся через JSON, чтобы числа выглядели так же, как в ответе сервера
+		encoded, _ := json.Marshal(result)
+		var decoded any
+		_ = json.Unmarshal(encoded, &decoded)
+		results[name] = decoded
+	}
+
+	// пустые коллекции Bitrix24 (PHP) отдаёт массивом
+	response := map[string]any{"result": results, "result_error": errs}
+	if len(results) == 0 {
+		response["result"] = []any{}
+	}
+	if len(errs) == 0 {
+		response["result_error"] = []any{}
+	}
+	return response
+}
+
+// batchOrder возвращает имена команд batch в порядке следования в теле запроса:
+// Bitrix24 выполняет их именно в этом порядке, а map его теряет
+func batchOrder(body []byte) []string {
+	var request struct {
+		Cmd json.RawMessage `json:"cmd"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(request.Cmd)))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	var names []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return names
+		}
+		names = append(names, fmt.Sprint(tok))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return names
+		}
+	}
+	return names
+}
+
+// parsePHPQuery превращает a[b][0]=c во вложенные map
+func parsePHPQuery(values url.Values) map[string]any {
+	root := make(map[string]any)
+	for key, vals := range values {
+		if len(vals) == 0 {
+			continue
+		}
+		path := []string{key}
+		if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+			path = append([]string{key[:i]}, strings.Split(key[i+1:len(key)-1], "][")...)
+		}
+		node := root
+		for i, part := range path {
+			if part == "" {
+				part = strconv.Itoa(len(node))
+			}
+			if i == len(path)-1 {
+				node[part] = vals[len(vals)-1]
+				break
+			}
+			next, ok := node[part].(map[string]any)
+			if !ok {
+				next = make(map[string]any)
+				node[part] = next
+			}
+			node = next
+		}
+	}
+	return root
+}
+
+func lookup(v any, path ...string) any {
+	for _, key := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			v = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func lookupString(v any, path ...string) string {
+	value := lookup(v, path...)
+	if value == nil {
+		return ""
+	}
+	if n, ok := value.(float64); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func lookupInt(v any, path ...string) int {
+	n, _ := strconv.Atoi(lookupString(v, path...))
+	return n
+}
+
+// listValues возвращает элементы JSON-массива или map с числовыми ключами
+func listValues(v any) []any {
+	switch node := v.(type) {
+	case []any:
+		return node
+	case map[string]any:
+		values := make([]any, 0, len(node))
+		for i := 0; i < len(node); i++ {
+			if item, ok := node[strconv.Itoa(i)]; ok {
+				values = append(values, item)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func writeBitrixFailure(w http.ResponseWriter, failure BitrixFailure) {
+	status := failure.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if failure.Error == "" {
+		w.WriteHeader(status)
+		return
+	}
+	writeBitrixJSON(w, status, map[string]any{"error": failure.Error, "error_description": failure.Description})
+}
+
+func writeBitrixJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
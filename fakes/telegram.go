@@ -0,0 +1,251 @@
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TelegramRequest - запрос к фейковому Bot API
+type TelegramRequest struct {
+	Method string
+	ChatID int64
+	Params url.Values
+	Files  map[string]string // поле формы -> имя загруженного файла
+}
+
+// Text возвращает текст или подпись отправленного сообщения
+func (r TelegramRequest) Text() string {
+	if text := r.Params.Get("text"); text != "" {
+		return text
+	}
+	return r.Params.Get("caption")
+}
+
+// ReplyMarkup возвращает клавиатуру сообщения в виде JSON-строки
+func (r TelegramRequest) ReplyMarkup() string {
+	return r.Params.Get("reply_markup")
+}
+
+// Telegram - фейковый Bot API. Бот подключается к нему через tgbotapi.NewBotAPIWithAPIEndpoint(token, Endpoint()).
+// Сервер отвечает успехом на любой метод и записывает все запросы
+type Telegram struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	requests  []TelegramRequest
+	failures  map[string][]TelegramFailure
+	chatFails map[int64][]TelegramFailure
+	messageID int
+	fileID    int
+}
+
+// TelegramFailure - ответ Bot API с ошибкой, например 403 для заблокировавшего бота пользователя
+type TelegramFailure struct {
+	Code        int
+	Description string
+	RetryAfter  int // секунды для ответа 429 Too Many Requests
+}
+
+// TelegramBlocked - ответ Bot API, когда пользователь заблокировал бота
+var TelegramBlocked = TelegramFailure{Code: http.StatusForbidden, Description: "Forbidden: bot was blocked by the user"}
+
+// TelegramFloodWait - ответ Bot API при превышении лимита отправки
+var TelegramFloodWait = TelegramFailure{Code: http.StatusTooManyRequests, Description: "Too Many Requests: retry after 1", RetryAfter: 1}
+
+// NewTelegram запускает фейковый Bot API, сервер останавливается через Close
+func NewTelegram() *Telegram {
+	f := &Telegram{failures: make(map[string][]TelegramFailure), chatFails: make(map[int64][]TelegramFailure)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// Endpoint - шаблон адреса API для tgbotapi.NewBotAPIWithAPIEndpoint
+func (f *Telegram) Endpoint() string {
+	return f.server.URL + "/bot%s/%s"
+}
+
+// URL - базовый адрес сервера
+func (f *Telegram) URL() string {
+	return f.server.URL
+}
+
+func (f *Telegram) Close() {
+	f.server.Close()
+}
+
+// Fail ставит в очередь ошибочные ответы для метода
+func (f *Telegram) Fail(method string, failures ...TelegramFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], failures...)
+}
+
+// FailChat ставит в очередь ошибочные ответы для отправки сообщений (send*) в чат
+func (f *Telegram) FailChat(chatID int64, failures ...TelegramFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chatFails[chatID] = append(f.chatFails[chatID], failures...)
+}
+
+// Requests возвращает все запросы, кроме getMe, в порядке поступления
+func (f *Telegram) Requests() []TelegramRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]TelegramRequest(nil), f.requests...)
+}
+
+// Sent возвращает запросы отправки сообщений (send*) в чат
+func (f *Telegram) Sent(chatID int64) []TelegramRequest {
+	var sent []TelegramRequest
+	for _, r := range f.Requests() {
+		if r.ChatID == chatID && strings.HasPrefix(r.Method, "send") {
+			sent = append(sent, r)
+		}
+	}
+	return sent
+}
+
+// Reset забывает записанные запросы
+func (f *Telegram) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+}
+
+func (f *Telegram) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	req := TelegramRequest{Method: method, Files: make(map[string]string)}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			writeTelegramError(w, TelegramFailure{Code: http.StatusBadRequest, Description: err.Error()})
+			return
+		}
+		req.Params = url.Values(r.MultipartForm.Value)
+		for field, headers := range r.MultipartForm.File {
+			if len(headers) > 0 {
+				req.Files[field] = headers[0].Filename
+			}
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			writeTelegramError(w, TelegramFailure{Code: http.StatusBadRequest, Description: err.Error()})
+			return
+		}
+		req.Params = r.PostForm
+	}
+	req.ChatID, _ = strconv.ParseInt(req.Params.Get("chat_id"), 10, 64)
+
+	if method == "getMe" {
+		writeTelegramResult(w, map[string]any{"id": 1, "is_bot": true, "first_name": "Fake", "username": "fake_bot"})
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	failure, failed := f.popFailure(req)
+	var result any
+	if !failed {
+		result = f.result(req)
+	}
+	f.mu.Unlock()
+
+	if failed {
+		writeTelegramError(w, failure)
+		return
+	}
+	writeTelegramResult(w, result)
+}
+
+func (f *Telegram) popFailure(req TelegramRequest) (TelegramFailure, bool) {
+	if queue := f.chatFails[req.ChatID]; len(queue) > 0 && strings.HasPrefix(req.Method, "send") {
+		f.chatFails[req.ChatID] = queue[1:]
+		return queue[0], true
+	}
+
+	method := req.Method
+	queue := f.failures[method]
+	if len(queue) == 0 {
+		return TelegramFailure{}, false
+	}
+	f.failures[method] = queue[1:]
+	return queue[0], true
+}
+
+// result формирует ответ метода; загруженным файлам выдаются новые file_id
+func (f *Telegram) result(req TelegramRequest) any {
+	switch req.Method {
+	case "sendMediaGroup":
+		var media []map[string]any
+		_ = json.Unmarshal([]byte(req.Params.Get("media")), &media)
+		messages := make([]any, 0, len(media))
+		for _, m := range media {
+			kind, _ := m["type"].(string)
+			ref, _ := m["media"].(string)
+			messages = append(messages, f.message(req.ChatID, "", kind, f.fileIDFor(ref)))
+		}
+		return messages
+	case "sendPhoto":
+		return f.message(req.ChatID, req.Text(), "photo", f.fileIDFor(req.Params.Get("photo")))
+	case "sendDocument":
+		return f.message(req.ChatID, req.Text(), "document", f.fileIDFor(req.Params.Get("document")))
+	case "sendVideo":
+		return f.message(req.ChatID, req.Text(), "video", f.fileIDFor(req.Params.Get("video")))
+	case "sendMessage", "sendInvoice":
+		return f.message(req.ChatID, req.Text(), "", "")
+	case "getFile":
+		return map[string]any{"file_id": req.Params.Get("file_id"), "file_path": "documents/" + req.Params.Get("file_id")}
+	default:
+		return true
+	}
+}
+
+// fileIDFor возвращает переданный file_id или выдаёт новый для загруженного файла (attach://, пустое значение)
+func (f *Telegram) fileIDFor(ref string) string {
+	if ref != "" && !strings.HasPrefix(ref, "attach://") {
+		return ref
+	}
+	f.fileID++
+	return fmt.Sprintf("file-%d", f.fileID)
+}
+
+func (f *Telegram) message(chatID int64, text, kind, fileID string) map[string]any {
+	f.messageID++
+	msg := map[string]any{
+		"message_id": f.messageID,
+		"date":       0,
+		"chat":       map[string]any{"id": chatID, "type": "private"},
+	}
+	if text != "" {
+		msg["text"] = text
+	}
+	switch kind {
+	case "photo":
+		msg["photo"] = []map[string]any{{"file_id": fileID, "file_unique_id": fileID, "width": 100, "height": 100}}
+	case "document", "video":
+		msg[kind] = map[string]any{"file_id": fileID, "file_unique_id": fileID}
+	}
+	return msg
+}
+
+func writeTelegramResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeTelegramError(w http.ResponseWriter, failure TelegramFailure) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]any{"ok": false, "error_code": failure.Code, "description": failure.Description}
+	if failure.RetryAfter > 0 {
+		resp["parameters"] = map[string]any{"retry_after": failure.RetryAfter}
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
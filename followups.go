@@ -0,0 +1,199 @@
+package main
+
+import (
+	"app/catalog"
+	"app/db"
+	tools "app/handlers"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Шаги воронки, после которых клиент может «застрять»
+const (
+	funnelSpeaker = "speaker" // выбрал спикера, но не курс
+	funnelCourse  = "course"  // посмотрел программу, но не нажал «Оставить заявку»
+	funnelBooking = "booking" // нажал «Оставить заявку», но не поделился номером
+	funnelDone    = "done"    // оставил контакт или подписался на новые даты - напоминать не о чем
+)
+
+const (
+	defaultFollowUpDelays = "speaker=24h,course=24h,booking=3h"
+	defaultFollowUpMax    = 2
+
+	followUpOptOutData = "followup_off"
+	// followup_do_<catalog.SessionID>_<callback кнопки>: кнопка напоминания сначала восстанавливает
+	// курс в сессии диалога (она могла потеряться после перезапуска), затем выполняет действие
+	followUpActionPrefix = "followup_do_"
+	followUpOptOutButton = "🔕 Больше не напоминать"
+	followUpOptOutReply  = "Хорошо, больше не будем напоминать 🙌\nЕсли захотите вернуться к выбору курса — просто напишите /start."
+
+	followUpSpeakerTemplate = "Вы смотрели курсы %s 👀\nВыберите город и дату — расскажем подробнее!"
+	followUpCourseTemplate  = "Остались вопросы по курсу %s? 🤔\nОставьте заявку — менеджер всё расскажет и поможет с записью."
+	followUpBookingTemplate = "Вы почти записались на курс %s! ✨\nОсталось поделиться номером телефона — менеджер свяжется с вами."
+)
+
+// followUpDelays читает FOLLOWUP_DELAYS: через сколько бездействия на шаге отправлять напоминание,
+// например "course=24h,booking=3h"; шаги без значения не напоминаются, "off" отключает напоминания
+func followUpDelays() map[string]time.Duration {
+	raw := strings.TrimSpace(os.Getenv("FOLLOWUP_DELAYS"))
+	if raw == "" {
+		raw = defaultFollowUpDelays
+	}
+	if strings.EqualFold(raw, "off") {
+		return nil
+	}
+
+	delays := make(map[string]time.Duration)
+	for _, part := range strings.Split(raw, ",") {
+		step, value, _ := strings.Cut(part, "=")
+		d, err := parseOffset(value)
+		if err != nil || d <= 0 {
+			log.Printf("followups: invalid delay %q in FOLLOWUP_DELAYS", part)
+			continue
+		}
+		delays[strings.TrimSpace(step)] = d
+	}
+	return delays
+}
+
+// followUpMax читает FOLLOWUP_MAX_PER_USER - сколько всего напоминаний может получить один клиент
+func followUpMax() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("FOLLOWUP_MAX_PER_USER"))); err == nil && n >= 0 {
+		return n
+	}
+	return defaultFollowUpMax
+}
+
+// trackFunnel запоминает шаг воронки, на котором находится клиент
+func trackFunnel(chatID int64, step, speaker, city string) {
+	if err := db.SetFunnelStep(dbConn, chatID, step, speaker, city); err != nil {
+		log.Printf("followups: failed to save funnel step for chat %d: %v", chatID, err)
+	}
+}
+
+// touchFunnel откладывает напоминание: клиент что-то делает в боте
+func touchFunnel(chatID int64) {
+	if err := db.TouchFunnel(dbConn, chatID); err != nil {
+		log.Printf("followups: failed to touch funnel for chat %d: %v", chatID, err)
+	}
+}
+
+// optOutFollowUps обрабатывает кнопку «Больше не напоминать»
+func optOutFollowUps(bot tools.Sender, chatID int64) {
+	if err := db.OptOutFollowUps(dbConn, chatID); err != nil {
+		log.Printf("followups: failed to opt out chat %d: %v", chatID, err)
+		return
+	}
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, followUpOptOutReply))
+}
+
+// sendDueFollowUps отправляет напоминания клиентам, которые дольше заданного не двигаются по воронке
+func sendDueFollowUps(bot tools.Sender, now time.Time) {
+	maxFollowUps := followUpMax()
+	if maxFollowUps == 0 {
+		return
+	}
+
+	for step, delay := range followUpDelays() {
+		states, err := db.GetStalledFunnels(dbConn, step, now.Add(-delay), maxFollowUps)
+		if err != nil {
+			log.Printf("followups: failed to load funnels: %v", err)
+			return
+		}
+		for _, st := range states {
+			msg, ok := followUpMessage(st)
+			if !ok {
+				continue
+			}
+			if _, err := bot.Send(msg); err != nil {
+				log.Printf("followups: failed to send follow-up to chat %d: %v", st.ChatID, err)
+				continue
+			}
+			if err := db.MarkFollowUpSent(dbConn, st.ChatID); err != nil {
+				log.Printf("followups: failed to mark follow-up for chat %d: %v", st.ChatID, err)
+			}
+			log.Printf("followups: sent %s follow-up to chat %d", st.Step, st.ChatID)
+		}
+	}
+}
+
+// followUpMessage собирает напоминание для шага воронки с клавиатурой, ведущей к следующему шагу.
+// Состояние диалога не меняет: курс восстанавливается, только когда клиент нажмёт кнопку
+func followUpMessage(st db.FunnelState) (tgbotapi.MessageConfig, bool) {
+	speakerIdx := -1
+	for i, s := range Speakers {
+		if s.Name == st.Speaker {
+			speakerIdx = i
+		}
+	}
+	if speakerIdx < 0 {
+		// спикера убрали из каталога
+		return tgbotapi.MessageConfig{}, false
+	}
+
+	var (
+		text     string
+		keyboard tgbotapi.InlineKeyboardMarkup
+	)
+	switch st.Step {
+	case funnelSpeaker:
+		text = fmt.Sprintf(followUpSpeakerTemplate, st.Speaker)
+		keyboard = CourseKeyboard(speakerIdx)
+		if len(keyboard.InlineKeyboard) == 0 {
+			return tgbotapi.MessageConfig{}, false
+		}
+	case funnelCourse, funnelBooking:
+		course, ok := findCourse(st.Speaker, st.City)
+		if !ok || soldOut(st.Speaker, course) {
+			return tgbotapi.MessageConfig{}, false
+		}
+		sessionID := catalog.SessionID(st.Speaker, st.City)
+		title := fmt.Sprintf("%s — %s", st.Speaker, st.City)
+		if st.Step == funnelCourse {
+			text = fmt.Sprintf(followUpCourseTemplate, title)
+			keyboard = CourseActionKeyboard(course)
+		} else {
+			text = fmt.Sprintf(followUpBookingTemplate, title)
+			keyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("📝 Оставить заявку", "book_course"),
+			))
+		}
+		for _, row := range keyboard.InlineKeyboard {
+			for i := range row {
+				if data := row[i].CallbackData; data != nil {
+					action := followUpActionPrefix + sessionID + "_" + *data
+					row[i].CallbackData = &action
+				}
+			}
+		}
+	default:
+		return tgbotapi.MessageConfig{}, false
+	}
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(followUpOptOutButton, followUpOptOutData),
+	))
+	msg := tgbotapi.NewMessage(st.ChatID, text)
+	msg.ReplyMarkup = keyboard
+	return msg, true
+}
+
+// restoreFollowUpSession восстанавливает курс из кнопки напоминания в сессии диалога и возвращает
+// callback самого действия; ok=false - сессии курса больше нет в каталоге
+func restoreFollowUpSession(chatID int64, data string) (action string, ok bool) {
+	sessionID, action, _ := strings.Cut(strings.TrimPrefix(data, followUpActionPrefix), "_")
+	speakerIdx, courseIdx := findSessionByID(sessionID)
+	if speakerIdx < 0 {
+		return "", false
+	}
+	course := Speakers[speakerIdx].Courses[courseIdx]
+	setSessionCourse(chatID, Speakers[speakerIdx].Name, course.City)
+	userSpeakerDir[chatID] = speakerDir(course.Program)
+	return action, true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"app/catalog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollowUps(t *testing.T) {
+	useTestDB(t)
+	bitrix, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("FOLLOWUP_DELAYS", "speaker=24h,course=3h,booking=1h")
+	t.Setenv("FOLLOWUP_MAX_PER_USER", "2")
+	useCatalog(t, []Speaker{{Name: "Мария", Courses: []Course{{City: "Казань | 15 июля", Program: "Описание"}}}})
+
+	// 1 - посмотрел программу, 2 - нажал «Оставить заявку», 3 - оставил контакт, 4 - выбрал спикера и отказался
+	HandleCallback(bot, callbackUpdate(1, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(2, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(2, "book_course"))
+	HandleCallback(bot, callbackUpdate(3, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(3, consentAcceptData))
+	HandleMessage(bot, contactUpdate(3, "+79991234567"))
+	HandleCallback(bot, callbackUpdate(4, speakerData(0)))
+	HandleCallback(bot, callbackUpdate(4, followUpOptOutData))
+	tg.Reset()
+
+	// через 2 часа после перезапуска - только напоминание про незавершённую заявку
+	resetChatState()
+	sendDueFollowUps(bot, time.Now().Add(2*time.Hour))
+	if sent := tg.Sent(1); len(sent) != 0 {
+		t.Errorf("course follow-up too early: %+v", sent)
+	}
+	bookData := followUpActionPrefix + catalog.SessionID("Мария", "Казань | 15 июля") + "_book_course"
+	sent := tg.Sent(2)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text(), "Вы почти записались") ||
+		!strings.Contains(sent[0].ReplyMarkup(), bookData) || !strings.Contains(sent[0].ReplyMarkup(), followUpOptOutData) {
+		t.Fatalf("booking follow-up = %+v", sent)
+	}
+	// напоминание не трогает сессию диалога - курс восстановится по кнопке
+	if session := snapshotSession(2); session != nil {
+		t.Errorf("follow-up changed session: %+v", session)
+	}
+
+	// через 4 часа - про программу; повторно тому же клиенту на том же шаге не пишем
+	tg.Reset()
+	sendDueFollowUps(bot, time.Now().Add(4*time.Hour))
+	if sent := tg.Sent(1); len(sent) != 1 || !strings.Contains(sent[0].ReplyMarkup(), "needed_tools") {
+		t.Errorf("course follow-up = %+v", sent)
+	}
+	if sent := tg.Sent(2); len(sent) != 0 {
+		t.Errorf("booking follow-up repeated: %+v", sent)
+	}
+	for _, chatID := range []int64{3, 4} {
+		if sent := tg.Sent(chatID); len(sent) != 0 {
+			t.Errorf("chat %d: unexpected follow-up %+v", chatID, sent)
+		}
+	}
+
+	// после активности клиент снова может получить напоминание, но не больше лимита
+	for i := 0; i < 3; i++ {
+		HandleMessage(bot, messageUpdate(1, "подумаю"))
+		tg.Reset()
+		sendDueFollowUps(bot, time.Now().Add(4*time.Hour))
+		want := 0
+		if i == 0 {
+			want = 1
+		}
+		if n := len(tg.Sent(1)); n != want {
+			t.Errorf("round %d: follow-ups = %d, want %d", i, n, want)
+		}
+	}
+
+	// кнопка из напоминания восстанавливает курс и ведёт к заявке
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(2, bookData))
+	HandleCallback(bot, callbackUpdate(2, consentAcceptData))
+	HandleMessage(bot, contactUpdate(2, "+79997654321"))
+	if n := len(bitrix.Items()); n != 2 {
+		t.Errorf("items = %d, want 2", n)
+	}
+}
@@ -8,6 +8,7 @@ require github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 
 require github.com/joho/godotenv v1.5.1
 
-require github.com/mattn/go-sqlite3 v1.14.28
-
-require golang.org/x/text v0.29.0 // indirect
+require (
+	github.com/mattn/go-sqlite3 v1.14.28
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -3,9 +3,9 @@ package main
 import (
 	"app/db"
 	tools "app/handlers"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -21,18 +21,29 @@ const (
 	courseHeaderTemplate       = "Отправляю программу курса «%s»"
 	nextStepMessage            = "Что делаем дальше?"
 	bookCourseFallbackMessage  = "Не удалось найти информацию о бронировании курса. Напишите нам, пожалуйста. @krasivyimk"
+	noSessionsMessage          = "Сейчас у этого спикера нет свободных дат 😔\nХотите, сообщим, когда появятся новые?"
+	staleCatalogText           = "Список курсов обновился 🔄 Выберите, пожалуйста, ещё раз:"
+	programUnavailableMessage  = "Программа курса скоро появится — менеджер пришлёт её лично. 🙌"
 )
 
-func HandleMessage(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+func HandleMessage(bot tools.Sender, update tgbotapi.Update) {
 	user := update.Message.From
 	chatID := update.Message.Chat.ID
 	phone := ""
 
-	if update.Message.Contact != nil {
-		phone = update.Message.Contact.PhoneNumber
+	// 152-ФЗ: без согласия на обработку персональных данных телефон не сохраняем и в CRM не передаём
+	consented := update.Message.Contact != nil && hasConsent(chatID)
+	if consented {
+		phone = contactPhone(update.Message.Contact)
 	}
 
-	err := db.UpsertUser(
+	// новый пользователь - тот, кого до этого сообщения не было в базе; приглашение засчитывается только ему
+	existed, err := db.UserExists(dbConn, chatID)
+	if err != nil {
+		log.Println("failed to check user:", err)
+		existed = true
+	}
+	err = db.UpsertUser(
 		dbConn,
 		chatID,
 		phone,
@@ -44,10 +55,34 @@ func HandleMessage(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 		log.Println("failed to upsert user:", err)
 	}
 
-	if update.Message.Contact != nil {
-		msg := tgbotapi.NewMessage(chatID, contactConfirmationMessage)
-		tools.SendAndLog(bot, msg)
+	// оплата приходит служебным сообщением, отвечать на него приветствием не нужно
+	if update.Message.SuccessfulPayment != nil {
+		handleSuccessfulPayment(bot, chatID, update.Message.SuccessfulPayment)
+		return
+	}
+
+	if isAdmin(user.ID) && (handleAdminCommand(bot, update.Message) ||
+		handleAdminMessage(bot, update.Message) ||
+		handleProgramUpload(bot, update.Message)) {
+		return
+	}
 
+	// t.me/<бот>?start=<payload> - метки источника, а иногда и сразу спикер или курс
+	switch update.Message.Command() {
+	case "start":
+		if handleStartLink(bot, chatID, user, update.Message.CommandArguments(), !existed) {
+			return
+		}
+	case "invite":
+		sendReferralStatus(bot, chatID)
+		return
+	}
+
+	if update.Message.Contact != nil {
+		if !consented {
+			sendConsentRequest(bot, chatID)
+			return
+		}
 		contactName := strings.TrimSpace(user.FirstName + " " + user.LastName)
 		if c := update.Message.Contact; c != nil {
 			candidate := strings.TrimSpace(c.FirstName + " " + c.LastName)
@@ -57,38 +92,95 @@ func HandleMessage(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 		}
 		setSessionContact(chatID, phone, contactName)
 
+		// пока клиент думал, последнее место могли занять: заявку и бронь не создаём, предлагаем лист ожидания
+		if sessionSoldOut(chatID) {
+			sendSessionSoldOut(bot, chatID)
+			return
+		}
+
+		msg := tgbotapi.NewMessage(chatID, contactConfirmationMessage)
+		tools.SendAndLog(bot, msg)
+		trackFunnel(chatID, funnelDone, "", "")
+
 		trySyncBitrixDeal(bot, chatID)
+		sendReferralInvite(bot, chatID)
 		return
 	}
 
+	if text := update.Message.Text; text != "" && !update.Message.IsCommand() {
+		if handlePromoMessage(bot, chatID, text) {
+			return
+		}
+		appendBitrixClientMessage(chatID, text)
+	}
+	touchFunnel(chatID)
+
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, greetingMessage)
 	msg.ReplyMarkup = SpeakerKeyboard()
 	tools.SendAndLog(bot, msg)
 }
 
-func HandleCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+func HandleCallback(bot tools.Sender, update tgbotapi.Update) {
 	data := update.CallbackQuery.Data
 	chatID := update.CallbackQuery.Message.Chat.ID
 
+	if strings.HasPrefix(data, followUpActionPrefix) {
+		action, ok := restoreFollowUpSession(chatID, data)
+		if !ok {
+			tools.SendAndLog(bot, staleCatalogMessage(chatID))
+		}
+		data = action
+	}
+
 	switch {
 	case strings.HasPrefix(data, "speaker_"):
-		pickSpeaker(data, bot, chatID, update)
+		pickSpeaker(data, bot, chatID, update.CallbackQuery.From)
 	case strings.HasPrefix(data, "course_"):
-		pickCourse(data, bot, chatID, update)
+		pickCourse(data, bot, chatID, update.CallbackQuery.From)
+	case strings.HasPrefix(data, "waitlist_"):
+		joinWaitlist(data, bot, chatID)
+	case data == promoEnterData:
+		askPromoCode(bot, chatID)
+	case data == payDepositData:
+		sendDepositInvoice(bot, chatID)
+	case data == payLinkData:
+		sendPaymentLink(bot, chatID)
+	case data == consentAcceptData:
+		acceptConsent(bot, chatID)
+	case data == followUpOptOutData:
+		optOutFollowUps(bot, chatID)
+	case data == broadcastSendData || data == broadcastCancelData:
+		if isAdmin(update.CallbackQuery.From.ID) {
+			handleBroadcastCallback(bot, chatID, data)
+		}
+	case strings.HasPrefix(data, "admin_"):
+		if isAdmin(update.CallbackQuery.From.ID) {
+			handleAdminCallback(bot, chatID, data)
+		}
+	case strings.HasPrefix(data, "upload_"):
+		if isAdmin(update.CallbackQuery.From.ID) {
+			handleUploadCallback(bot, chatID, data)
+		}
 	case data == "book_course":
+		if sessionSoldOut(chatID) {
+			sendSessionSoldOut(bot, chatID)
+			break
+		}
+
 		text, err := tools.ReadTextFile(bookCourseInfoPath)
 		if err != nil {
 			text = bookCourseFallbackMessage
 		}
 
-		msg := tgbotapi.NewMessage(chatID, text)
-		msg.ReplyMarkup = ContactKeyboard()
-		tools.SendAndLog(bot, msg)
+		requestContact(bot, chatID, text)
+		trackFunnel(chatID, funnelBooking, "", "")
 
 	case data == "needed_tools":
 		speakerDir := userSpeakerDir[chatID]
 		msg := tgbotapi.NewMessage(chatID, tools.GetToolsText(speakerDir))
 		tools.SendAndLog(bot, msg)
+		setSessionPaymentViewed(chatID)
+		touchFunnel(chatID)
 	}
 
 	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
@@ -97,10 +189,14 @@ func HandleCallback(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
 	}
 }
 
-func pickSpeaker(data string, bot *tgbotapi.BotAPI, chatID int64, update tgbotapi.Update) {
-	idx, _ := strconv.Atoi(strings.TrimPrefix(data, "speaker_"))
+func pickSpeaker(data string, bot tools.Sender, chatID int64, user *tgbotapi.User) {
+	idx := findSpeakerByID(strings.TrimPrefix(data, "speaker_"))
+	if idx < 0 {
+		// кнопка из старого сообщения, спикера с тех пор убрали из каталога
+		tools.SendAndLog(bot, staleCatalogMessage(chatID))
+		return
+	}
 	speaker := Speakers[idx].Name
-	user := update.CallbackQuery.From
 
 	err := db.UpsertUser(
 		dbConn,
@@ -115,24 +211,29 @@ func pickSpeaker(data string, bot *tgbotapi.BotAPI, chatID int64, update tgbotap
 	}
 
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(speakerPromptTemplate, speaker))
-	msg.ReplyMarkup = CourseKeyboard(idx)
+	if keyboard := CourseKeyboard(idx); len(keyboard.InlineKeyboard) > 0 {
+		msg.ReplyMarkup = keyboard
+	} else {
+		// дат нет или все сессии заполнены и закрыты
+		msg.Text = noSessionsMessage
+		msg.ReplyMarkup = WaitlistKeyboard(idx, -1)
+	}
 	tools.SendAndLog(bot, msg)
 
 	setSessionCourse(chatID, speaker, "")
+	trackFunnel(chatID, funnelSpeaker, speaker, "")
 }
 
-func pickCourse(data string, bot *tgbotapi.BotAPI, chatID int64, update tgbotapi.Update) {
-	parts := strings.Split(strings.TrimPrefix(data, "course_"), "_")
-	if len(parts) < 2 {
+func pickCourse(data string, bot tools.Sender, chatID int64, user *tgbotapi.User) {
+	speakerIdx, courseIdx := findSessionByID(strings.TrimPrefix(data, "course_"))
+	if speakerIdx < 0 {
+		// кнопка из старого сообщения, сессии с тех пор нет в каталоге
+		tools.SendAndLog(bot, staleCatalogMessage(chatID))
 		return
 	}
 
-	speakerIdx, _ := strconv.Atoi(parts[0])
-	courseIdx, _ := strconv.Atoi(parts[1])
-
 	course := Speakers[speakerIdx].Courses[courseIdx]
 	city := course.City
-	user := update.CallbackQuery.From
 
 	err := db.UpsertUser(
 		dbConn,
@@ -146,14 +247,7 @@ func pickCourse(data string, bot *tgbotapi.BotAPI, chatID int64, update tgbotapi
 		log.Println("failed to update user city:", err)
 	}
 
-	programPath := strings.TrimPrefix(course.Program, "/")
-	speakerDir := programPath
-	if strings.Contains(programPath, "/") {
-		speakerDir = strings.SplitN(programPath, "/", 2)[0]
-	} else if strings.Contains(programPath, "\\") {
-		speakerDir = strings.SplitN(programPath, "\\", 2)[0]
-	}
-	userSpeakerDir[chatID] = speakerDir
+	userSpeakerDir[chatID] = speakerDir(course.Program)
 
 	speakerName := Speakers[speakerIdx].Name
 	courseTitle := strings.TrimSpace(course.City)
@@ -161,20 +255,65 @@ func pickCourse(data string, bot *tgbotapi.BotAPI, chatID int64, update tgbotapi
 		courseTitle = "курс"
 	}
 
-	courseDisplay := fmt.Sprintf("%s — %s", speakerName, courseTitle)
-	header := tgbotapi.NewMessage(chatID, fmt.Sprintf(courseHeaderTemplate, courseDisplay))
-	tools.SendAndLog(bot, header)
+	if course.HasCard() {
+		if err := tools.SendCourseCard(bot, dbConn, chatID, course.Cover, courseCardText(speakerName, course)); err != nil {
+			log.Printf("failed to send course card: %v", err)
+		}
+	} else {
+		courseDisplay := fmt.Sprintf("%s — %s", speakerName, courseTitle)
+		header := tgbotapi.NewMessage(chatID, fmt.Sprintf(courseHeaderTemplate, courseDisplay))
+		tools.SendAndLog(bot, header)
+	}
 
-	if err := tools.SendCourseProgram(bot, chatID, course.Program); err != nil {
+	err = tools.SendCourseProgram(bot, dbConn, chatID, course.Program)
+	switch {
+	case errors.Is(err, tools.ErrProgramFileMissing):
+		// путь к файлу клиенту не показываем, ошибку видно в логе
+		log.Printf("course program unavailable for %s: %v", speakerName, err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, programUnavailableMessage))
+	case err != nil:
 		log.Printf("failed to send course program: %v", err)
 		return
 	}
 
+	if soldOut(speakerName, course) {
+		// программу показываем, но записаться на заполненную сессию нельзя
+		soldOutMsg := tgbotapi.NewMessage(chatID, soldOutMessage)
+		soldOutMsg.ReplyMarkup = WaitlistKeyboard(speakerIdx, courseIdx)
+		tools.SendAndLog(bot, soldOutMsg)
+		return
+	}
+
 	msg := tgbotapi.NewMessage(chatID, nextStepMessage)
-	msg.ReplyMarkup = CourseActionKeyboard()
+	msg.ReplyMarkup = CourseActionKeyboard(course)
 	tools.SendAndLog(bot, msg)
 
 	setSessionCourse(chatID, speakerName, city)
+	setSessionProgram(chatID, course.Program)
+	trackFunnel(chatID, funnelCourse, speakerName, city)
+
+	// телефон оставлен до выбора курса - это первая заявка. Если сделка уже есть, просмотр другого курса
+	// новую не создаёт: для этого клиент записывается или вносит предоплату
+	if !chatSyncedBitrix(chatID) {
+		trySyncBitrixDeal(bot, chatID)
+	}
+}
+
+// staleCatalogMessage - ответ на кнопку из сообщения, отправленного до обновления каталога
+func staleCatalogMessage(chatID int64) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(chatID, staleCatalogText)
+	msg.ReplyMarkup = SpeakerKeyboard()
+	return msg
+}
 
-	trySyncBitrixDeal(bot, chatID)
+// speakerDir возвращает папку спикера в data/ по пути к файлу программы
+func speakerDir(program string) string {
+	programPath := strings.TrimPrefix(program, "/")
+	dir := programPath
+	if strings.Contains(programPath, "/") {
+		dir = strings.SplitN(programPath, "/", 2)[0]
+	} else if strings.Contains(programPath, "\\") {
+		dir = strings.SplitN(programPath, "\\", 2)[0]
+	}
+	return dir
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Максимальная длина подписи к фото
+const telegramCaptionLimit = 1024
+
+// SendCourseCard Отправляет карточку курса (HTML-разметка): с обложкой, если она указана и есть в data/,
+// иначе - обычным сообщением. Карточка длиннее лимита подписи уходит отдельным сообщением после обложки
+func SendCourseCard(bot Sender, conn *sql.DB, chatID int64, cover, text string) error {
+	coverPath := ""
+	if cover = strings.TrimLeft(strings.ReplaceAll(strings.TrimSpace(cover), "\\", "/"), "/"); cover != "" {
+		coverPath = filepath.Join("data", filepath.FromSlash(cover))
+		if info, err := os.Stat(coverPath); err != nil || info.IsDir() {
+			log.Printf("Обложка курса не найдена: %s", cover)
+			coverPath = ""
+		}
+	}
+
+	sendText := func() error {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		_, err := bot.Send(msg)
+		return err
+	}
+	if coverPath == "" {
+		return sendText()
+	}
+
+	caption := ""
+	if len([]rune(text)) <= telegramCaptionLimit {
+		caption = text
+	}
+	err := sendCachedFile(bot, conn, coverPath, func(file tgbotapi.RequestFileData) tgbotapi.Chattable {
+		photo := tgbotapi.NewPhoto(chatID, file)
+		photo.Caption = caption
+		photo.ParseMode = tgbotapi.ModeHTML
+		return photo
+	})
+	if err != nil {
+		log.Println("Ошибка отправки обложки:", err)
+		return sendText()
+	}
+	if caption == "" {
+		return sendText()
+	}
+	return nil
+}
@@ -1,42 +1,381 @@
 package handlers
 
 import (
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"app/catalog"
+	"app/db"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	programCaption = "Программа курса"
+
+	// Telegram принимает в альбоме от 2 до 10 файлов
+	mediaGroupLimit = 10
+)
+
+// ErrProgramFileMissing - файл программы, указанный в каталоге, не найден на диске
+var ErrProgramFileMissing = errors.New("program file is missing")
+
+// programKind - способ отправки файла программы
+type programKind int
+
+const (
+	programText programKind = iota
+	programPhoto
+	programVideo
+	programDocument
+	programMarkdown
+	programPlainText
 )
 
-// SendCourseProgram Отправляем информацию по курсу
-func SendCourseProgram(bot *tgbotapi.BotAPI, chatID int64, program string) error {
-	ext := strings.ToLower(filepath.Ext(program))
-	baseDir := "data"
-
-	switch ext {
-	case ".jpg", ".jpeg", ".png":
-		filePath := filepath.Join(baseDir, program)
-		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FilePath(filePath))
-		photo.Caption = "Программа курса"
-		_, err := bot.Send(photo)
+// programKinds - как отправлять файлы каждого формата из catalog.ProgramExtensions
+var programKinds = map[string]programKind{
+	".jpg":  programPhoto,
+	".jpeg": programPhoto,
+	".png":  programPhoto,
+	".mp4":  programVideo,
+	".pdf":  programDocument,
+	".docx": programDocument,
+	".pptx": programDocument,
+	".md":   programMarkdown,
+	".txt":  programPlainText,
+}
+
+// programPart - один файл (или текст) программы курса
+type programPart struct {
+	kind programKind
+	path string // путь к файлу от рабочего каталога, для programText - сам текст
+}
+
+// SendCourseProgram Отправляем информацию по курсу.
+// Программа - текст или один/несколько файлов через ";" относительно data/: фото и видео уходят альбомом,
+// документы (pdf, docx, pptx) - альбомом документов, .md и .txt - отформатированным текстом.
+// Программа считается списком файлов, только если у каждого элемента поддерживаемое расширение.
+// Если файл не найден, возвращается ErrProgramFileMissing и клиенту ничего не отправляется.
+// Файлы загружаются в Telegram один раз: file_id хранится в conn и используется,
+// пока файл на диске не изменится. conn может быть nil - тогда файл загружается каждый раз
+func SendCourseProgram(bot Sender, conn *sql.DB, chatID int64, program string) error {
+	parts, err := parseProgram("data", program)
+	if err != nil {
+		return err
+	}
+
+	// подряд идущие фото/видео и документы отправляются альбомами
+	for i := 0; i < len(parts); {
+		j := i + 1
+		for j < len(parts) && j-i < mediaGroupLimit && sameAlbum(parts[i], parts[j]) {
+			j++
+		}
+		if err := sendProgramParts(bot, conn, chatID, parts[i:j]); err != nil {
+			return err
+		}
+		i = j
+	}
+	return nil
+}
+
+// parseProgram разбирает ячейку программы и проверяет, что все файлы есть на диске
+func parseProgram(baseDir, program string) ([]programPart, error) {
+	files := catalog.ProgramFiles(program)
+	if files == nil {
+		return []programPart{{kind: programText, path: program}}, nil
+	}
+
+	var parts []programPart
+	for _, ref := range files {
+		kind := programKinds[strings.ToLower(filepath.Ext(ref))]
+		path := filepath.Join(baseDir, filepath.FromSlash(ref))
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			return nil, fmt.Errorf("%w: %s", ErrProgramFileMissing, ref)
+		}
+		parts = append(parts, programPart{kind: kind, path: path})
+	}
+	return parts, nil
+}
+
+func sameAlbum(a, b programPart) bool {
+	media := func(p programPart) bool { return p.kind == programPhoto || p.kind == programVideo }
+	return media(a) && media(b) || a.kind == programDocument && b.kind == programDocument
+}
+
+// sendProgramParts отправляет одну часть программы отдельным сообщением или несколько - альбомом
+func sendProgramParts(bot Sender, conn *sql.DB, chatID int64, parts []programPart) error {
+	if len(parts) > 1 {
+		err := sendCachedMediaGroup(bot, conn, chatID, parts)
 		if err != nil {
-			log.Println("Ошибка отправки фото:", err)
+			log.Println("Ошибка отправки альбома:", err)
 		}
 		return err
-	case ".pdf":
-		filePath := filepath.Join(baseDir, program)
-		doc := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(filePath))
-		doc.Caption = "Программа курса"
-		_, err := bot.Send(doc)
+	}
+
+	part := parts[0]
+	var err error
+	switch part.kind {
+	case programPhoto:
+		err = sendCachedFile(bot, conn, part.path, func(file tgbotapi.RequestFileData) tgbotapi.Chattable {
+			photo := tgbotapi.NewPhoto(chatID, file)
+			photo.Caption = programCaption
+			return photo
+		})
+		if err != nil {
+			log.Println("Ошибка отправки фото:", err)
+		}
+	case programVideo:
+		err = sendCachedFile(bot, conn, part.path, func(file tgbotapi.RequestFileData) tgbotapi.Chattable {
+			video := tgbotapi.NewVideo(chatID, file)
+			video.Caption = programCaption
+			return video
+		})
 		if err != nil {
-			log.Println("Ошибка отправки PDF:", err)
+			log.Println("Ошибка отправки видео:", err)
+		}
+	case programDocument:
+		err = sendCachedFile(bot, conn, part.path, func(file tgbotapi.RequestFileData) tgbotapi.Chattable {
+			doc := tgbotapi.NewDocument(chatID, file)
+			doc.Caption = programCaption
+			return doc
+		})
+		if err != nil {
+			log.Println("Ошибка отправки документа:", err)
+		}
+	case programMarkdown, programPlainText:
+		err = sendTextFile(bot, chatID, part)
+		if err != nil {
+			log.Println("Ошибка отправки текста программы:", err)
 		}
-		return err
 	default:
-		msg := tgbotapi.NewMessage(chatID, program)
-		_, err := bot.Send(msg)
+		_, err = bot.Send(tgbotapi.NewMessage(chatID, part.path))
 		if err != nil {
 			log.Println("Ошибка отправки текста:", err)
 		}
+	}
+	return err
+}
+
+// sendTextFile отправляет содержимое .md (с разметкой) или .txt, при необходимости несколькими сообщениями
+func sendTextFile(bot Sender, chatID int64, part programPart) error {
+	text, err := ReadTextFile(part.path)
+	if err != nil {
+		return err
+	}
+
+	parseMode := ""
+	chunks := SplitMessage(text)
+	if part.kind == programMarkdown {
+		parseMode = tgbotapi.ModeHTML
+		chunks = SplitHTMLMessage(MarkdownToHTML(text))
+	}
+
+	for _, chunk := range chunks {
+		msg := tgbotapi.NewMessage(chatID, chunk)
+		msg.ParseMode = parseMode
+		if _, err := bot.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendCachedFile отправляет файл по сохранённому file_id, а если его нет или Telegram его не принял -
+// загружает файл с диска и запоминает новый file_id
+func sendCachedFile(bot Sender, conn *sql.DB, filePath string, build func(tgbotapi.RequestFileData) tgbotapi.Chattable) error {
+	if conn == nil {
+		_, err := bot.Send(build(tgbotapi.FilePath(filePath)))
+		return err
+	}
+
+	state, fileID, err := cachedFileID(conn, filePath)
+	if err != nil {
+		return err
+	}
+	if fileID != "" {
+		_, err := bot.Send(build(tgbotapi.FileID(fileID)))
+		if err == nil {
+			return nil
+		}
+		log.Printf("Telegram не принял file_id для %s, загружаем файл заново: %v", filePath, err)
+		if err := db.DeleteCachedFileID(conn, filePath); err != nil {
+			log.Println("Ошибка очистки кеша файлов:", err)
+		}
+	}
+
+	sent, err := bot.Send(build(tgbotapi.FilePath(filePath)))
+	if err != nil {
 		return err
 	}
+
+	if fileID := sentFileID(sent); fileID != "" {
+		saveCachedFileID(conn, filePath, state, fileID)
+	}
+	return nil
+}
+
+// sendCachedMediaGroup отправляет альбом, используя сохранённые file_id; если Telegram их не принял -
+// загружает все файлы альбома заново. После каждой успешной отправки file_id загруженных файлов
+// сохраняются, поэтому альбом, где в кеше была только часть файлов, в следующий раз уйдёт целиком по file_id
+func sendCachedMediaGroup(bot Sender, conn *sql.DB, chatID int64, parts []programPart) error {
+	states := make([]db.CachedFile, len(parts))
+	fileIDs := make([]string, len(parts))
+	cached := false
+	if conn != nil {
+		for i, part := range parts {
+			var err error
+			if states[i], fileIDs[i], err = cachedFileID(conn, part.path); err != nil {
+				return err
+			}
+			cached = cached || fileIDs[i] != ""
+		}
+	}
+
+	var messages []tgbotapi.Message
+	var err error
+	if cached {
+		if messages, err = sendMediaGroup(bot, chatID, parts, fileIDs); err != nil {
+			log.Printf("Telegram не принял альбом с file_id, загружаем файлы заново: %v", err)
+		}
+	}
+	if !cached || err != nil {
+		fileIDs = make([]string, len(parts))
+		if messages, err = sendMediaGroup(bot, chatID, parts, fileIDs); err != nil {
+			return err
+		}
+	}
+	if conn == nil {
+		return nil
+	}
+	for i, msg := range messages {
+		if i >= len(parts) {
+			break
+		}
+		// файл ушёл по file_id из кеша - сохранять нечего
+		if fileIDs[i] != "" {
+			continue
+		}
+		if fileID := sentFileID(msg); fileID != "" {
+			saveCachedFileID(conn, parts[i].path, states[i], fileID)
+		}
+	}
+	return nil
+}
+
+// sendMediaGroup отправляет альбом; для файлов с непустым fileIDs[i] используется file_id, для остальных - загрузка
+func sendMediaGroup(bot Sender, chatID int64, parts []programPart, fileIDs []string) ([]tgbotapi.Message, error) {
+	media := make([]any, 0, len(parts))
+	for i, part := range parts {
+		var file tgbotapi.RequestFileData = tgbotapi.FilePath(part.path)
+		if fileIDs[i] != "" {
+			file = tgbotapi.FileID(fileIDs[i])
+		}
+
+		switch part.kind {
+		case programPhoto:
+			photo := tgbotapi.NewInputMediaPhoto(file)
+			if i == 0 {
+				photo.Caption = programCaption
+			}
+			media = append(media, photo)
+		case programVideo:
+			video := tgbotapi.NewInputMediaVideo(file)
+			if i == 0 {
+				video.Caption = programCaption
+			}
+			media = append(media, video)
+		default:
+			doc := tgbotapi.NewInputMediaDocument(file)
+			// у альбома документов подпись показывается под последним файлом
+			if i == len(parts)-1 {
+				doc.Caption = programCaption
+			}
+			media = append(media, doc)
+		}
+	}
+
+	resp, err := bot.Request(tgbotapi.NewMediaGroup(chatID, media))
+	if err != nil {
+		return nil, err
+	}
+	var messages []tgbotapi.Message
+	if err := json.Unmarshal(resp.Result, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// cachedFileID возвращает сохранённый file_id файла и текущее состояние файла для записи в кеш.
+// Пока размер и время изменения совпадают с сохранёнными, файл не перечитывается; иначе содержимое
+// сверяется по хешу, и если оно то же, кеш запоминает новое время изменения
+func cachedFileID(conn *sql.DB, path string) (db.CachedFile, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return db.CachedFile{}, "", err
+	}
+	state := db.CachedFile{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+
+	cached, err := db.GetCachedFile(conn, path)
+	if err != nil {
+		log.Println("Ошибка чтения кеша файлов:", err)
+	}
+	if cached != nil && cached.Size == state.Size && cached.ModTime == state.ModTime {
+		state.Hash = cached.Hash
+		return state, cached.FileID, nil
+	}
+
+	if state.Hash, err = fileHash(path); err != nil {
+		return state, "", err
+	}
+	if cached == nil || cached.Hash != state.Hash {
+		return state, "", nil
+	}
+	saveCachedFileID(conn, path, state, cached.FileID)
+	return state, cached.FileID, nil
+}
+
+// saveCachedFileID запоминает file_id файла в состоянии state
+func saveCachedFileID(conn *sql.DB, path string, state db.CachedFile, fileID string) {
+	state.FileID = fileID
+	if err := db.SaveCachedFile(conn, path, state); err != nil {
+		log.Println("Ошибка записи кеша файлов:", err)
+	}
+}
+
+// fileHash возвращает SHA-256 содержимого файла: по нему кеш понимает, что файл на диске заменили
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sentFileID достаёт file_id файла из отправленного сообщения
+func sentFileID(msg tgbotapi.Message) string {
+	switch {
+	case len(msg.Photo) > 0:
+		// последний размер - оригинал
+		return msg.Photo[len(msg.Photo)-1].FileID
+	case msg.Document != nil:
+		return msg.Document.FileID
+	case msg.Video != nil:
+		return msg.Video.FileID
+	default:
+		return ""
+	}
 }
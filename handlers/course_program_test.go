@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"app/catalog"
+	"app/db"
+	"app/fakes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestEnv создаёт рабочий каталог с data/, временную базу и бота на фейковом Bot API
+func newTestEnv(t *testing.T) (*fakes.Telegram, *tgbotapi.BotAPI, string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.MkdirAll(filepath.Join("data", "Мария"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := fakes.NewTelegram()
+	t.Cleanup(fake.Close)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("TEST", fake.Endpoint())
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	return fake, bot, dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendCourseProgramCachesFileID(t *testing.T) {
+	const chatID = 7
+	fake, bot, dir := newTestEnv(t)
+	conn, err := db.Open(filepath.Join(dir, "clients.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	program := filepath.Join("data", "Мария", "program.pdf")
+	writeFile(t, program, "версия 1")
+
+	send := func() fakes.TelegramRequest {
+		t.Helper()
+		fake.Reset()
+		if err := SendCourseProgram(bot, conn, chatID, "Мария/program.pdf"); err != nil {
+			t.Fatalf("SendCourseProgram: %v", err)
+		}
+		sent := fake.Sent(chatID)
+		if len(sent) != 1 || sent[0].Method != "sendDocument" {
+			t.Fatalf("sent = %+v", sent)
+		}
+		return sent[0]
+	}
+
+	first := send()
+	if first.Files["document"] != "program.pdf" {
+		t.Fatalf("first send should upload the file: %+v", first)
+	}
+
+	second := send()
+	if len(second.Files) != 0 || second.Params.Get("document") != "file-1" {
+		t.Fatalf("second send should reuse file_id: %+v", second)
+	}
+
+	// новый файл на диске - новая загрузка
+	writeFile(t, program, "версия 2")
+	third := send()
+	if third.Files["document"] != "program.pdf" {
+		t.Fatalf("changed file should be uploaded again: %+v", third)
+	}
+	if fourth := send(); fourth.Params.Get("document") != "file-2" {
+		t.Fatalf("fourth send should reuse new file_id: %+v", fourth)
+	}
+
+	// при том же размере и времени изменения файл не перечитывается: содержимое подменено незаметно для кеша
+	info, err := os.Stat(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, program, "версия 3")
+	if err := os.Chtimes(program, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if fifth := send(); fifth.Params.Get("document") != "file-2" {
+		t.Fatalf("unchanged size and mtime should hit the cache without hashing: %+v", fifth)
+	}
+
+	// файл «потрогали», но содержимое то же - хеш совпал, загрузки нет
+	writeFile(t, program, "версия 2")
+	if sixth := send(); sixth.Files["document"] != "" || sixth.Params.Get("document") != "file-2" {
+		t.Fatalf("touched file with the same content should reuse file_id: %+v", sixth)
+	}
+}
+
+func TestSendCourseProgramReuploadsRejectedFileID(t *testing.T) {
+	const chatID = 7
+	fake, bot, dir := newTestEnv(t)
+	conn, err := db.Open(filepath.Join(dir, "clients.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	program := filepath.Join("data", "Мария", "img.png")
+	writeFile(t, program, "png")
+	if err := SendCourseProgram(bot, conn, chatID, "Мария/img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Reset()
+	fake.Fail("sendPhoto", fakes.TelegramFailure{Code: 400, Description: "Bad Request: wrong file identifier"})
+	if err := SendCourseProgram(bot, conn, chatID, "Мария/img.png"); err != nil {
+		t.Fatalf("SendCourseProgram: %v", err)
+	}
+
+	sent := fake.Sent(chatID)
+	if len(sent) != 2 || sent[1].Files["photo"] != "img.png" {
+		t.Fatalf("sent = %+v", sent)
+	}
+}
+
+func TestSendCourseProgramMediaGroup(t *testing.T) {
+	const chatID = 7
+	fake, bot, dir := newTestEnv(t)
+	conn, err := db.Open(filepath.Join(dir, "clients.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writeFile(t, filepath.Join("data", "Мария", "1.jpg"), "jpg")
+	writeFile(t, filepath.Join("data", "Мария", "2.mp4"), "mp4")
+	writeFile(t, filepath.Join("data", "Мария", "plan.docx"), "docx")
+	writeFile(t, filepath.Join("data", "Мария", "slides.pptx"), "pptx")
+	program := "Мария/1.jpg; Мария/2.mp4; Мария/plan.docx; Мария/slides.pptx"
+
+	if err := SendCourseProgram(bot, conn, chatID, program); err != nil {
+		t.Fatalf("SendCourseProgram: %v", err)
+	}
+	sent := fake.Sent(chatID)
+	if len(sent) != 2 || sent[0].Method != "sendMediaGroup" || sent[1].Method != "sendMediaGroup" {
+		t.Fatalf("sent = %+v", sent)
+	}
+	if len(sent[0].Files) != 2 || len(sent[1].Files) != 2 {
+		t.Fatalf("first send should upload all files: %+v", sent)
+	}
+
+	// повторная отправка - по сохранённым file_id
+	fake.Reset()
+	if err := SendCourseProgram(bot, conn, chatID, program); err != nil {
+		t.Fatalf("SendCourseProgram: %v", err)
+	}
+	for _, req := range fake.Sent(chatID) {
+		if len(req.Files) != 0 || !strings.Contains(req.Params.Get("media"), "file-") {
+			t.Errorf("album should reuse file_id: %+v", req)
+		}
+	}
+
+	// заменили один файл: он загружается, его новый file_id сохраняется, и дальше весь альбом идёт по file_id
+	writeFile(t, filepath.Join("data", "Мария", "2.mp4"), "mp4 v2")
+	fake.Reset()
+	if err := SendCourseProgram(bot, conn, chatID, program); err != nil {
+		t.Fatalf("SendCourseProgram: %v", err)
+	}
+	if sent := fake.Sent(chatID); len(sent[0].Files) != 1 || len(sent[1].Files) != 0 {
+		t.Fatalf("only the changed file should be uploaded: %+v", sent)
+	}
+	fake.Reset()
+	if err := SendCourseProgram(bot, conn, chatID, program); err != nil {
+		t.Fatalf("SendCourseProgram: %v", err)
+	}
+	for _, req := range fake.Sent(chatID) {
+		if len(req.Files) != 0 {
+			t.Errorf("changed file was not cached after partial send: %+v", req)
+		}
+	}
+}
+
+func TestSendCourseProgramTextFiles(t *testing.T) {
+	const chatID = 7
+	fake, bot, _ := newTestEnv(t)
+
+	writeFile(t, filepath.Join("data", "Мария", "program.md"), "# День 1\n- **теория**\n- практика")
+	writeFile(t, filepath.Join("data", "Мария", "program.txt"), "<без разметки>")
+
+	if err := SendCourseProgram(bot, nil, chatID, "Мария/program.md;Мария/program.txt"); err != nil {
+		t.Fatalf("SendCourseProgram: %v", err)
+	}
+	sent := fake.Sent(chatID)
+	if len(sent) != 2 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	if sent[0].Params.Get("parse_mode") != "HTML" || sent[0].Text() != "<b>День 1</b>\n• <b>теория</b>\n• практика" {
+		t.Errorf("markdown = %q (%s)", sent[0].Text(), sent[0].Params.Get("parse_mode"))
+	}
+	if sent[1].Params.Get("parse_mode") != "" || sent[1].Text() != "<без разметки>" {
+		t.Errorf("text = %q", sent[1].Text())
+	}
+}
+
+func TestSendCourseProgramMissingFile(t *testing.T) {
+	const chatID = 7
+	fake, bot, _ := newTestEnv(t)
+	writeFile(t, filepath.Join("data", "Мария", "1.jpg"), "jpg")
+
+	err := SendCourseProgram(bot, nil, chatID, "Мария/1.jpg;Мария/нет.pdf")
+	if !errors.Is(err, ErrProgramFileMissing) {
+		t.Fatalf("err = %v, want ErrProgramFileMissing", err)
+	}
+	if sent := fake.Sent(chatID); len(sent) != 0 {
+		t.Errorf("nothing should be sent for incomplete program: %+v", sent)
+	}
+
+	// обычный текст с точкой с запятой остаётся текстом
+	if err := SendCourseProgram(bot, nil, chatID, "Теория; практика. Итог"); err != nil {
+		t.Fatalf("text program: %v", err)
+	}
+	// расширения, которых бот не отправляет, не делают текст списком файлов
+	for _, program := range []string{"Подробности на example.com", "Мария/program.rar", "Теория; практика.итог"} {
+		if catalog.ProgramFiles(program) != nil {
+			t.Errorf("%q is treated as a file list", program)
+		}
+	}
+}
+
+func TestProgramKindsCoverCatalogExtensions(t *testing.T) {
+	for _, ext := range catalog.ProgramExtensions {
+		if _, ok := programKinds[ext]; !ok {
+			t.Errorf("no way to send %s program files", ext)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Максимальная длина текста сообщения в Telegram
+const telegramMessageLimit = 4096
+
+var (
+	mdHeading    = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	mdListItem   = regexp.MustCompile(`^(\s*)[-*+]\s+`)
+	mdLink       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdBold       = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalicStar = regexp.MustCompile(`\*([^*\s][^*]*)\*`)
+	mdItalicLine = regexp.MustCompile(`(^|[\s(])_([^_\s][^_]*)_($|[\s).,!?:;])`)
+	mdCode       = regexp.MustCompile("`([^`]+)`")
+)
+
+// MarkdownToHTML переводит простой Markdown (заголовки, списки, жирный, курсив, код, ссылки)
+// в HTML-разметку, которую понимает Telegram
+func MarkdownToHTML(md string) string {
+	var out []string
+	inCode := false
+	for _, line := range strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				out = append(out, "</pre>")
+			} else {
+				out = append(out, "<pre>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			out = append(out, html.EscapeString(line))
+			continue
+		}
+
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			out = append(out, "<b>"+markdownInline(m[1])+"</b>")
+			continue
+		}
+		if m := mdListItem.FindStringSubmatch(line); m != nil {
+			line = m[1] + "• " + line[len(m[0]):]
+		}
+		out = append(out, markdownInline(line))
+	}
+	if inCode {
+		out = append(out, "</pre>")
+	}
+
+	result := strings.Join(out, "\n")
+	// в HTML-режиме <pre> с переводами строк вокруг даёт лишние пустые строки
+	result = strings.ReplaceAll(result, "<pre>\n", "<pre>")
+	result = strings.ReplaceAll(result, "\n</pre>", "</pre>")
+	return strings.TrimSpace(result)
+}
+
+// markdownInline обрабатывает разметку внутри строки; код внутри обратных кавычек не трогается
+func markdownInline(line string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range mdCode.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(markdownSpans(line[last:loc[0]]))
+		b.WriteString("<code>" + html.EscapeString(line[loc[2]:loc[3]]) + "</code>")
+		last = loc[1]
+	}
+	b.WriteString(markdownSpans(line[last:]))
+	return b.String()
+}
+
+func markdownSpans(text string) string {
+	text = html.EscapeString(text)
+	text = mdLink.ReplaceAllString(text, `<a href="$2">$1</a>`)
+	text = mdBold.ReplaceAllString(text, "<b>$1$2</b>")
+	text = mdItalicStar.ReplaceAllString(text, "<i>$1</i>")
+	text = mdItalicLine.ReplaceAllString(text, "$1<i>$2</i>$3")
+	return text
+}
+
+// SplitMessage делит длинный текст на части не длиннее лимита Telegram, по возможности по границам строк.
+// Текст с HTML-разметкой делится SplitHTMLMessage
+func SplitMessage(text string) []string {
+	var parts []string
+	for len([]rune(text)) > telegramMessageLimit {
+		runes := []rune(text)
+		cut := telegramMessageLimit
+		if i := strings.LastIndex(string(runes[:cut]), "\n"); i > 0 {
+			cut = len([]rune(string(runes[:cut])[:i]))
+		}
+		parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+		text = strings.TrimSpace(string(runes[cut:]))
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
+
+// SplitHTMLMessage делит текст с HTML-разметкой Telegram так же, как SplitMessage, но не разрезает теги
+// и HTML-сущности: теги, открытые на границе, закрываются в конце части и открываются заново в следующей
+func SplitHTMLMessage(text string) []string {
+	var parts []string
+	var open []string // открывающие теги, которые действуют на границе части
+	for text = strings.TrimSpace(text); text != ""; {
+		prefix := strings.Join(open, "")
+		cut, next := htmlCut(text, open, telegramMessageLimit-utf8.RuneCountInString(prefix))
+		parts = append(parts, prefix+strings.TrimSpace(text[:cut])+closeTags(next))
+		open = next
+		text = strings.TrimSpace(text[cut:])
+	}
+	return parts
+}
+
+// htmlCut ищет, где закончить часть: по возможности перед переводом строки, так, чтобы часть вместе
+// с закрывающими тегами уложилась в limit символов. Возвращает позицию в байтах и теги, открытые на ней
+func htmlCut(text string, open []string, limit int) (int, []string) {
+	stack := open
+	used := 0
+	lineCut, lineStack := 0, []string(nil)
+	for i := 0; i < len(text); {
+		token := htmlToken(text[i:])
+		next := stack
+		switch {
+		case strings.HasPrefix(token, "</"):
+			next = popTag(stack, htmlTagName(token))
+		case strings.HasPrefix(token, "<"):
+			next = append(append([]string(nil), stack...), token)
+		}
+		size := utf8.RuneCountInString(token)
+		if i > 0 && used+size+utf8.RuneCountInString(closeTags(next)) > limit {
+			if lineCut > 0 {
+				return lineCut, lineStack
+			}
+			return i, stack
+		}
+		if token == "\n" {
+			lineCut, lineStack = i, stack
+		}
+		used += size
+		stack = next
+		i += len(token)
+	}
+	return len(text), stack
+}
+
+// htmlToken возвращает тег, HTML-сущность или один символ с начала s
+func htmlToken(s string) string {
+	switch s[0] {
+	case '<':
+		if end := strings.IndexByte(s, '>'); end > 0 {
+			return s[:end+1]
+		}
+	case '&':
+		if end := strings.IndexByte(s, ';'); end > 0 && end <= 10 {
+			return s[:end+1]
+		}
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size]
+}
+
+// htmlTagName - имя тега без атрибутов: `<a href="...">` -> a
+func htmlTagName(tag string) string {
+	name := strings.TrimLeft(strings.TrimSuffix(tag, ">"), "</")
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name)
+}
+
+// popTag убирает из стека последний открытый тег name и всё, что открыто после него
+func popTag(stack []string, name string) []string {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if htmlTagName(stack[i]) == name {
+			return stack[:i]
+		}
+	}
+	return stack
+}
+
+// closeTags закрывает открытые теги в обратном порядке
+func closeTags(stack []string) string {
+	var b strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		b.WriteString("</" + htmlTagName(stack[i]) + ">")
+	}
+	return b.String()
+}
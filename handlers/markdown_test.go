@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		md   string
+		want string
+	}{
+		{"## Программа", "<b>Программа</b>"},
+		{"* пункт\n  - вложенный", "• пункт\n  • вложенный"},
+		{"**жирный** и *курсив* и _тоже_", "<b>жирный</b> и <i>курсив</i> и <i>тоже</i>"},
+		{"файл_с_подчёркиваниями", "файл_с_подчёркиваниями"},
+		{"`a < b` & [сайт](https://example.com)", `<code>a &lt; b</code> &amp; <a href="https://example.com">сайт</a>`},
+		{"```\n**как есть**\n```", "<pre>**как есть**</pre>"},
+	}
+	for _, tt := range tests {
+		if got := MarkdownToHTML(tt.md); got != tt.want {
+			t.Errorf("MarkdownToHTML(%q) = %q, want %q", tt.md, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	line := strings.Repeat("я", 100)
+	text := strings.TrimSpace(strings.Repeat(line+"\n", 50))
+
+	parts := SplitMessage(text)
+	if len(parts) != 2 {
+		t.Fatalf("parts = %d, want 2", len(parts))
+	}
+	for _, p := range parts {
+		if n := len([]rune(p)); n > telegramMessageLimit {
+			t.Errorf("part length = %d", n)
+		}
+		if !strings.HasSuffix(p, line) {
+			t.Errorf("part should end on line boundary")
+		}
+	}
+	if strings.Join(parts, "\n") != text {
+		t.Errorf("parts lost text")
+	}
+}
+
+func TestSplitHTMLMessage(t *testing.T) {
+	// длинный блок кода и ссылка на границе частей
+	code := strings.Repeat("строка кода &amp; ещё\n", 300)
+	text := `<b>Программа</b>` + "\n" + `<pre>` + code + `</pre>` + "\n" + `<a href="https://example.com">` +
+		strings.Repeat("ссылка ", 700) + `</a>`
+
+	parts := SplitHTMLMessage(text)
+	if len(parts) < 3 {
+		t.Fatalf("parts = %d, want at least 3", len(parts))
+	}
+	for i, p := range parts {
+		if n := len([]rune(p)); n > telegramMessageLimit {
+			t.Errorf("part %d length = %d", i, n)
+		}
+		if strings.Count(p, "<pre>") != strings.Count(p, "</pre>") || strings.Count(p, "<a ") != strings.Count(p, "</a>") {
+			t.Errorf("part %d has unbalanced tags: ...%s", i, p[len(p)-40:])
+		}
+		if strings.HasSuffix(strings.TrimSuffix(p, "</pre>"), "&amp") {
+			t.Errorf("part %d cuts an entity", i)
+		}
+	}
+	if !strings.HasPrefix(parts[1], "<pre>") {
+		t.Errorf("code block is not reopened: %.40s", parts[1])
+	}
+	if last := parts[len(parts)-1]; !strings.HasPrefix(last, `<a href="https://example.com">`) {
+		t.Errorf("link is not reopened: %.60s", last)
+	}
+}
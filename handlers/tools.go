@@ -7,6 +7,13 @@ import (
 	"path/filepath"
 )
 
+// Sender - часть Bot API, которой пользуются обработчики. Реализуется *tgbotapi.BotAPI,
+// в тестах - ботом, направленным на фейковый сервер
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
 func ReadTextFile(path string) (string, error) {
 	bytes, err := os.ReadFile(filepath.Clean(path))
 	if err != nil {
@@ -38,7 +45,7 @@ func GetToolsText(speakerDir string) string {
 }
 
 // SendAndLog Отправляет сообщение и логирует ошибку, если она есть
-func SendAndLog(bot *tgbotapi.BotAPI, msg tgbotapi.Chattable) {
+func SendAndLog(bot Sender, msg tgbotapi.Chattable) {
 	if _, err := bot.Send(msg); err != nil {
 		log.Printf("Ошибка отправки сообщения: %v", err)
 	}
@@ -1,17 +1,29 @@
 package main
 
 import (
+	"app/catalog"
 	"fmt"
-	"strconv"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// speakerData - callback кнопки спикера. В кнопке не номер в списке, а catalog.SpeakerID:
+// после перезагрузки каталога старая кнопка не откроет другого спикера
+func speakerData(speakerIdx int) string {
+	return "speaker_" + catalog.SpeakerID(Speakers[speakerIdx].Name)
+}
+
+// courseData - callback кнопки сессии курса с catalog.SessionID
+func courseData(speakerIdx, courseIdx int) string {
+	s := Speakers[speakerIdx]
+	return "course_" + catalog.SessionID(s.Name, s.Courses[courseIdx].City)
+}
+
 func SpeakerKeyboard() tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 	for i, s := range Speakers {
 		label := fmt.Sprintf("🎓 %s ✂️", s.Name)
-		btn := tgbotapi.NewInlineKeyboardButtonData(label, "speaker_"+strconv.Itoa(i))
+		btn := tgbotapi.NewInlineKeyboardButtonData(label, speakerData(i))
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
 	}
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
@@ -19,23 +31,45 @@ func SpeakerKeyboard() tgbotapi.InlineKeyboardMarkup {
 
 func CourseKeyboard(speakerIdx int) tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
+	speakerName := Speakers[speakerIdx].Name
 	for i, c := range Speakers[speakerIdx].Courses {
-		btn := tgbotapi.NewInlineKeyboardButtonData(
-			c.City,
-			"course_"+strconv.Itoa(speakerIdx)+"_"+strconv.Itoa(i),
-		)
+		label := c.City
+		if left, limited := seatsLeft(speakerName, c); limited {
+			if left == 0 && bookingAutoClose() {
+				continue
+			}
+			label = fmt.Sprintf("%s · %s", c.City, seatsLabel(left))
+		}
+		btn := tgbotapi.NewInlineKeyboardButtonData(label, courseData(speakerIdx, i))
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
 	}
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func CourseActionKeyboard() tgbotapi.InlineKeyboardMarkup {
-	return tgbotapi.NewInlineKeyboardMarkup(
+func CourseActionKeyboard(course Course) tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("📝 Оставить заявку", "book_course"),
 			tgbotapi.NewInlineKeyboardButtonData("❓ Как оплатить", "needed_tools"),
 		),
-	)
+	}
+	if depositAvailable(course) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💳 Внести предоплату "+formatPrice(courseDeposit(course)), payDepositData),
+		))
+	}
+	if paymentLinkAvailable(course) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔗 Оплатить предоплату по ссылке", payLinkData),
+		))
+	}
+	// кнопку показываем, только когда промокоды есть, чтобы не искали несуществующий
+	if promoAvailable() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏷 Промокод", promoEnterData),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
 func ContactKeyboard() tgbotapi.ReplyKeyboardMarkup {
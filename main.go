@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"log"
 	"os"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/joho/godotenv"
@@ -19,7 +20,7 @@ func main() {
 		log.Panic(err)
 	}
 
-	err = LoadSpeakersFromCSV("data/courses.csv")
+	err = LoadSpeakers(catalogPath())
 	if err != nil {
 		panic(err)
 	}
@@ -30,16 +31,50 @@ func main() {
 	}
 	defer dbConn.Close()
 
+	startBitrixWebhookServer(bot)
+	startPaymentServer(bot)
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates := bot.GetUpdatesChan(u)
 
-	for update := range updates {
-		if update.Message != nil {
-			HandleMessage(bot, update)
-		}
-		if update.CallbackQuery != nil {
-			HandleCallback(bot, update)
+	// обновления и фоновые задачи обрабатываются в одной горутине, поэтому каталог можно менять без блокировок
+	var reloadTick <-chan time.Time
+	watcher := newCatalogWatcher(catalogPath())
+	if interval := catalogReloadInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		reloadTick = ticker.C
+	}
+
+	schedulerTicker := time.NewTicker(schedulerInterval)
+	defer schedulerTicker.Stop()
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if update.Message != nil {
+				HandleMessage(bot, update)
+			}
+			if update.CallbackQuery != nil {
+				HandleCallback(bot, update)
+			}
+			if update.PreCheckoutQuery != nil {
+				handlePreCheckout(bot, update.PreCheckoutQuery)
+			}
+		case <-reloadTick:
+			if watcher.changed() {
+				_ = reloadCatalog(bot)
+			}
+		case id := <-paymentCompletions:
+			finishPayment(bot, id)
+		case now := <-schedulerTicker.C:
+			sendDueReminders(bot, now)
+			sendDueFollowUps(bot, now)
+			sendScheduledExport(bot, now)
 		}
 	}
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"app/db"
+	"app/fakes"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useTestDB подменяет глобальное подключение к базе временной базой
+func useTestDB(t *testing.T) {
+	t.Helper()
+	conn, err := db.Open(filepath.Join(t.TempDir(), "clients.db"))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	prev := dbConn
+	dbConn = conn
+	t.Cleanup(func() {
+		dbConn = prev
+		conn.Close()
+	})
+}
+
+// useTestCRM подменяет CRM и сбрасывает состояние чатов
+func useTestCRM(t *testing.T, crm CRM) {
+	t.Helper()
+	crmOnce = sync.Once{}
+	crmOnce.Do(func() {
+		crmInst, crmErr = crm, nil
+	})
+	resetChatState()
+	t.Cleanup(func() {
+		crmOnce = sync.Once{}
+		crmInst, crmErr = nil, nil
+		resetChatState()
+	})
+}
+
+func resetChatState() {
+	bitrixSyncMu.Lock()
+	bitrixSyncedItems = make(map[syncedItemKey]string)
+	bitrixSyncMu.Unlock()
+	chatStateMu.Lock()
+	chatStates = make(map[int64]*bitrixSession)
+	chatStateMu.Unlock()
+}
+
+// newTestBitrix запускает фейковый Bitrix24 и клиента к нему без задержек между запросами
+func newTestBitrix(t *testing.T) (*fakes.Bitrix24, *BitrixClient) {
+	t.Helper()
+	fake := fakes.NewBitrix24()
+	t.Cleanup(fake.Close)
+	client := &BitrixClient{
+		baseURL:      fake.URL(),
+		httpClient:   fake.Client(),
+		retryBackoff: time.Millisecond,
+	}
+	return fake, client
+}
+
+// newTestTelegram запускает фейковый Bot API и бота, подключённого к нему
+func newTestTelegram(t *testing.T) (*fakes.Telegram, *tgbotapi.BotAPI) {
+	t.Helper()
+	fake := fakes.NewTelegram()
+	t.Cleanup(fake.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("TEST", fake.Endpoint())
+	if err != nil {
+		t.Fatalf("create bot: %v", err)
+	}
+	return fake, bot
+}
+
+// sentTexts возвращает тексты и подписи всех сообщений, отправленных в чат
+func sentTexts(fake *fakes.Telegram, chatID int64) []string {
+	var texts []string
+	for _, r := range fake.Sent(chatID) {
+		texts = append(texts, r.Text())
+	}
+	return texts
+}
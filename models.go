@@ -1,12 +1,31 @@
 package main
 
-type Course struct {
-	SpeakerIdx int
-	City       string
-	Program    string
+import "app/catalog"
+
+// Course и Speaker описаны в пакете catalog, чтобы каталог могли читать и утилиты из cmd/
+type (
+	Course  = catalog.Course
+	Speaker = catalog.Speaker
+)
+
+// findSpeakerByID возвращает номер спикера с catalog.SpeakerID в текущем каталоге; -1 - спикера больше нет
+func findSpeakerByID(id string) int {
+	for i, s := range Speakers {
+		if catalog.SpeakerID(s.Name) == id {
+			return i
+		}
+	}
+	return -1
 }
 
-type Speaker struct {
-	Name    string
-	Courses []Course
+// findSessionByID возвращает номера спикера и сессии с catalog.SessionID; -1 - сессии больше нет
+func findSessionByID(id string) (speakerIdx, courseIdx int) {
+	for i, s := range Speakers {
+		for j, c := range s.Courses {
+			if catalog.SessionID(s.Name, c.City) == id {
+				return i, j
+			}
+		}
+	}
+	return -1, -1
 }
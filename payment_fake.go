@@ -0,0 +1,153 @@
+package main
+
+import (
+	"app/db"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const fakePaymentPayPath = "/payments/fake/pay"
+
+// FakePaymentProvider - платёжный сервис для локальной проверки оплаты по ссылке. Ссылка ведёт на страницу
+// самого бота: открытие страницы отправляет на /payments/callback подписанное уведомление об успешной оплате.
+// Подпись - HMAC-SHA256 тела с секретом PAYMENT_CALLBACK_SECRET в заголовке X-Signature: sha256=<hex>.
+// В ссылке есть токен, подписанный тем же секретом: без него по последовательному ID чужой платёж не оплатить
+type FakePaymentProvider struct {
+	baseURL    string
+	secret     string
+	httpClient *http.Client
+}
+
+// fakePaymentNotice - тело уведомления фейкового сервиса
+type fakePaymentNotice struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"` // succeeded или canceled
+	Amount   int    `json:"amount"` // в копейках
+	Currency string `json:"currency"`
+}
+
+// newFakePaymentProvider создаёт фейковый сервис: адрес сервера уведомлений для ссылок берётся из
+// PAYMENT_FAKE_BASE_URL (по умолчанию http://localhost<PAYMENT_CALLBACK_ADDR>), секрет - из PAYMENT_CALLBACK_SECRET
+func newFakePaymentProvider() (*FakePaymentProvider, error) {
+	secret := os.Getenv("PAYMENT_CALLBACK_SECRET")
+	if secret == "" {
+		return nil, errors.New("PAYMENT_CALLBACK_SECRET env is empty")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("PAYMENT_FAKE_BASE_URL")), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost" + strings.TrimSpace(os.Getenv("PAYMENT_CALLBACK_ADDR"))
+	}
+	return &FakePaymentProvider{
+		baseURL:    baseURL,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name реализует PaymentProvider
+func (f *FakePaymentProvider) Name() string {
+	return "fake"
+}
+
+// CreatePayment реализует PaymentProvider: возвращает ссылку на страницу оплаты.
+// ID строится из ID платежа в базе, поэтому не повторяется после перезапуска бота, а сумму страница
+// берёт из платежа в базе, поэтому ссылка работает и после перезапуска
+func (f *FakePaymentProvider) CreatePayment(_ context.Context, req PaymentRequest) (PaymentLink, error) {
+	id := fmt.Sprintf("fake-%d", req.PaymentID)
+	query := url.Values{"id": {id}, "token": {f.payToken(id)}}
+	return PaymentLink{ID: id, URL: f.baseURL + fakePaymentPayPath + "?" + query.Encode()}, nil
+}
+
+// payToken - подпись ID платежа для ссылки на страницу оплаты
+func (f *FakePaymentProvider) payToken(id string) string {
+	return signWebhookBody(f.secret, []byte("pay:"+id))
+}
+
+// ParseCallback реализует PaymentProvider: проверяет подпись и разбирает уведомление
+func (f *FakePaymentProvider) ParseCallback(_ context.Context, r *http.Request) (PaymentNotice, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return PaymentNotice{}, err
+	}
+	signature := strings.TrimPrefix(r.Header.Get(crmWebhookSignatureHeader), "sha256=")
+	if !hmac.Equal([]byte(signature), []byte(signWebhookBody(f.secret, body))) {
+		return PaymentNotice{}, fmt.Errorf("%w: bad signature", errPaymentCallbackInvalid)
+	}
+	var notice fakePaymentNotice
+	if err := json.Unmarshal(body, &notice); err != nil || notice.ID == "" {
+		return PaymentNotice{}, fmt.Errorf("%w: bad body", errPaymentCallbackInvalid)
+	}
+	return PaymentNotice{
+		ID:       notice.ID,
+		Paid:     notice.Status == "succeeded",
+		Amount:   notice.Amount,
+		Currency: notice.Currency,
+	}, nil
+}
+
+// ServeHTTP - страница оплаты: проверяет токен ссылки и отправляет боту подписанное уведомление об успешной оплате
+func (f *FakePaymentProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(f.payToken(id))) {
+		log.Printf("payments: fake pay page for %q with bad token from %s", id, r.RemoteAddr)
+		http.Error(w, "неверная ссылка на оплату", http.StatusForbidden)
+		return
+	}
+	payment, err := db.GetPaymentByCharge(dbConn, f.Name(), id)
+	if err != nil {
+		log.Printf("payments: failed to load fake payment %s: %v", id, err)
+		http.Error(w, "не удалось загрузить платёж", http.StatusInternalServerError)
+		return
+	}
+	if payment == nil {
+		http.Error(w, "платёж "+id+" не найден", http.StatusNotFound)
+		return
+	}
+	if payment.Status == db.PaymentPaid {
+		fmt.Fprintf(w, "Платёж %s уже оплачен, вернитесь в Telegram.\n", id)
+		return
+	}
+
+	notice := fakePaymentNotice{ID: id, Status: "succeeded", Amount: payment.Amount, Currency: payment.Currency}
+	if err := f.notify(r.Context(), notice); err != nil {
+		log.Printf("payments: fake callback for %s failed: %v", id, err)
+		http.Error(w, "не удалось отправить уведомление об оплате", http.StatusBadGateway)
+		return
+	}
+	fmt.Fprintf(w, "Платёж %s оплачен, вернитесь в Telegram.\n", id)
+}
+
+// notify отправляет подписанное уведомление на /payments/callback сервера уведомлений
+func (f *FakePaymentProvider) notify(ctx context.Context, notice fakePaymentNotice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.baseURL+paymentCallbackPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(crmWebhookSignatureHeader, "sha256="+signWebhookBody(f.secret, body))
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("callback http %d", resp.StatusCode)
+	}
+	return nil
+}
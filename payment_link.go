@@ -0,0 +1,274 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	payLinkData = "pay_link"
+
+	paymentCallbackPath       = "/payments/callback"
+	paymentServerReadTimeout  = 10 * time.Second
+	paymentServerWriteTimeout = 30 * time.Second
+	paymentLinkTemplate       = "🔗 Ссылка на оплату предоплаты %s за курс «%s»:\n%s\n\nПосле оплаты бот пришлёт подтверждение."
+)
+
+// errPaymentCallbackInvalid - уведомление не прошло проверку подписи или не разобрано
+var errPaymentCallbackInvalid = errors.New("invalid payment callback")
+
+// PaymentProvider - платёжный сервис, который принимает оплату по ссылке (ЮKassa, Тинькофф и т.п.)
+type PaymentProvider interface {
+	// Name - имя сервиса в таблице payments
+	Name() string
+	// CreatePayment создаёт платёж у сервиса и возвращает его ID и ссылку на оплату
+	CreatePayment(ctx context.Context, req PaymentRequest) (PaymentLink, error)
+	// ParseCallback проверяет подлинность уведомления сервиса и возвращает его данные.
+	// Поддельное или неразборчивое уведомление - ошибка errPaymentCallbackInvalid
+	ParseCallback(ctx context.Context, r *http.Request) (PaymentNotice, error)
+}
+
+// PaymentRequest - платёж, который нужно создать у сервиса
+type PaymentRequest struct {
+	PaymentID   int64  // ID в таблице payments
+	Amount      int    // в копейках
+	Currency    string // RUB
+	Description string
+	ReturnURL   string // куда вернуть клиента после оплаты
+}
+
+// PaymentLink - созданный у сервиса платёж
+type PaymentLink struct {
+	ID  string
+	URL string
+}
+
+// PaymentNotice - уведомление сервиса о состоянии платежа
+type PaymentNotice struct {
+	ID       string // ID платежа у сервиса
+	Paid     bool
+	Amount   int // в копейках
+	Currency string
+}
+
+var (
+	// ленивое создание платёжного сервиса по конфигурации
+	paymentProviderOnce sync.Once
+	paymentProviderInst PaymentProvider
+	paymentProviderErr  error
+
+	// сервер уведомлений об оплате запущен: без него оплату по ссылке никто не подтвердит
+	paymentCallbackListening atomic.Bool
+)
+
+// getPaymentProvider возвращает синглтон платёжного сервиса, выбранного переменной окружения
+// PAYMENT_LINK_PROVIDER: yookassa, fake или пусто - оплата по ссылке выключена (nil)
+func getPaymentProvider() (PaymentProvider, error) {
+	paymentProviderOnce.Do(func() {
+		paymentProviderInst, paymentProviderErr = newPaymentProvider(os.Getenv("PAYMENT_LINK_PROVIDER"))
+		if paymentProviderErr != nil {
+			log.Printf("payments: %v", paymentProviderErr)
+		} else if paymentProviderInst != nil {
+			log.Printf("payments: using %T", paymentProviderInst)
+		}
+	})
+	return paymentProviderInst, paymentProviderErr
+}
+
+func newPaymentProvider(provider string) (PaymentProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "", "none":
+		return nil, nil
+	case "yookassa":
+		return newYooKassaProvider()
+	case "fake":
+		return newFakePaymentProvider()
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_LINK_PROVIDER %q", provider)
+	}
+}
+
+// paymentLinkAvailable - показывать ли кнопку оплаты по ссылке для курса
+func paymentLinkAvailable(course Course) bool {
+	provider, err := getPaymentProvider()
+	_, supported := paymentCurrency()
+	return err == nil && provider != nil && paymentCallbackListening.Load() && supported && courseDeposit(course) > 0
+}
+
+// paymentReturnURL - страница, на которую сервис вернёт клиента после оплаты: PAYMENT_RETURN_URL или чат с ботом
+func paymentReturnURL(bot tools.Sender) string {
+	if url := strings.TrimSpace(os.Getenv("PAYMENT_RETURN_URL")); url != "" {
+		return url
+	}
+	return "https://t.me/" + botUsername(bot)
+}
+
+// sendPaymentLink создаёт у платёжного сервиса платёж на предоплату за выбранный курс и присылает ссылку
+func sendPaymentLink(bot tools.Sender, chatID int64) {
+	provider, err := getPaymentProvider()
+	if err != nil {
+		provider = nil
+	}
+	name := ""
+	if provider != nil {
+		name = provider.Name()
+	}
+	payment, session, ok := newDepositPayment(bot, chatID, name, provider != nil && paymentCallbackListening.Load())
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	link, err := provider.CreatePayment(ctx, PaymentRequest{
+		PaymentID:   payment.ID,
+		Amount:      payment.Amount,
+		Currency:    payment.Currency,
+		Description: depositDescription(session),
+		ReturnURL:   paymentReturnURL(bot),
+	})
+	if err == nil {
+		err = db.SetPaymentCharge(dbConn, payment.ID, link.ID)
+	}
+	if err != nil {
+		log.Printf("payments: failed to create %s payment %d for chat %d: %v", name, payment.ID, chatID, err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось создать ссылку на оплату, попробуйте позже."))
+		return
+	}
+
+	title := session.SpeakerName + " — " + session.City
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(paymentLinkTemplate, formatPrice(payment.Amount/100), title, link.URL))
+	msg.DisableWebPagePreview = true
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL("💳 Оплатить "+formatPrice(payment.Amount/100), link.URL),
+	))
+	tools.SendAndLog(bot, msg)
+	log.Printf("payments: %s payment %d (%s) sent to chat %d", name, payment.ID, link.ID, chatID)
+}
+
+// startPaymentServer поднимает HTTP-сервер для уведомлений платёжного сервиса, если выбран сервис
+// и задан PAYMENT_CALLBACK_ADDR. Пока сервер не слушает порт, оплата по ссылке недоступна
+func startPaymentServer(bot tools.Sender) {
+	provider, err := getPaymentProvider()
+	if err != nil || provider == nil {
+		return
+	}
+	addr := strings.TrimSpace(os.Getenv("PAYMENT_CALLBACK_ADDR"))
+	if addr == "" {
+		log.Println("payments: PAYMENT_CALLBACK_ADDR env is empty, payment links are disabled")
+		return
+	}
+
+	mux := http.NewServeMux()
+	registerPaymentCallback(mux, bot)
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  paymentServerReadTimeout,
+		WriteTimeout: paymentServerWriteTimeout,
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("payments: callback server error: %v", err)
+		return
+	}
+	paymentCallbackListening.Store(true)
+
+	go func() {
+		log.Printf("payments: listening on %s%s", addr, paymentCallbackPath)
+		err := server.Serve(listener)
+		paymentCallbackListening.Store(false)
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("payments: callback server error: %v", err)
+		}
+	}()
+}
+
+// registerPaymentCallback добавляет на сервер приём уведомлений платёжного сервиса, если он выбран
+func registerPaymentCallback(mux *http.ServeMux, bot tools.Sender) bool {
+	provider, err := getPaymentProvider()
+	if err != nil || provider == nil {
+		return false
+	}
+	mux.Handle(paymentCallbackPath, &paymentCallbackHandler{bot: bot, provider: provider})
+	// у фейкового сервиса своя страница оплаты
+	if fake, ok := provider.(*FakePaymentProvider); ok {
+		mux.Handle(fakePaymentPayPath, fake)
+	}
+	return true
+}
+
+// paymentCompletions передаёт оплаченные по ссылке предоплаты из HTTP-обработчика в основной цикл
+var paymentCompletions = make(chan int64, 16)
+
+// paymentCallbackHandler принимает уведомления платёжного сервиса и отмечает оплаченные предоплаты
+type paymentCallbackHandler struct {
+	bot      tools.Sender
+	provider PaymentProvider
+}
+
+func (h *paymentCallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	notice, err := h.provider.ParseCallback(ctx, r)
+	switch {
+	case errors.Is(err, errPaymentCallbackInvalid):
+		log.Printf("payments: rejected callback from %s: %v", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	case err != nil:
+		// сервис повторит уведомление
+		log.Printf("payments: callback error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !notice.Paid {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	payment, err := db.GetPaymentByCharge(dbConn, h.provider.Name(), notice.ID)
+	if err != nil {
+		log.Printf("payments: failed to load payment %s: %v", notice.ID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case payment == nil:
+		log.Printf("payments: callback for unknown %s payment %s", h.provider.Name(), notice.ID)
+	case payment.Amount != notice.Amount || payment.Currency != notice.Currency:
+		log.Printf("payments: payment %d amount mismatch: want %d %s, got %d %s",
+			payment.ID, payment.Amount, payment.Currency, notice.Amount, notice.Currency)
+	default:
+		// оплату отмечаем сразу, чтобы при ошибке сервис повторил уведомление,
+		// а сообщение клиенту и CRM - в основном цикле, вместе с остальной работой с сессиями
+		fresh, err := db.MarkPaymentPaid(dbConn, payment.ID, notice.ID, "")
+		if err != nil {
+			log.Printf("payments: failed to mark payment %d paid: %v", payment.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if fresh {
+			paymentCompletions <- payment.ID
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
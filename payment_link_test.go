@@ -0,0 +1,255 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// runPaymentCompletions доводит оплаты, переданные обработчиком уведомлений, как это делает основной цикл
+func runPaymentCompletions(t *testing.T, bot tools.Sender) int {
+	t.Helper()
+	n := 0
+	for {
+		select {
+		case id := <-paymentCompletions:
+			finishPayment(bot, id)
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+// useTestPaymentProvider подменяет платёжный сервис оплаты по ссылке
+func useTestPaymentProvider(t *testing.T, provider PaymentProvider) {
+	t.Helper()
+	paymentProviderOnce = sync.Once{}
+	paymentProviderOnce.Do(func() {
+		paymentProviderInst, paymentProviderErr = provider, nil
+	})
+	paymentCallbackListening.Store(true)
+	t.Cleanup(func() {
+		paymentProviderOnce = sync.Once{}
+		paymentProviderInst, paymentProviderErr = nil, nil
+		paymentCallbackListening.Store(false)
+	})
+}
+
+func TestPaymentLinkNeedsCallbackServer(t *testing.T) {
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+	t.Setenv("PAYMENT_CALLBACK_SECRET", "s3cret")
+	provider, err := newFakePaymentProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestPaymentProvider(t, provider)
+	paymentCallbackListening.Store(false)
+
+	t.Setenv("PAYMENT_CALLBACK_ADDR", "")
+	startPaymentServer(nil)
+	if paymentLinkAvailable(Course{}) {
+		t.Error("payment link available without callback server")
+	}
+	paymentCallbackListening.Store(true)
+	if !paymentLinkAvailable(Course{}) {
+		t.Error("payment link unavailable with callback server")
+	}
+}
+
+func TestFakePaymentIDs(t *testing.T) {
+	t.Setenv("PAYMENT_CALLBACK_SECRET", "s3cret")
+	t.Setenv("PAYMENT_FAKE_BASE_URL", "http://bot.local")
+	// после перезапуска бота новый платёж не получает ID старого
+	for _, paymentID := range []int64{41, 42} {
+		provider, err := newFakePaymentProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		link, err := provider.CreatePayment(context.Background(), PaymentRequest{PaymentID: paymentID, Amount: 100, Currency: "RUB"})
+		if want := fmt.Sprintf("fake-%d", paymentID); err != nil || link.ID != want {
+			t.Errorf("payment %d: id = %q, %v; want %s", paymentID, link.ID, err, want)
+		}
+		if !strings.Contains(link.URL, "token="+provider.payToken(link.ID)) {
+			t.Errorf("payment %d: link without token: %s", paymentID, link.URL)
+		}
+	}
+}
+
+func TestYooKassaPaymentLink(t *testing.T) {
+	const chatID = 5001
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, crm := newTestBitrix(t)
+	useTestCRM(t, crm)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+
+	// ЮKassa: создание платежа и запрос статуса
+	yookassa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "shop" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/payments":
+			var req struct {
+				Amount   yooKassaAmount    `json:"amount"`
+				Metadata map[string]string `json:"metadata"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if r.Header.Get("Idempotence-Key") != "deposit_1" || req.Amount.Value != "5000.00" || req.Metadata["payment_id"] != "1" {
+				t.Errorf("create payment: key %q, body %+v", r.Header.Get("Idempotence-Key"), req)
+			}
+			w.Write([]byte(`{"id":"yk-1","status":"pending","confirmation":{"type":"redirect","confirmation_url":"https://yoomoney.ru/checkout?orderId=yk-1"}}`))
+		case r.URL.Path == "/payments/yk-1":
+			w.Write([]byte(`{"id":"yk-1","status":"succeeded","paid":true,"amount":{"value":"5000.00","currency":"RUB"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(yookassa.Close)
+	t.Setenv("YOOKASSA_SHOP_ID", "shop")
+	t.Setenv("YOOKASSA_SECRET_KEY", "secret")
+	t.Setenv("YOOKASSA_API", yookassa.URL)
+	provider, err := newYooKassaProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestPaymentProvider(t, provider)
+
+	HandleCallback(bot, callbackUpdate(chatID, courseData(0, 0)))
+	sent := tg.Sent(chatID)
+	if !strings.Contains(sent[len(sent)-1].ReplyMarkup(), payLinkData) {
+		t.Fatalf("no payment link button: %+v", sent[len(sent)-1])
+	}
+	HandleCallback(bot, callbackUpdate(chatID, payLinkData))
+	texts := sentTexts(tg, chatID)
+	if !strings.Contains(texts[len(texts)-1], "https://yoomoney.ru/checkout?orderId=yk-1") {
+		t.Fatalf("payment link = %q", texts[len(texts)-1])
+	}
+
+	handler := &paymentCallbackHandler{bot: bot, provider: provider}
+	notify := func(id string) int {
+		t.Helper()
+		body := `{"type":"notification","event":"payment.succeeded","object":{"id":"` + id + `","status":"succeeded"}}`
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, paymentCallbackPath, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	tg.Reset()
+	if code := notify("yk-1"); code != http.StatusOK {
+		t.Fatalf("callback status = %d", code)
+	}
+	if code := notify("yk-1"); code != http.StatusOK {
+		t.Fatalf("repeated callback status = %d", code)
+	}
+	// обработчик только отмечает оплату, клиенту отвечает основной цикл - один раз
+	if texts := sentTexts(tg, chatID); len(texts) != 0 {
+		t.Errorf("replies from callback goroutine = %v", texts)
+	}
+	if n := runPaymentCompletions(t, bot); n != 1 {
+		t.Errorf("completions = %d", n)
+	}
+	texts = sentTexts(tg, chatID)
+	if len(texts) != 3 || !strings.HasPrefix(texts[0], "✅ Предоплата 5 000 ₽ получена") {
+		t.Errorf("replies after payment = %v", texts)
+	}
+	if p, err := db.GetPayment(dbConn, 1); err != nil || p.Status != db.PaymentPaid || p.Provider != "yookassa" {
+		t.Errorf("payment = %+v, %v", p, err)
+	}
+
+	// ЮKassa не знает платёж - уведомление поддельное
+	if code := notify("forged"); code != http.StatusInternalServerError {
+		t.Errorf("forged callback status = %d", code)
+	}
+}
+
+func TestFakePaymentProvider(t *testing.T) {
+	const chatID = 5002
+	useTestDB(t)
+	useDemoCatalog(t)
+	_, crm := newTestBitrix(t)
+	useTestCRM(t, crm)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	t.Setenv("PAYMENT_CALLBACK_SECRET", "s3cret")
+	t.Setenv("PAYMENT_FAKE_BASE_URL", server.URL)
+	provider, err := newFakePaymentProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	useTestPaymentProvider(t, provider)
+	if !registerPaymentCallback(mux, bot) {
+		t.Fatal("payment callback is not registered")
+	}
+
+	HandleCallback(bot, callbackUpdate(chatID, courseData(1, 0)))
+	HandleCallback(bot, callbackUpdate(chatID, payLinkData))
+	payURL := server.URL + fakePaymentPayPath + "?id=fake-1&token=" + provider.payToken("fake-1")
+	if texts := sentTexts(tg, chatID); !strings.Contains(texts[len(texts)-1], payURL) {
+		t.Fatalf("payment link = %q", texts[len(texts)-1])
+	}
+
+	// неподписанное уведомление отклоняется
+	resp, err := http.Post(server.URL+paymentCallbackPath, "application/json",
+		strings.NewReader(`{"id":"fake-1","status":"succeeded","amount":500000,"currency":"RUB"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsigned callback status = %d", resp.StatusCode)
+	}
+	if p, _ := db.GetPayment(dbConn, 1); p.Status != db.PaymentPending {
+		t.Fatalf("payment paid by unsigned callback: %+v", p)
+	}
+
+	// по угаданному ID без токена чужой платёж не оплатить
+	for link, want := range map[string]int{
+		fakePaymentPayPath + "?id=fake-1":                                      http.StatusForbidden,
+		fakePaymentPayPath + "?id=fake-1&token=" + provider.payToken("fake-2"): http.StatusForbidden,
+		fakePaymentPayPath + "?id=fake-2&token=" + provider.payToken("fake-2"): http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", link, resp.StatusCode, want)
+		}
+	}
+	if p, _ := db.GetPayment(dbConn, 1); p.Status != db.PaymentPending {
+		t.Fatalf("payment paid without token: %+v", p)
+	}
+
+	// ссылка не зависит от памяти сервиса и работает после перезапуска бота
+	restarted, err := newFakePaymentProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	restarted.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, payURL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pay page status = %d", rec.Code)
+	}
+	if p, _ := db.GetPayment(dbConn, 1); p.Status != db.PaymentPaid || p.ChargeID != "fake-1" {
+		t.Errorf("payment = %+v", p)
+	}
+	if n := runPaymentCompletions(t, bot); n != 1 {
+		t.Errorf("completions = %d", n)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultYooKassaAPI = "https://api.yookassa.ru/v3"
+
+// YooKassaProvider создаёт платежи через API ЮKassa. ЮKassa не подписывает уведомления,
+// поэтому подлинность уведомления проверяется запросом статуса платежа через API
+type YooKassaProvider struct {
+	baseURL    string
+	shopID     string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// yooKassaAmount - сумма в формате ЮKassa: строка "5000.00"
+type yooKassaAmount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// yooKassaPayment - объект платежа в ответах и уведомлениях ЮKassa
+type yooKassaPayment struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"` // pending, waiting_for_capture, succeeded, canceled
+	Paid         bool           `json:"paid"`
+	Amount       yooKassaAmount `json:"amount"`
+	Confirmation struct {
+		ConfirmationURL string `json:"confirmation_url"`
+	} `json:"confirmation"`
+}
+
+// newYooKassaProvider создаёт клиента ЮKassa по переменным окружения YOOKASSA_SHOP_ID и YOOKASSA_SECRET_KEY
+func newYooKassaProvider() (*YooKassaProvider, error) {
+	shopID := strings.TrimSpace(os.Getenv("YOOKASSA_SHOP_ID"))
+	secretKey := strings.TrimSpace(os.Getenv("YOOKASSA_SECRET_KEY"))
+	if shopID == "" || secretKey == "" {
+		return nil, errors.New("YOOKASSA_SHOP_ID or YOOKASSA_SECRET_KEY env is empty")
+	}
+	baseURL := strings.TrimRight(strings.TrimSpace(os.Getenv("YOOKASSA_API")), "/")
+	if baseURL == "" {
+		baseURL = defaultYooKassaAPI
+	}
+	return &YooKassaProvider{
+		baseURL:   baseURL,
+		shopID:    shopID,
+		secretKey: secretKey,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Name реализует PaymentProvider
+func (y *YooKassaProvider) Name() string {
+	return "yookassa"
+}
+
+// CreatePayment реализует PaymentProvider: платёж с автоматическим списанием и переходом на страницу оплаты
+func (y *YooKassaProvider) CreatePayment(ctx context.Context, req PaymentRequest) (PaymentLink, error) {
+	payload := map[string]any{
+		"amount":  yooKassaAmount{Value: formatMinorUnits(req.Amount), Currency: req.Currency},
+		"capture": true,
+		"confirmation": map[string]any{
+			"type":       "redirect",
+			"return_url": req.ReturnURL,
+		},
+		"description": truncateRunes(req.Description, 128),
+		"metadata":    map[string]any{"payment_id": strconv.FormatInt(req.PaymentID, 10)},
+	}
+
+	var payment yooKassaPayment
+	// ключ идемпотентности не даёт создать второй платёж при повторе запроса
+	idempotenceKey := depositPayloadPrefix + strconv.FormatInt(req.PaymentID, 10)
+	if err := y.do(ctx, http.MethodPost, "/payments", idempotenceKey, payload, &payment); err != nil {
+		return PaymentLink{}, err
+	}
+	if payment.ID == "" || payment.Confirmation.ConfirmationURL == "" {
+		return PaymentLink{}, errors.New("yookassa: empty payment id or confirmation url")
+	}
+	return PaymentLink{ID: payment.ID, URL: payment.Confirmation.ConfirmationURL}, nil
+}
+
+// ParseCallback реализует PaymentProvider: берёт из уведомления ID платежа и запрашивает его статус у ЮKassa
+func (y *YooKassaProvider) ParseCallback(ctx context.Context, r *http.Request) (PaymentNotice, error) {
+	var notification struct {
+		Type   string          `json:"type"`
+		Event  string          `json:"event"`
+		Object yooKassaPayment `json:"object"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&notification); err != nil {
+		return PaymentNotice{}, fmt.Errorf("%w: %v", errPaymentCallbackInvalid, err)
+	}
+	if notification.Type != "notification" || notification.Object.ID == "" {
+		return PaymentNotice{}, fmt.Errorf("%w: not a payment notification", errPaymentCallbackInvalid)
+	}
+
+	var payment yooKassaPayment
+	err := y.do(ctx, http.MethodGet, "/payments/"+url.PathEscape(notification.Object.ID), "", nil, &payment)
+	if err != nil {
+		return PaymentNotice{}, err
+	}
+	if payment.ID != notification.Object.ID {
+		return PaymentNotice{}, fmt.Errorf("%w: payment %s not found", errPaymentCallbackInvalid, notification.Object.ID)
+	}
+	amount, err := parseMinorUnits(payment.Amount.Value)
+	if err != nil {
+		return PaymentNotice{}, fmt.Errorf("yookassa: bad amount %q: %w", payment.Amount.Value, err)
+	}
+	return PaymentNotice{
+		ID:       payment.ID,
+		Paid:     payment.Status == "succeeded" && payment.Paid,
+		Amount:   amount,
+		Currency: payment.Amount.Currency,
+	}, nil
+}
+
+// do выполняет запрос к API ЮKassa с basic-авторизацией shopId:secretKey
+func (y *YooKassaProvider) do(ctx context.Context, method, path, idempotenceKey string, payload, out any) error {
+	var body io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, y.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(y.shopID, y.secretKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+
+	resp, err := y.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		log.Printf("yookassa: http %d response: %s. %s %s", resp.StatusCode, string(respBody), method, path)
+		return fmt.Errorf("yookassa http %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// formatMinorUnits переводит копейки в строку "5000.00"
+func formatMinorUnits(amount int) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
+
+// parseMinorUnits переводит строку "5000.00" в копейки
+func parseMinorUnits(value string) (int, error) {
+	rubles, kopecks, _ := strings.Cut(strings.TrimSpace(value), ".")
+	r, err := strconv.Atoi(rubles)
+	if err != nil {
+		return 0, err
+	}
+	k := 0
+	if kopecks != "" {
+		if len(kopecks) == 1 {
+			kopecks += "0"
+		}
+		if k, err = strconv.Atoi(kopecks); err != nil || len(kopecks) != 2 {
+			return 0, fmt.Errorf("bad kopecks %q", kopecks)
+		}
+	}
+	return r*100 + k, nil
+}
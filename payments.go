@@ -0,0 +1,275 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	payDepositData = "pay_deposit"
+
+	// invoice payload: deposit_<ID платежа>
+	depositPayloadPrefix = "deposit_"
+
+	depositInvoiceTitle    = "Предоплата за курс"
+	depositUnavailableText = "Онлайн-предоплата для этого курса недоступна. Оставьте заявку — менеджер подскажет, как оплатить. 🙌"
+	depositPaidTemplate    = "✅ Предоплата %s получена, место на курсе «%s» за вами!\nМенеджер свяжется с вами, чтобы уточнить детали."
+	depositContactText     = "Чтобы менеджер мог с вами связаться, поделитесь, пожалуйста, номером телефона 👇"
+)
+
+// crmPaymentMarker реализуют CRM, которые умеют отмечать оплату в сделке не только примечанием
+type crmPaymentMarker interface {
+	MarkPaid(ctx context.Context, dealID string) error
+}
+
+// paymentProviderToken - токен платёжного провайдера из BotFather; пустой - онлайн-предоплата выключена
+func paymentProviderToken() string {
+	return strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER_TOKEN"))
+}
+
+// paymentCurrency - валюта счетов из PAYMENT_CURRENCY, по умолчанию RUB. Суммы каталога в рублях и переводятся
+// в копейки, поэтому другие валюты не поддерживаются: ok=false выключает онлайн-оплату
+func paymentCurrency() (currency string, ok bool) {
+	currency = strings.ToUpper(strings.TrimSpace(os.Getenv("PAYMENT_CURRENCY")))
+	if currency == "" || currency == "RUB" {
+		return "RUB", true
+	}
+	return currency, false
+}
+
+// courseDeposit возвращает размер предоплаты за курс в рублях: колонка «Предоплата» каталога,
+// а если она пустая - DEPOSIT_AMOUNT. 0 - предоплата не принимается
+func courseDeposit(course Course) int {
+	if deposit, ok := parsePrice(course.Deposit); ok {
+		return deposit
+	}
+	raw := strings.TrimSpace(os.Getenv("DEPOSIT_AMOUNT"))
+	if raw == "" {
+		return 0
+	}
+	deposit, err := strconv.Atoi(raw)
+	if err != nil || deposit < 0 {
+		log.Printf("payments: invalid DEPOSIT_AMOUNT %q", raw)
+		return 0
+	}
+	return deposit
+}
+
+// depositAvailable - показывать ли кнопку предоплаты для курса
+func depositAvailable(course Course) bool {
+	_, ok := paymentCurrency()
+	return ok && paymentProviderToken() != "" && courseDeposit(course) > 0
+}
+
+// newDepositPayment записывает предоплату за курс, выбранный в диалоге. Если предоплата сейчас невозможна,
+// сообщает клиенту причину и возвращает ok=false
+func newDepositPayment(bot tools.Sender, chatID int64, provider string, enabled bool) (payment db.Payment, session *bitrixSession, ok bool) {
+	session = snapshotSession(chatID)
+	if session == nil || session.City == "" {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "Сначала выберите курс 👇"))
+		return payment, nil, false
+	}
+	course, found := findCourse(session.SpeakerName, session.City)
+	currency, supported := paymentCurrency()
+	if !supported {
+		log.Printf("payments: unsupported PAYMENT_CURRENCY %q, only RUB is supported", currency)
+	}
+	if !found || !enabled || !supported || courseDeposit(course) <= 0 {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, depositUnavailableText))
+		return payment, nil, false
+	}
+	if sessionFull(chatID, session.SpeakerName, course) {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, soldOutMessage))
+		return payment, nil, false
+	}
+
+	payment = db.Payment{
+		ChatID:   chatID,
+		Session:  sessionKey(session.SpeakerName, session.City),
+		Provider: provider,
+		Amount:   courseDeposit(course) * 100,
+		Currency: currency,
+	}
+	id, err := db.CreatePayment(dbConn, payment)
+	if err != nil {
+		log.Printf("payments: failed to create payment for chat %d: %v", chatID, err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось выставить счёт, попробуйте позже."))
+		return payment, nil, false
+	}
+	payment.ID = id
+	return payment, session, true
+}
+
+// depositDescription - назначение платежа для клиента
+func depositDescription(session *bitrixSession) string {
+	return fmt.Sprintf("%s — %s. Предоплата закрепляет место на курсе, остаток оплачивается по договорённости с менеджером.",
+		session.SpeakerName, session.City)
+}
+
+// sendDepositInvoice выставляет счёт Telegram Payments на предоплату за курс, выбранный в диалоге
+func sendDepositInvoice(bot tools.Sender, chatID int64) {
+	payment, session, ok := newDepositPayment(bot, chatID, "telegram", paymentProviderToken() != "")
+	if !ok {
+		return
+	}
+	id := payment.ID
+	invoice := tgbotapi.NewInvoice(chatID, depositInvoiceTitle, truncateRunes(depositDescription(session), 255),
+		depositPayloadPrefix+strconv.FormatInt(id, 10), paymentProviderToken(), "", payment.Currency,
+		[]tgbotapi.LabeledPrice{{Label: "Предоплата", Amount: payment.Amount}})
+	// без пустого списка библиотека отправляет suggested_tip_amounts=null
+	invoice.SuggestedTipAmounts = []int{}
+	tools.SendAndLog(bot, invoice)
+	log.Printf("payments: invoice %d for %s sent to chat %d", id, formatPrice(payment.Amount/100), chatID)
+}
+
+// parseDepositPayload возвращает ID платежа из payload счёта
+func parseDepositPayload(payload string) (int64, bool) {
+	raw, ok := strings.CutPrefix(payload, depositPayloadPrefix)
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	return id, err == nil && id > 0
+}
+
+// handlePreCheckout подтверждает оплату, если счёт ещё актуален. Telegram ждёт ответа не дольше 10 секунд
+func handlePreCheckout(bot tools.Sender, query *tgbotapi.PreCheckoutQuery) {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+	if reason := checkPreCheckout(query); reason != "" {
+		log.Printf("payments: pre-checkout %q from %d rejected: %s", query.InvoicePayload, query.From.ID, reason)
+		answer.OK = false
+		answer.ErrorMessage = reason
+	}
+	if _, err := bot.Request(answer); err != nil {
+		log.Printf("payments: failed to answer pre-checkout %s: %v", query.ID, err)
+	}
+}
+
+// checkPreCheckout возвращает причину отказа для клиента или пустую строку
+func checkPreCheckout(query *tgbotapi.PreCheckoutQuery) string {
+	id, ok := parseDepositPayload(query.InvoicePayload)
+	if !ok {
+		return "Счёт не найден. Выставьте новый в карточке курса."
+	}
+	payment, err := db.GetPayment(dbConn, id)
+	if err != nil {
+		log.Printf("payments: failed to load payment %d: %v", id, err)
+		return "Не удалось проверить счёт, попробуйте ещё раз через минуту."
+	}
+	if payment == nil || payment.ChatID != query.From.ID ||
+		payment.Amount != query.TotalAmount || payment.Currency != query.Currency {
+		return "Счёт не найден. Выставьте новый в карточке курса."
+	}
+	if payment.Status == db.PaymentPaid {
+		return "Этот счёт уже оплачен."
+	}
+	speaker, city := splitSessionKey(payment.Session)
+	if course, ok := findCourse(speaker, city); !ok || sessionFull(payment.ChatID, speaker, course) {
+		return "К сожалению, места на этот курс закончились."
+	}
+	return ""
+}
+
+// handleSuccessfulPayment обрабатывает сообщение об оплате счёта Telegram Payments
+func handleSuccessfulPayment(bot tools.Sender, chatID int64, p *tgbotapi.SuccessfulPayment) {
+	id, ok := parseDepositPayload(p.InvoicePayload)
+	if !ok {
+		log.Printf("payments: unknown payload %q paid by chat %d", p.InvoicePayload, chatID)
+		return
+	}
+	completePayment(bot, id, p.TelegramPaymentChargeID, p.ProviderPaymentChargeID)
+}
+
+// completePayment отмечает платёж оплаченным, благодарит клиента и отмечает оплату в CRM.
+// Повторное уведомление об уже оплаченном платеже ничего не делает
+func completePayment(bot tools.Sender, id int64, chargeID, providerChargeID string) {
+	fresh, err := db.MarkPaymentPaid(dbConn, id, chargeID, providerChargeID)
+	if err != nil {
+		log.Printf("payments: failed to mark payment %d paid: %v", id, err)
+		return
+	}
+	if fresh {
+		finishPayment(bot, id)
+	}
+}
+
+// finishPayment сообщает клиенту об оплате, занимает место и отмечает оплату в CRM.
+// Трогает сессии чата, поэтому вызывается только из основного цикла
+func finishPayment(bot tools.Sender, id int64) {
+	payment, err := db.GetPayment(dbConn, id)
+	if err != nil || payment == nil {
+		log.Printf("payments: failed to load payment %d: %v", id, err)
+		return
+	}
+	chatID := payment.ChatID
+	log.Printf("payments: chat %d paid %d %s for payment %d via %s", chatID, payment.Amount, payment.Currency, id, payment.Provider)
+
+	speaker, city := splitSessionKey(payment.Session)
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID,
+		fmt.Sprintf(depositPaidTemplate, formatPrice(payment.Amount/100), speaker+" — "+city)))
+
+	// оплаченное место занимаем сразу, не дожидаясь заявки и подтверждения в CRM
+	if err := db.HoldBooking(dbConn, chatID, payment.Session); err != nil {
+		log.Printf("payments: failed to hold seat of chat %d on %s: %v", chatID, payment.Session, err)
+	}
+
+	itemID, err := db.GetBookingItem(dbConn, chatID, payment.Session)
+	if err != nil {
+		log.Printf("payments: failed to load booking of chat %d: %v", chatID, err)
+		return
+	}
+	if itemID == "" {
+		// сделки по этой сессии ещё нет - её создаст и отметит оплату syncSession, когда клиент оставит телефон.
+		// Пока клиент платил, он мог открыть другой курс - возвращаем диалог к оплаченному
+		setSessionCourse(chatID, speaker, city)
+		requestContact(bot, chatID, depositContactText)
+		return
+	}
+	crm, err := getCRM()
+	if err != nil {
+		log.Printf("crm: %v", err)
+		return
+	}
+	markPaymentsInCRM(crm, chatID, payment.Session, itemID)
+}
+
+// markPaymentsInCRM отмечает в сделке оплаченные предоплаты сессии, которые ещё не попали в CRM
+func markPaymentsInCRM(crm CRM, chatID int64, session, itemID string) {
+	payments, err := db.GetUnsyncedPayments(dbConn, chatID, session)
+	if err != nil {
+		log.Printf("payments: failed to load payments of chat %d: %v", chatID, err)
+		return
+	}
+	for _, p := range payments {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*bitrixCallTimeout())
+		err := crm.AddNote(ctx, itemID, fmt.Sprintf("💳 Внесена предоплата %s (платёж %s)", formatPrice(p.Amount/100), p.ChargeID))
+		if marker, ok := crm.(crmPaymentMarker); ok && err == nil {
+			err = marker.MarkPaid(ctx, itemID)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("crm: failed to mark payment %d in deal %s: %v", p.ID, itemID, err)
+			continue
+		}
+		if err := db.SetPaymentItem(dbConn, p.ID, itemID); err != nil {
+			log.Printf("payments: failed to save deal of payment %d: %v", p.ID, err)
+		}
+	}
+}
+
+// truncateRunes обрезает строку до n символов
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"app/db"
+	"strconv"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDepositPayment(t *testing.T) {
+	const chatID = 4001
+	useTestDB(t)
+	useDemoCatalog(t)
+	bitrix, crm := newTestBitrix(t)
+	useTestCRM(t, crm)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("PAYMENT_PROVIDER_TOKEN", "TEST:provider")
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+	t.Setenv("B24_PAID_STAGE", "DT1050_:PAID")
+
+	HandleCallback(bot, callbackUpdate(chatID, courseData(0, 0)))
+	sent := tg.Sent(chatID)
+	if !strings.Contains(sent[len(sent)-1].ReplyMarkup(), "Внести предоплату 5 000 ₽") {
+		t.Fatalf("no deposit button: %+v", sent[len(sent)-1])
+	}
+
+	HandleCallback(bot, callbackUpdate(chatID, payDepositData))
+	sent = tg.Sent(chatID)
+	invoice := sent[len(sent)-1]
+	if invoice.Method != "sendInvoice" || invoice.Params.Get("payload") != "deposit_1" ||
+		invoice.Params.Get("currency") != "RUB" || !strings.Contains(invoice.Params.Get("prices"), `"amount":500000`) {
+		t.Fatalf("invoice = %+v", invoice)
+	}
+
+	preCheckout := func(amount int) string {
+		t.Helper()
+		tg.Reset()
+		handlePreCheckout(bot, &tgbotapi.PreCheckoutQuery{
+			ID: "q1", From: &tgbotapi.User{ID: chatID}, Currency: "RUB", TotalAmount: amount, InvoicePayload: "deposit_1",
+		})
+		requests := tg.Requests()
+		if len(requests) != 1 || requests[0].Method != "answerPreCheckoutQuery" {
+			t.Fatalf("pre-checkout requests = %+v", requests)
+		}
+		return requests[0].Params.Get("error_message")
+	}
+	if reason := preCheckout(100); reason == "" {
+		t.Error("wrong amount accepted")
+	}
+	if reason := preCheckout(500000); reason != "" {
+		t.Errorf("valid payment rejected: %s", reason)
+	}
+
+	// оплата до заявки: бот просит телефон, оплата попадает в сделку при её создании
+	paid := messageUpdate(chatID, "")
+	paid.Message.SuccessfulPayment = &tgbotapi.SuccessfulPayment{
+		Currency: "RUB", TotalAmount: 500000, InvoicePayload: "deposit_1",
+		TelegramPaymentChargeID: "tg-charge", ProviderPaymentChargeID: "provider-charge",
+	}
+	HandleMessage(bot, paid)
+	HandleMessage(bot, paid)
+	texts := sentTexts(tg, chatID)
+	if len(texts) != 3 || !strings.HasPrefix(texts[0], "✅ Предоплата 5 000 ₽ получена") || texts[1] != depositContactText ||
+		!strings.HasPrefix(texts[2], "🔒") {
+		t.Fatalf("payment replies = %v", texts)
+	}
+	if p, err := db.GetPayment(dbConn, 1); err != nil || p.Status != db.PaymentPaid || p.ChargeID != "tg-charge" {
+		t.Fatalf("payment = %+v, %v", p, err)
+	}
+
+	HandleCallback(bot, callbackUpdate(chatID, consentAcceptData))
+	HandleMessage(bot, contactUpdate(chatID, "79991234567"))
+	items := bitrix.Items()
+	if len(items) != 1 || items[0].StageID != "DT1050_:PAID" {
+		t.Fatalf("items = %+v", items)
+	}
+	comments := bitrix.Comments()
+	if len(comments) != 2 || !strings.Contains(comments[1].Comment, "💳 Внесена предоплата 5 000 ₽ (платёж tg-charge)") {
+		t.Errorf("comments = %+v", comments)
+	}
+	if p, _ := db.GetPayment(dbConn, 1); p.ItemID == "" {
+		t.Errorf("payment not linked to deal: %+v", p)
+	}
+
+	// повторная проверка оплаченного счёта отклоняется
+	if reason := preCheckout(500000); reason != "Этот счёт уже оплачен." {
+		t.Errorf("paid invoice reason = %q", reason)
+	}
+}
+
+func TestDepositHiddenWithoutToken(t *testing.T) {
+	t.Setenv("PAYMENT_PROVIDER_TOKEN", "")
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+	if depositAvailable(Course{}) {
+		t.Error("deposit available without provider token")
+	}
+	t.Setenv("PAYMENT_PROVIDER_TOKEN", "TEST:provider")
+	t.Setenv("DEPOSIT_AMOUNT", "")
+	if got := courseDeposit(Course{Deposit: "3 000 ₽"}); got != 3000 {
+		t.Errorf("catalog deposit = %d", got)
+	}
+	if depositAvailable(Course{}) {
+		t.Error("deposit available without amount")
+	}
+}
+
+func TestDepositForSecondCourse(t *testing.T) {
+	const chatID, other = 4002, 4003
+	useTestDB(t)
+	useDemoCatalog(t)
+	Speakers[0].Courses[1].Seats = 1
+	bitrix, crm := newTestBitrix(t)
+	useTestCRM(t, crm)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("PAYMENT_PROVIDER_TOKEN", "TEST:provider")
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+	t.Setenv("B24_PAID_STAGE", "DT1050_:PAID")
+	t.Setenv("B24_CONFIRMED_STAGES", "DT1050_:SUCCESS")
+
+	// заявка на первый курс
+	HandleCallback(bot, callbackUpdate(chatID, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(chatID, "book_course"))
+	HandleCallback(bot, callbackUpdate(chatID, consentAcceptData))
+	HandleMessage(bot, contactUpdate(chatID, "79991234567"))
+
+	// предоплата второго курса занимает место сразу
+	HandleCallback(bot, callbackUpdate(chatID, courseData(0, 1)))
+	HandleCallback(bot, callbackUpdate(chatID, payDepositData))
+	paid := messageUpdate(chatID, "")
+	paid.Message.SuccessfulPayment = &tgbotapi.SuccessfulPayment{
+		Currency: "RUB", TotalAmount: 500000, InvoicePayload: "deposit_1", TelegramPaymentChargeID: "tg-charge-2",
+	}
+	HandleCallback(bot, callbackUpdate(chatID, courseData(0, 0))) // клиент листает каталог, пока платит
+	HandleMessage(bot, paid)
+	if left, _ := seatsLeft(Speakers[0].Name, Speakers[0].Courses[1]); left != 0 {
+		t.Errorf("seats left after deposit = %d", left)
+	}
+	if texts := sentTexts(tg, chatID); texts[len(texts)-1] != depositContactText {
+		t.Fatalf("after payment = %q", texts[len(texts)-1])
+	}
+	HandleCallback(bot, callbackUpdate(other, courseData(0, 1)))
+	if texts := sentTexts(tg, other); texts[len(texts)-1] != soldOutMessage {
+		t.Errorf("paid seat is free for another client: %q", texts[len(texts)-1])
+	}
+
+	// место за плательщиком: заявка не упирается в «мест нет», по второму курсу - своя сделка с оплатой
+	HandleMessage(bot, contactUpdate(chatID, "79991234567"))
+	items := bitrix.Items()
+	if len(items) != 2 || items[1].StageID != "DT1050_:PAID" {
+		t.Fatalf("items = %+v", items)
+	}
+	if p, _ := db.GetPayment(dbConn, 1); p.ItemID != strconv.Itoa(items[1].ID) {
+		t.Errorf("payment linked to %q, want %d", p.ItemID, items[1].ID)
+	}
+	if ok, _ := db.HasConfirmedBooking(dbConn, chatID, sessionKey(Speakers[0].Name, Speakers[0].Courses[1].City)); !ok {
+		t.Error("paid booking is not confirmed after sync")
+	}
+}
+
+func TestDepositOnlyInRubles(t *testing.T) {
+	t.Setenv("PAYMENT_PROVIDER_TOKEN", "TEST:provider")
+	t.Setenv("DEPOSIT_AMOUNT", "5000")
+	t.Setenv("PAYMENT_CURRENCY", "usd")
+	if depositAvailable(Course{}) {
+		t.Error("deposit available in USD")
+	}
+	t.Setenv("PAYMENT_CURRENCY", "rub")
+	if !depositAvailable(Course{}) {
+		t.Error("deposit unavailable in RUB")
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Регион по умолчанию для номеров без кода страны
+const defaultPhoneRegion = "RU"
+
+// phoneCountry - правила нумерации страны
+type phoneCountry struct {
+	Region      string   // код ISO 3166-1 alpha-2
+	Name        string   // название для сообщений клиенту
+	Code        string   // телефонный код страны
+	Lengths     []int    // допустимая длина национального номера
+	TrunkPrefix string   // префикс междугородного набора (8 в России), убирается при разборе
+	Prefixes    []string // допустимые первые цифры национального номера, пусто - любые
+	Example     string   // пример номера в формате E.164
+}
+
+// phoneCountries - поддерживаемые страны. Россия и Казахстан делят код +7 и различаются первой цифрой
+var phoneCountries = []phoneCountry{
+	{Region: "RU", Name: "Россия", Code: "7", Lengths: []int{10}, TrunkPrefix: "8", Prefixes: []string{"3", "4", "8", "9"}, Example: "+79991234567"},
+	{Region: "KZ", Name: "Казахстан", Code: "7", Lengths: []int{10}, TrunkPrefix: "8", Prefixes: []string{"6", "7"}, Example: "+77011234567"},
+	{Region: "BY", Name: "Беларусь", Code: "375", Lengths: []int{9}, TrunkPrefix: "80", Example: "+375291234567"},
+	{Region: "UA", Name: "Украина", Code: "380", Lengths: []int{9}, TrunkPrefix: "0", Example: "+380501234567"},
+	{Region: "LV", Name: "Латвия", Code: "371", Lengths: []int{8}, Prefixes: []string{"2", "6", "8", "9"}, Example: "+37121234567"},
+	{Region: "LT", Name: "Литва", Code: "370", Lengths: []int{8}, TrunkPrefix: "8", Example: "+37061234567"},
+	{Region: "EE", Name: "Эстония", Code: "372", Lengths: []int{7, 8}, Example: "+3725123456"},
+	{Region: "UZ", Name: "Узбекистан", Code: "998", Lengths: []int{9}, Example: "+998901234567"},
+	{Region: "KG", Name: "Киргизия", Code: "996", Lengths: []int{9}, TrunkPrefix: "0", Example: "+996555123456"},
+	{Region: "AM", Name: "Армения", Code: "374", Lengths: []int{8}, TrunkPrefix: "0", Example: "+37491123456"},
+	{Region: "GE", Name: "Грузия", Code: "995", Lengths: []int{9}, TrunkPrefix: "0", Example: "+995555123456"},
+	{Region: "AZ", Name: "Азербайджан", Code: "994", Lengths: []int{9}, TrunkPrefix: "0", Example: "+994501234567"},
+}
+
+// Ограничения E.164 для стран, которых нет в таблице
+const (
+	minE164Digits = 8
+	maxE164Digits = 15
+)
+
+// phoneError - понятное клиенту описание, что не так с номером
+type phoneError string
+
+func (e phoneError) Error() string {
+	return string(e)
+}
+
+// normalizePhone приводит номер к формату E.164 с учётом региона по умолчанию (PHONE_DEFAULT_REGION)
+func normalizePhone(raw string) (string, error) {
+	return parsePhone(raw, phoneDefaultRegion())
+}
+
+// phoneDefaultRegion возвращает регион для номеров без кода страны
+func phoneDefaultRegion() string {
+	region := strings.ToUpper(strings.TrimSpace(os.Getenv("PHONE_DEFAULT_REGION")))
+	if findPhoneRegion(region) == nil {
+		return defaultPhoneRegion
+	}
+	return region
+}
+
+// phoneExample возвращает пример номера для региона по умолчанию
+func phoneExample() string {
+	return findPhoneRegion(phoneDefaultRegion()).Example
+}
+
+// parsePhone разбирает номер: с "+" или "00" - как международный, иначе сначала как национальный номер
+// региона по умолчанию, затем как международный без "+" с кодом страны из таблицы. Номер без "+"
+// с неизвестным кодом страны не принимается: это скорее опечатка в национальном номере, чем другая страна
+func parsePhone(raw, defaultRegion string) (string, error) {
+	digits, international := phoneDigits(raw)
+	if digits == "" {
+		return "", phoneError("в номере нет цифр")
+	}
+	if international {
+		return parseInternationalPhone(digits)
+	}
+
+	country := findPhoneRegion(defaultRegion)
+	if country != nil {
+		if national, ok := country.national(digits); ok {
+			return "+" + country.Code + national, nil
+		}
+	}
+
+	if knownPhoneCode(digits) {
+		if e164, err := parseInternationalPhone(digits); err == nil {
+			return e164, nil
+		}
+	}
+
+	if country != nil && len(digits) <= maxNationalLength(country) {
+		return "", phoneError(fmt.Sprintf(
+			"для страны «%s» нужно %s цифр без кода страны", country.Name, describeLengths(country.Lengths),
+		))
+	}
+	return "", phoneError("не удалось определить код страны, укажите номер в международном формате с «+»")
+}
+
+// knownPhoneCode сообщает, начинается ли номер с кода страны из таблицы
+func knownPhoneCode(digits string) bool {
+	for _, c := range phoneCountries {
+		if strings.HasPrefix(digits, c.Code) {
+			return true
+		}
+	}
+	return false
+}
+
+// contactPhone возвращает номер из контакта Telegram. Telegram присылает его с кодом страны,
+// но иногда без "+", а без "+" номер с неизвестным кодом страны не разбирается
+func contactPhone(contact *tgbotapi.Contact) string {
+	phone := strings.TrimSpace(contact.PhoneNumber)
+	if phone != "" && !strings.HasPrefix(phone, "+") {
+		return "+" + phone
+	}
+	return phone
+}
+
+// parseInternationalPhone разбирает номер, начинающийся с кода страны
+func parseInternationalPhone(digits string) (string, error) {
+	// страна для сообщения об ошибке: совпал код, а лучше ещё и первая цифра номера
+	var matched *phoneCountry
+	for i := range phoneCountries {
+		c := &phoneCountries[i]
+		if !strings.HasPrefix(digits, c.Code) {
+			continue
+		}
+		national := digits[len(c.Code):]
+		if c.valid(national) {
+			return "+" + digits, nil
+		}
+		if matched == nil || c.hasPrefix(national) && !matched.hasPrefix(digits[len(matched.Code):]) {
+			matched = c
+		}
+	}
+
+	if matched != nil {
+		return "", phoneError(fmt.Sprintf(
+			"для страны «%s» (+%s) нужно %s цифр после кода страны",
+			matched.Name, matched.Code, describeLengths(matched.Lengths),
+		))
+	}
+	if len(digits) < minE164Digits {
+		return "", phoneError("номер слишком короткий")
+	}
+	if len(digits) > maxE164Digits {
+		return "", phoneError("номер слишком длинный")
+	}
+	return "+" + digits, nil
+}
+
+// phoneDigits оставляет только цифры и сообщает, указан ли номер в международном формате
+func phoneDigits(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+
+	var b strings.Builder
+	for _, r := range raw {
+		if unicode.IsDigit(r) && r < unicode.MaxASCII {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if !international && strings.HasPrefix(digits, "00") {
+		return digits[2:], true
+	}
+	return digits, international
+}
+
+// national возвращает национальный номер без префикса междугородного набора, если номер подходит стране
+func (c *phoneCountry) national(digits string) (string, bool) {
+	if c.TrunkPrefix != "" && strings.HasPrefix(digits, c.TrunkPrefix) {
+		if trimmed := digits[len(c.TrunkPrefix):]; c.valid(trimmed) {
+			return trimmed, true
+		}
+	}
+	if c.valid(digits) {
+		return digits, true
+	}
+	return "", false
+}
+
+func (c *phoneCountry) valid(national string) bool {
+	if !c.hasPrefix(national) {
+		return false
+	}
+	for _, l := range c.Lengths {
+		if len(national) == l {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *phoneCountry) hasPrefix(national string) bool {
+	if len(c.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range c.Prefixes {
+		if strings.HasPrefix(national, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func findPhoneRegion(region string) *phoneCountry {
+	for i := range phoneCountries {
+		if phoneCountries[i].Region == region {
+			return &phoneCountries[i]
+		}
+	}
+	return nil
+}
+
+func maxNationalLength(c *phoneCountry) int {
+	max := 0
+	for _, l := range c.Lengths {
+		if l > max {
+			max = l
+		}
+	}
+	return max + len(c.TrunkPrefix)
+}
+
+func describeLengths(lengths []int) string {
+	parts := make([]string, len(lengths))
+	for i, l := range lengths {
+		parts[i] = fmt.Sprint(l)
+	}
+	return strings.Join(parts, " или ")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParsePhone(t *testing.T) {
+	tests := []struct {
+		raw     string
+		region  string
+		want    string
+		wantErr string
+	}{
+		{raw: "+7 (999) 123-45-67", region: "RU", want: "+79991234567"},
+		{raw: "89991234567", region: "RU", want: "+79991234567"},
+		{raw: "9991234567", region: "RU", want: "+79991234567"},
+		{raw: "79991234567", region: "RU", want: "+79991234567"},
+		{raw: "8 701 123 45 67", region: "KZ", want: "+77011234567"},
+		{raw: "77011234567", region: "RU", want: "+77011234567"},
+		{raw: "8 029 123-45-67", region: "BY", want: "+375291234567"},
+		{raw: "375291234567", region: "RU", want: "+375291234567"},
+		{raw: "21234567", region: "LV", want: "+37121234567"},
+		{raw: "+371 2123 4567", region: "RU", want: "+37121234567"},
+		{raw: "0037121234567", region: "RU", want: "+37121234567"},
+		{raw: "+49 151 12345678", region: "RU", want: "+4915112345678"},
+		{raw: "4915112345678", region: "RU", wantErr: "с «+»"},
+		{raw: "89991234567", region: "LV", wantErr: "с «+»"},
+		{raw: "79991234567", region: "LV", want: "+79991234567"},
+		{raw: "12345", region: "RU", wantErr: "«Россия» нужно 10 цифр"},
+		{raw: "+371 2123 456", region: "RU", wantErr: "«Латвия» (+371) нужно 8 цифр"},
+		{raw: "+7 999 123 45", region: "LV", wantErr: "«Россия» (+7) нужно 10 цифр"},
+		{raw: "+1234", region: "RU", wantErr: "слишком короткий"},
+		{raw: "телефон", region: "RU", wantErr: "нет цифр"},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePhone(tt.raw, tt.region)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parsePhone(%q, %s) = %q, %v; want error %q", tt.raw, tt.region, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parsePhone(%q, %s) = %q, %v; want %q", tt.raw, tt.region, got, err, tt.want)
+		}
+	}
+}
+
+func TestContactPhone(t *testing.T) {
+	for raw, want := range map[string]string{
+		"4915112345678": "+4915112345678",
+		"+79991234567":  "+79991234567",
+		" 79991234567 ": "+79991234567",
+	} {
+		if got := contactPhone(&tgbotapi.Contact{PhoneNumber: raw}); got != want {
+			t.Errorf("contactPhone(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestNormalizePhoneDefaultRegion(t *testing.T) {
+	t.Setenv("PHONE_DEFAULT_REGION", "lv")
+	if got, err := normalizePhone("2123 4567"); err != nil || got != "+37121234567" {
+		t.Errorf("normalizePhone = %q, %v", got, err)
+	}
+
+	t.Setenv("PHONE_DEFAULT_REGION", "XX")
+	if got, err := normalizePhone("8 999 123-45-67"); err != nil || got != "+79991234567" {
+		t.Errorf("normalizePhone with unknown region = %q, %v", got, err)
+	}
+}
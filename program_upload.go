@@ -0,0 +1,329 @@
+package main
+
+import (
+	"app/catalog"
+	tools "app/handlers"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// Bot API отдаёт ботам файлы не больше 20 МБ
+	uploadMaxSize = 20 << 20
+	// предыдущие версии программ, относительно data/
+	programArchiveDir = "archive"
+
+	uploadSpeakerPrompt = "📎 Файл получен. Для какого спикера это программа?"
+	uploadCoursePrompt  = "Выберите курс — программа будет заменена этим файлом:"
+	uploadCancelled     = "Загрузка отменена."
+	uploadExpired       = "Файл не найден — пришлите его ещё раз."
+	uploadUnsupported   = "Такой формат бот не умеет отправлять. Подойдут pdf, docx, pptx, jpg, png, mp4, md и txt."
+	uploadTooLarge      = "Файл больше 20 МБ — Telegram не даст боту его скачать. Сожмите файл или положите его в data/ вручную."
+)
+
+// programUpload - файл программы, присланный администратором, пока он выбирает курс
+type programUpload struct {
+	fileID  string
+	name    string
+	speaker string
+}
+
+// обрабатывается в основной горутине вместе с остальными обновлениями
+var programUploads = make(map[int64]*programUpload)
+
+// downloadTelegramFile скачивает файл, присланный боту; в тестах подменяется
+var downloadTelegramFile = func(bot tools.Sender, fileID string) ([]byte, error) {
+	api, ok := bot.(interface {
+		GetFileDirectURL(fileID string) (string, error)
+	})
+	if !ok {
+		return nil, errors.New("bot can't download files")
+	}
+	url, err := api.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download: http %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, uploadMaxSize+1))
+}
+
+// handleProgramUpload принимает от администратора документ или фото и предлагает выбрать спикера.
+// Возвращает false, если в сообщении нет файла
+func handleProgramUpload(bot tools.Sender, message *tgbotapi.Message) bool {
+	chatID := message.Chat.ID
+
+	var upload programUpload
+	switch {
+	case message.Document != nil:
+		if message.Document.FileSize > uploadMaxSize {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, uploadTooLarge))
+			return true
+		}
+		upload = programUpload{fileID: message.Document.FileID, name: uploadFileName(message.Document.FileName)}
+	case len(message.Photo) > 0:
+		photo := message.Photo[len(message.Photo)-1]
+		upload = programUpload{fileID: photo.FileID, name: "photo-" + time.Now().Format("20060102-150405") + ".jpg"}
+	default:
+		return false
+	}
+	if upload.name == "" || !catalog.ProgramFileSupported(upload.name) {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, uploadUnsupported))
+		return true
+	}
+	programUploads[chatID] = &upload
+
+	file, err := catalog.Read(catalogPath())
+	if err != nil {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf(adminCatalogError, err)))
+		return true
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, s := range file.Speakers {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(s.Name, "upload_speaker_"+catalog.SpeakerID(s.Name)),
+		))
+	}
+	rows = append(rows, uploadCancelRow())
+
+	msg := tgbotapi.NewMessage(chatID, uploadSpeakerPrompt)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	tools.SendAndLog(bot, msg)
+	return true
+}
+
+// handleUploadCallback обрабатывает выбор спикера и курса для загруженного файла
+func handleUploadCallback(bot tools.Sender, chatID int64, data string) {
+	if data == "upload_cancel" {
+		delete(programUploads, chatID)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, uploadCancelled))
+		return
+	}
+	upload := programUploads[chatID]
+	if upload == nil {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, uploadExpired))
+		return
+	}
+
+	file, err := catalog.Read(catalogPath())
+	if err != nil {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf(adminCatalogError, err)))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(data, "upload_speaker_"):
+		id := strings.TrimPrefix(data, "upload_speaker_")
+		idx := -1
+		for i, s := range file.Speakers {
+			if catalog.SpeakerID(s.Name) == id {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, staleCatalogText))
+			return
+		}
+		speaker := file.Speakers[idx]
+		upload.speaker = speaker.Name
+
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for i, c := range speaker.Courses {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(uploadCourseLabel(i, c), "upload_course_"+catalog.CourseID(speaker.Name, c)),
+			))
+		}
+		rows = append(rows, uploadCancelRow())
+		msg := tgbotapi.NewMessage(chatID, uploadCoursePrompt)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+		tools.SendAndLog(bot, msg)
+
+	case strings.HasPrefix(data, "upload_course_"):
+		course, idx, ok := findCourseEntry(file, upload.speaker, strings.TrimPrefix(data, "upload_course_"))
+		if !ok {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, staleCatalogText))
+			return
+		}
+		ref, archived, err := saveProgramUpload(bot, upload, course, idx)
+		if err != nil {
+			log.Printf("upload: failed to save program for %s: %v", upload.speaker, err)
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "❌ Не удалось обновить программу: "+err.Error()))
+			return
+		}
+		delete(programUploads, chatID)
+
+		text := fmt.Sprintf("✅ Программа курса обновлена: %s", ref)
+		if len(archived) > 0 {
+			text += "\nПредыдущая версия сохранена в data/" + strings.Join(archived, ", data/")
+		}
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, text))
+	}
+}
+
+// saveProgramUpload скачивает файл в папку спикера, копирует прежнюю программу в архив,
+// прописывает файл в каталоге и перечитывает его. Если каталог не удалось обновить или перечитать,
+// файл программы и каталог возвращаются в прежнее состояние. Возвращает путь файла и архивных копий относительно data/
+func saveProgramUpload(bot tools.Sender, upload *programUpload, course catalog.CourseEntry, idx int) (string, []string, error) {
+	content, err := downloadTelegramFile(bot, upload.fileID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(content) > uploadMaxSize {
+		return "", nil, errors.New("файл больше 20 МБ")
+	}
+	if len(content) == 0 {
+		return "", nil, errors.New("файл пустой")
+	}
+
+	// файл кладём рядом с прежней программой, а если её не было - в папку с именем спикера
+	dir := strings.TrimSpace(upload.speaker)
+	if len(course.Files) > 0 {
+		if d := path.Dir(course.Files[0]); d != "." {
+			dir = d
+		}
+	}
+	ref := path.Join(dir, upload.name)
+
+	stamp := time.Now().Format("20060102-150405")
+	var archived []string
+	for _, old := range append(append([]string(nil), course.Files...), ref) {
+		dst := path.Join(programArchiveDir, stamp, old)
+		if contains(archived, dst) {
+			continue
+		}
+		ok, err := copyFile(filepath.Join("data", filepath.FromSlash(old)), filepath.Join("data", filepath.FromSlash(dst)))
+		if err != nil {
+			return "", nil, fmt.Errorf("archive %s: %w", old, err)
+		}
+		if ok {
+			archived = append(archived, dst)
+		}
+	}
+
+	// прежнее состояние для отката
+	prevCatalog, err := os.ReadFile(catalogPath())
+	if err != nil {
+		return "", nil, err
+	}
+	target := filepath.Join("data", filepath.FromSlash(ref))
+	prevProgram, err := os.ReadFile(target)
+	existed := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, err
+	}
+	restoreProgram := func() {
+		err := os.Remove(target)
+		if existed {
+			err = catalog.WriteFileAtomic(target, prevProgram)
+		}
+		if err != nil {
+			log.Printf("upload: failed to restore %s: %v", target, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", nil, err
+	}
+	if err := catalog.WriteFileAtomic(target, content); err != nil {
+		return "", nil, err
+	}
+	if err := catalog.SetProgramFiles(catalogPath(), upload.speaker, idx, []string{ref}); err != nil {
+		restoreProgram()
+		return "", nil, err
+	}
+	if err := reloadCatalog(bot); err != nil {
+		restoreProgram()
+		if err := catalog.WriteFileAtomic(catalogPath(), prevCatalog); err != nil {
+			log.Printf("upload: failed to restore catalog: %v", err)
+		}
+		return "", nil, err
+	}
+	log.Printf("upload: program of %s, course #%d replaced with %s", upload.speaker, idx+1, ref)
+	return ref, archived, nil
+}
+
+// copyFile копирует src в dst; false - src не существует
+func copyFile(src, dst string) (bool, error) {
+	data, err := os.ReadFile(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(dst, data, 0o644)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// findCourseEntry ищет курс спикера по catalog.CourseID и возвращает его номер для catalog.SetProgramFiles
+func findCourseEntry(file *catalog.File, speaker, id string) (catalog.CourseEntry, int, bool) {
+	for _, s := range file.Speakers {
+		if s.Name != speaker {
+			continue
+		}
+		for i, c := range s.Courses {
+			if catalog.CourseID(s.Name, c) == id {
+				return c, i, true
+			}
+		}
+	}
+	return catalog.CourseEntry{}, -1, false
+}
+
+// uploadCourseLabel - подпись курса: название или номер и города
+func uploadCourseLabel(i int, c catalog.CourseEntry) string {
+	title := c.Title
+	if title == "" {
+		title = fmt.Sprintf("Курс %d", i+1)
+	}
+	var cities []string
+	for _, s := range c.Sessions {
+		if !contains(cities, s.City) {
+			cities = append(cities, s.City)
+		}
+	}
+	return title + " · " + strings.Join(cities, ", ")
+}
+
+func uploadCancelRow() []tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отменить", "upload_cancel"))
+}
+
+// uploadFileName оставляет от имени файла только имя без каталогов
+func uploadFileName(name string) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, "\\", "/"))
+	name = path.Base(name)
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
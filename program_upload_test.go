@@ -0,0 +1,128 @@
+package main
+
+import (
+	"app/catalog"
+	"app/handlers"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func documentUpdate(chatID int64, fileID, name string) tgbotapi.Update {
+	update := messageUpdate(chatID, "")
+	update.Message.Document = &tgbotapi.Document{FileID: fileID, FileName: name, FileSize: 3}
+	return update
+}
+
+func TestProgramUpload(t *testing.T) {
+	const admin = 900
+	t.Chdir(t.TempDir())
+	useTestDB(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+
+	prevDownload := downloadTelegramFile
+	downloadTelegramFile = func(_ handlers.Sender, fileID string) ([]byte, error) {
+		return []byte("new program " + fileID), nil
+	}
+	prevSpeakers := Speakers
+	t.Cleanup(func() {
+		downloadTelegramFile = prevDownload
+		Speakers = prevSpeakers
+		programUploads = make(map[int64]*programUpload)
+	})
+
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("data/Мария/program.pdf", "old program")
+	writeCatalog(t, "data/courses.yaml", `speakers:
+  - name: Мария
+    courses:
+      - title: Брови
+        files: [Мария/program.pdf]
+        sessions:
+          - city: Казань
+            date: 15 июля
+      - title: Ресницы
+        program: Расскажем на месте
+        sessions:
+          - city: Москва
+`)
+	if err := LoadSpeakers(catalogPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := catalog.Read(catalogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	speakerData := "upload_speaker_" + catalog.SpeakerID("Мария")
+	browsData := "upload_course_" + catalog.CourseID("Мария", file.Speakers[0].Courses[0])
+	lashesData := "upload_course_" + catalog.CourseID("Мария", file.Speakers[0].Courses[1])
+
+	HandleMessage(bot, documentUpdate(admin, "doc1", "program.pdf"))
+	HandleCallback(bot, callbackUpdate(admin, speakerData))
+	sent := tg.Sent(admin)
+	if len(sent) != 2 || !strings.Contains(sent[1].ReplyMarkup(), "Брови · Казань") || !strings.Contains(sent[1].ReplyMarkup(), browsData) {
+		t.Fatalf("upload menus = %v", sentTexts(tg, admin))
+	}
+	HandleCallback(bot, callbackUpdate(admin, browsData))
+
+	got, err := os.ReadFile("data/Мария/program.pdf")
+	if err != nil || string(got) != "new program doc1" {
+		t.Errorf("program file = %q, %v", got, err)
+	}
+	archived, _ := filepath.Glob("data/archive/*/Мария/program.pdf")
+	if len(archived) != 1 {
+		t.Fatalf("archived = %v", archived)
+	}
+	if old, _ := os.ReadFile(archived[0]); string(old) != "old program" {
+		t.Errorf("archived content = %q", old)
+	}
+	texts := sentTexts(tg, admin)
+	if !strings.Contains(texts[len(texts)-1], "Программа курса обновлена: Мария/program.pdf") {
+		t.Errorf("reply = %q", texts[len(texts)-1])
+	}
+
+	// курс с текстовой программой получает файл в папке спикера
+	HandleMessage(bot, documentUpdate(admin, "doc2", "lashes.pdf"))
+	HandleCallback(bot, callbackUpdate(admin, speakerData))
+	HandleCallback(bot, callbackUpdate(admin, lashesData))
+	if Speakers[0].Courses[1].Program != "Мария/lashes.pdf" {
+		t.Errorf("reloaded program = %q", Speakers[0].Courses[1].Program)
+	}
+
+	// кнопка курса, которого больше нет в каталоге, ничего не меняет
+	HandleMessage(bot, documentUpdate(admin, "doc4", "brows.pdf"))
+	HandleCallback(bot, callbackUpdate(admin, speakerData))
+	before, _ := os.ReadFile(catalogPath())
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(admin, "upload_course_"+catalog.CourseID("Мария", catalog.CourseEntry{Title: "Удалённый"})))
+	if got := sentTexts(tg, admin); len(got) != 1 || got[0] != staleCatalogText {
+		t.Errorf("stale course reply = %v", got)
+	}
+	if after, _ := os.ReadFile(catalogPath()); string(after) != string(before) {
+		t.Errorf("catalog changed by stale button")
+	}
+	if _, err := os.Stat("data/Мария/brows.pdf"); !os.IsNotExist(err) {
+		t.Errorf("program file written for stale button: %v", err)
+	}
+
+	tg.Reset()
+	HandleMessage(bot, documentUpdate(admin, "doc3", "archive.zip"))
+	if got := sentTexts(tg, admin); len(got) != 1 || got[0] != uploadUnsupported {
+		t.Errorf("unsupported upload reply = %v", got)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	promoEnterData = "promo_enter"
+
+	promoPrompt    = "🏷 Отправьте промокод сообщением"
+	promoRetryHint = "\nЧтобы ввести другой код, снова нажмите «🏷 Промокод»."
+	promoAdminHelp = "Промокоды:\n" +
+		"/promo — список\n" +
+		"/promo add КОД 10% [from=2024-05-01] [to=2024-05-31] [limit=50] [speaker=Мария Петрова] [city=Казань]\n" +
+		"/promo add КОД 3000 — скидка 3 000 ₽\n" +
+		"/promo del КОД"
+)
+
+// promoCodePattern - формат промокода: латинские буквы и цифры, внутри _ и -, от 3 до 32 символов.
+// Обычные слова кириллицей и фразы с пробелами под него не подходят
+var promoCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{1,30}[A-Za-z0-9]$`)
+
+// чаты, от которых ждём промокод после кнопки «Промокод»; обрабатывается в основной горутине
+var promoAwaiting = make(map[int64]bool)
+
+// promoOptions - ключи условий в /promo add
+var promoOptions = []string{"from", "to", "limit", "speaker", "city"}
+
+// parsePromoArgs разбирает аргументы /promo add: код, скидку и условия key=value.
+// Значение условия тянется до следующего ключа, поэтому имя спикера может быть из нескольких слов
+func parsePromoArgs(args string) (db.PromoCode, error) {
+	var p db.PromoCode
+	tokens := strings.Fields(args)
+	if len(tokens) < 2 {
+		return p, errors.New("укажите код и скидку")
+	}
+	p.Code = tokens[0]
+	if !promoCodePattern.MatchString(p.Code) {
+		return p, fmt.Errorf("код «%s»: только латинские буквы, цифры, _ и -, от 3 до 32 символов", p.Code)
+	}
+
+	amount := strings.TrimSuffix(strings.TrimSuffix(tokens[1], "₽"), "р")
+	p.Percent = strings.HasSuffix(amount, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(amount, "%"))
+	if err != nil || n <= 0 || p.Percent && n > 100 {
+		return p, fmt.Errorf("скидка «%s»: нужен процент от 1%% до 100%% или сумма в рублях", tokens[1])
+	}
+	p.Amount = n
+
+	options := make(map[string][]string)
+	key := ""
+	for _, token := range tokens[2:] {
+		if k, v, ok := strings.Cut(token, "="); ok && contains(promoOptions, k) {
+			key = k
+			options[key] = append(options[key][:0], v)
+			continue
+		}
+		if key == "" {
+			return p, fmt.Errorf("непонятное условие «%s»", token)
+		}
+		options[key] = append(options[key], token)
+	}
+
+	for key, words := range options {
+		value := strings.TrimSpace(strings.Join(words, " "))
+		switch key {
+		case "from", "to":
+			day, ok := parseExportDate(value)
+			if !ok {
+				return p, fmt.Errorf("дата «%s»: нужен формат ГГГГ-ММ-ДД", value)
+			}
+			if key == "from" {
+				p.From = day
+			} else {
+				p.To = day
+			}
+		case "limit":
+			if p.MaxUses, err = strconv.Atoi(value); err != nil || p.MaxUses < 0 {
+				return p, fmt.Errorf("лимит «%s»: нужно целое число", value)
+			}
+		case "speaker":
+			for _, s := range Speakers {
+				if strings.EqualFold(s.Name, value) {
+					p.Speaker = s.Name
+				}
+			}
+			if p.Speaker == "" {
+				return p, fmt.Errorf("спикер «%s» не найден в каталоге", value)
+			}
+		case "city":
+			p.City = value
+		}
+	}
+	if !p.From.IsZero() && !p.To.IsZero() && p.To.Before(p.From) {
+		return p, errors.New("дата окончания раньше даты начала")
+	}
+	return p, nil
+}
+
+// describePromo - скидка и условия промокода одной строкой
+func describePromo(p db.PromoCode) string {
+	parts := []string{promoDiscountLabel(p)}
+	if !p.From.IsZero() {
+		parts = append(parts, "с "+p.From.Format("02.01.2006"))
+	}
+	if !p.To.IsZero() {
+		parts = append(parts, "по "+p.To.Format("02.01.2006"))
+	}
+	if p.MaxUses > 0 {
+		parts = append(parts, fmt.Sprintf("использован %d из %d", p.Used, p.MaxUses))
+	} else {
+		parts = append(parts, fmt.Sprintf("использован %d", p.Used))
+	}
+	if p.Speaker != "" {
+		parts = append(parts, "спикер "+p.Speaker)
+	}
+	if p.City != "" {
+		parts = append(parts, "город "+p.City)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// promoDiscountLabel - размер скидки: "−10%" или "−3 000 ₽"
+func promoDiscountLabel(p db.PromoCode) string {
+	if p.Percent {
+		return fmt.Sprintf("−%d%%", p.Amount)
+	}
+	return "−" + formatPrice(p.Amount)
+}
+
+// handlePromoCommand - команда администратора /promo: список, добавление и удаление промокодов
+func handlePromoCommand(bot tools.Sender, chatID int64, args string) {
+	action, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	switch action {
+	case "":
+		codes, err := db.GetPromoCodes(dbConn)
+		if err != nil {
+			log.Printf("promo: failed to load codes: %v", err)
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось загрузить промокоды."))
+			return
+		}
+		if len(codes) == 0 {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "Промокодов пока нет.\n\n"+promoAdminHelp))
+			return
+		}
+		var b strings.Builder
+		b.WriteString("🏷 Промокоды:\n")
+		for _, p := range codes {
+			fmt.Fprintf(&b, "\n%s: %s", p.Code, describePromo(p))
+		}
+		for _, part := range tools.SplitMessage(b.String()) {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, part))
+		}
+	case "add":
+		p, err := parsePromoArgs(rest)
+		if err != nil {
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ "+err.Error()+"\n\n"+promoAdminHelp))
+			return
+		}
+		if err := db.SavePromoCode(dbConn, p); err != nil {
+			log.Printf("promo: failed to save %s: %v", p.Code, err)
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось сохранить промокод."))
+			return
+		}
+		log.Printf("promo: saved %s (%s)", p.Code, describePromo(p))
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Промокод %s: %s", p.Code, describePromo(p))))
+	case "del":
+		code := strings.TrimSpace(rest)
+		deleted, err := db.DeletePromoCode(dbConn, code)
+		switch {
+		case err != nil:
+			log.Printf("promo: failed to delete %s: %v", code, err)
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось удалить промокод."))
+		case !deleted:
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("Промокода «%s» нет.", code)))
+		default:
+			tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf("🗑 Промокод %s удалён.", code)))
+		}
+	default:
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, promoAdminHelp))
+	}
+}
+
+// promoAvailable - показывать ли кнопку «Промокод»: есть хотя бы один действующий код
+func promoAvailable() bool {
+	ok, err := db.HasActivePromoCodes(dbConn, time.Now())
+	if err != nil {
+		log.Printf("promo: failed to check codes: %v", err)
+	}
+	return ok
+}
+
+// askPromoCode просит клиента прислать промокод следующим сообщением
+func askPromoCode(bot tools.Sender, chatID int64) {
+	promoAwaiting[chatID] = true
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, promoPrompt))
+	touchFunnel(chatID)
+}
+
+// handlePromoMessage принимает промокод после кнопки «Промокод». false - сообщение не похоже на код,
+// его обрабатываем как обычно
+func handlePromoMessage(bot tools.Sender, chatID int64, text string) bool {
+	if !promoAwaiting[chatID] {
+		return false
+	}
+	code := strings.TrimSpace(text)
+	if !promoCodePattern.MatchString(code) {
+		// клиент передумал и пишет о своём
+		delete(promoAwaiting, chatID)
+		return false
+	}
+
+	// ждём только одну попытку: следующее сообщение - уже не код
+	delete(promoAwaiting, chatID)
+
+	session := snapshotSession(chatID)
+	p, err := checkPromoCode(session, chatID, code, time.Now())
+	if err == nil {
+		err = reservePromoCode(chatID, *p)
+	}
+	if err != nil {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "❌ "+err.Error()+promoRetryHint))
+		return true
+	}
+	if session.PromoCode != "" && !strings.EqualFold(session.PromoCode, p.Code) {
+		releasePromoCode(chatID, session.PromoCode)
+	}
+	setSessionPromo(chatID, p.Code)
+
+	text = fmt.Sprintf("✅ Промокод %s применён: скидка %s", p.Code, strings.TrimPrefix(promoDiscountLabel(*p), "−"))
+	course, ok := findCourse(session.SpeakerName, session.City)
+	if price, found := parsePrice(course.Price); ok && found {
+		text += fmt.Sprintf("\nСтоимость: %s → %s", formatPrice(price), formatPrice(applyPromo(price, *p)))
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = CourseActionKeyboard(course)
+	tools.SendAndLog(bot, msg)
+	log.Printf("promo: chat %d applied %s", chatID, p.Code)
+	return true
+}
+
+// checkPromoCode ищет промокод и проверяет, что он действует на выбранный клиентом курс.
+// Текст ошибки показывается клиенту
+func checkPromoCode(session *bitrixSession, chatID int64, code string, now time.Time) (*db.PromoCode, error) {
+	if session == nil || session.City == "" {
+		return nil, errors.New("Сначала выберите курс — промокод применяется к нему.")
+	}
+	p, err := db.GetPromoCode(dbConn, code)
+	if err != nil {
+		log.Printf("promo: failed to load %s: %v", code, err)
+		return nil, errors.New("Не удалось проверить промокод, попробуйте позже.")
+	}
+	if p == nil {
+		return nil, fmt.Errorf("Промокод «%s» не найден. Проверьте, нет ли опечатки.", code)
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch {
+	case !p.From.IsZero() && today.Before(p.From):
+		return nil, fmt.Errorf("Промокод %s начнёт действовать %s.", p.Code, p.From.Format("02.01.2006"))
+	case !p.To.IsZero() && today.After(p.To):
+		return nil, fmt.Errorf("Срок действия промокода %s закончился %s.", p.Code, p.To.Format("02.01.2006"))
+	case p.Speaker != "" && p.Speaker != session.SpeakerName:
+		return nil, fmt.Errorf("Промокод %s действует только на курсы спикера %s.", p.Code, p.Speaker)
+	case p.City != "" && !strings.EqualFold(courseCity(session.City), p.City):
+		return nil, fmt.Errorf("Промокод %s действует только на курсы в городе %s.", p.Code, p.City)
+	}
+
+	used, err := db.PromoCodeUsed(dbConn, p.Code, chatID)
+	if err != nil {
+		log.Printf("promo: failed to check use of %s: %v", p.Code, err)
+	}
+	if used {
+		return nil, fmt.Errorf("Вы уже использовали промокод %s.", p.Code)
+	}
+	return p, nil
+}
+
+// reservePromoCode занимает использование промокода за клиентом в момент применения,
+// а не при передаче заявки в CRM, чтобы лимит нельзя было превысить
+func reservePromoCode(chatID int64, p db.PromoCode) error {
+	reserved, err := db.ReservePromoCode(dbConn, p.Code, chatID)
+	if err != nil {
+		log.Printf("promo: failed to reserve %s for chat %d: %v", p.Code, chatID, err)
+		return errors.New("Не удалось проверить промокод, попробуйте позже.")
+	}
+	if !reserved {
+		return fmt.Errorf("Промокод %s закончился — его уже использовали максимальное число раз.", p.Code)
+	}
+	return nil
+}
+
+// releasePromoCode возвращает зарезервированное использование, когда клиент сменил курс или код
+func releasePromoCode(chatID int64, code string) {
+	if err := db.ReleasePromoCode(dbConn, code, chatID); err != nil {
+		log.Printf("promo: failed to release %s of chat %d: %v", code, chatID, err)
+	}
+}
+
+// courseCity - город из "Город | Дата"
+func courseCity(city string) string {
+	name, _, _ := strings.Cut(city, "|")
+	return strings.TrimSpace(name)
+}
+
+// applyPromo считает цену со скидкой, не меньше нуля
+func applyPromo(price int, p db.PromoCode) int {
+	if p.Percent {
+		return price - (price*p.Amount+50)/100
+	}
+	return max(price-p.Amount, 0)
+}
+
+// sessionPricing дополняет сессию ценой курса и промокодом для сделки в CRM.
+// Срок промокода не перепроверяется: клиент применил его, пока код действовал.
+// Резерв продлевается: если он успел истечь и лимит заняли другие, код снимается
+func sessionPricing(chatID int64, session *bitrixSession) {
+	if course, ok := findCourse(session.SpeakerName, session.City); ok {
+		session.Price, _ = parsePrice(course.Price)
+	}
+	session.FinalPrice = session.Price
+	if session.PromoCode == "" {
+		return
+	}
+	p, err := db.GetPromoCode(dbConn, session.PromoCode)
+	if err != nil || p == nil {
+		log.Printf("promo: code %s of session is unavailable: %v", session.PromoCode, err)
+		session.PromoCode = ""
+		return
+	}
+	if reserved, err := db.ReservePromoCode(dbConn, p.Code, chatID); err != nil {
+		log.Printf("promo: failed to renew reservation of %s for chat %d: %v", p.Code, chatID, err)
+	} else if !reserved {
+		log.Printf("promo: reservation of %s for chat %d expired and the limit is used up", p.Code, chatID)
+		session.PromoCode = ""
+		return
+	}
+	session.PromoDiscount = promoDiscountLabel(*p)
+	if session.Price > 0 {
+		session.FinalPrice = applyPromo(session.Price, *p)
+	}
+}
+
+// parsePrice достаёт сумму в рублях из цены каталога в свободной форме: "25 000 ₽" -> 25000.
+// Берётся первое число, пробелы и точки внутри него считаются разделителями разрядов.
+// Запятая или точка, за которой одна-две последние цифры числа, отделяет копейки: "15 000,00 ₽" -> 15000
+func parsePrice(raw string) (int, bool) {
+	runes := []rune(raw)
+	var digits strings.Builder
+	for i, r := range runes {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+			continue
+		}
+		if digits.Len() == 0 {
+			continue
+		}
+		if (r == ',' || r == '.') && fractionDigits(runes[i+1:]) {
+			break
+		}
+		if !strings.ContainsRune(" .,\u00a0\u202f", r) {
+			break
+		}
+	}
+	n, err := strconv.Atoi(digits.String())
+	return n, err == nil && n > 0
+}
+
+// fractionDigits - начинается ли текст с одной-двух цифр, которыми число заканчивается
+func fractionDigits(rest []rune) bool {
+	n := 0
+	for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+		n++
+	}
+	return n >= 1 && n <= 2
+}
+
+// formatPrice печатает сумму как в каталоге: 22500 -> "22 500 ₽"
+func formatPrice(n int) string {
+	s := strconv.Itoa(n)
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + " ₽"
+}
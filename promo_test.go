@@ -0,0 +1,213 @@
+package main
+
+import (
+	"app/db"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePromoArgs(t *testing.T) {
+	useDemoCatalog(t)
+
+	p, err := parsePromoArgs("SPRING 10% from=2024-05-01 to=31.05.2024 limit=50 speaker=мария петрова (pdf) city=Казань")
+	if err != nil {
+		t.Fatalf("parsePromoArgs: %v", err)
+	}
+	want := db.PromoCode{
+		Code:    "SPRING",
+		Percent: true,
+		Amount:  10,
+		From:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local),
+		To:      time.Date(2024, 5, 31, 0, 0, 0, 0, time.Local),
+		MaxUses: 50,
+		Speaker: "Мария Петрова (pdf)",
+		City:    "Казань",
+	}
+	if p != want {
+		t.Errorf("promo = %+v, want %+v", p, want)
+	}
+
+	if p, err := parsePromoArgs("MINUS3000 3000₽"); err != nil || p.Percent || p.Amount != 3000 {
+		t.Errorf("fixed promo = %+v, %v", p, err)
+	}
+	for _, args := range []string{"SPRING", "SPRING 150%", "SPRING 10% speaker=Никто", "SPRING 10% лишнее", "S 10%", "ВЕСНА 10%"} {
+		if _, err := parsePromoArgs(args); err == nil {
+			t.Errorf("parsePromoArgs(%q): expected error", args)
+		}
+	}
+}
+
+func TestPromoPrices(t *testing.T) {
+	for raw, want := range map[string]int{
+		"25 000 ₽": 25000, "от 18.500 руб.": 18500, "бесплатно": 0,
+		"15 000,00 ₽": 15000, "1 500,5 руб.": 1500, "12,000 ₽": 12000,
+	} {
+		if got, _ := parsePrice(raw); got != want {
+			t.Errorf("parsePrice(%q) = %d, want %d", raw, got, want)
+		}
+	}
+	if got := formatPrice(1234567); got != "1 234 567 ₽" {
+		t.Errorf("formatPrice = %q", got)
+	}
+	if got := applyPromo(25000, db.PromoCode{Percent: true, Amount: 10}); got != 22500 {
+		t.Errorf("percent discount = %d", got)
+	}
+	if got := applyPromo(2000, db.PromoCode{Amount: 3000}); got != 0 {
+		t.Errorf("fixed discount = %d", got)
+	}
+}
+
+func TestReservePromoCode(t *testing.T) {
+	useTestDB(t)
+	if err := db.SavePromoCode(dbConn, db.PromoCode{Code: "ONE", Amount: 100, MaxUses: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.ReservePromoCode(dbConn, "one", 1); !ok || err != nil {
+		t.Fatalf("first reserve = %v, %v", ok, err)
+	}
+	if ok, err := db.ReservePromoCode(dbConn, "ONE", 1); !ok || err != nil {
+		t.Errorf("own reserve again = %v, %v", ok, err)
+	}
+	if ok, err := db.ReservePromoCode(dbConn, "ONE", 2); ok || err != nil {
+		t.Errorf("reserve over limit = %v, %v", ok, err)
+	}
+	if used, _ := db.PromoCodeUsed(dbConn, "ONE", 1); used {
+		t.Error("reservation counted as used")
+	}
+
+	// резерв снят - код свободен
+	if err := db.ReleasePromoCode(dbConn, "ONE", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.ReservePromoCode(dbConn, "ONE", 2); !ok || err != nil {
+		t.Errorf("reserve after release = %v, %v", ok, err)
+	}
+	if err := db.UsePromoCode(dbConn, "ONE", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReleasePromoCode(dbConn, "ONE", 2); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := db.PromoCodeUsed(dbConn, "ONE", 2); !used {
+		t.Error("confirmed use released")
+	}
+}
+
+func TestPromoReservationExpires(t *testing.T) {
+	useTestDB(t)
+	if err := db.SavePromoCode(dbConn, db.PromoCode{Code: "ONE", Amount: 100, MaxUses: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.ReservePromoCode(dbConn, "ONE", 1); !ok || err != nil {
+		t.Fatalf("first reserve = %v, %v", ok, err)
+	}
+	stale := time.Now().Add(-db.PromoReservationTTL - time.Hour).Format("2006-01-02 15:04:05")
+	if _, err := dbConn.Exec("UPDATE promo_uses SET date = ?", stale); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := db.GetPromoCode(dbConn, "ONE"); p == nil || p.Used != 0 {
+		t.Errorf("stale reservation counted: %+v", p)
+	}
+
+	// брошенный резерв не мешает другому клиенту
+	if ok, err := db.ReservePromoCode(dbConn, "ONE", 2); !ok || err != nil {
+		t.Fatalf("reserve over stale = %v, %v", ok, err)
+	}
+	if ok, err := db.ReservePromoCode(dbConn, "ONE", 1); ok || err != nil {
+		t.Errorf("stale own reserve renewed over limit = %v, %v", ok, err)
+	}
+	session := &bitrixSession{PromoCode: "ONE"}
+	sessionPricing(1, session)
+	if session.PromoCode != "" {
+		t.Errorf("expired promo kept: %+v", session)
+	}
+	session = &bitrixSession{PromoCode: "ONE"}
+	sessionPricing(2, session)
+	if session.PromoCode != "ONE" {
+		t.Errorf("own promo dropped: %+v", session)
+	}
+}
+
+func TestPromoCodeBooking(t *testing.T) {
+	const admin, client, other = 900, 3001, 3002
+	useTestDB(t)
+	useDemoCatalog(t)
+	bitrix, crm := newTestBitrix(t)
+	useTestCRM(t, crm)
+	useAdmin(t, admin)
+	tg, bot := newTestTelegram(t)
+	t.Cleanup(func() { promoAwaiting = make(map[int64]bool) })
+
+	HandleMessage(bot, messageUpdate(admin, "/promo add SPRING 10% limit=1 speaker=Иван Иванов (текст)"))
+	if texts := sentTexts(tg, admin); len(texts) != 1 || !strings.HasPrefix(texts[0], "✅ Промокод SPRING: −10%") {
+		t.Fatalf("admin reply = %v", texts)
+	}
+
+	// курс другого спикера - код не подходит
+	HandleCallback(bot, callbackUpdate(client, courseData(1, 0)))
+	sent := tg.Sent(client)
+	if !strings.Contains(sent[len(sent)-1].ReplyMarkup(), promoEnterData) {
+		t.Fatalf("no promo button: %+v", sent[len(sent)-1])
+	}
+	HandleCallback(bot, callbackUpdate(client, promoEnterData))
+	HandleMessage(bot, messageUpdate(client, "AUTUMN"))
+	texts := sentTexts(tg, client)
+	if got := texts[len(texts)-1]; !strings.Contains(got, "Промокод «AUTUMN» не найден") || !strings.Contains(got, promoRetryHint) {
+		t.Errorf("unknown code reply = %q", got)
+	}
+	// после неудачной попытки код больше не ждём
+	if promoAwaiting[client] {
+		t.Error("still awaiting promo code after a failed attempt")
+	}
+	HandleCallback(bot, callbackUpdate(client, promoEnterData))
+	HandleMessage(bot, messageUpdate(client, "spring"))
+	texts = sentTexts(tg, client)
+	if got := texts[len(texts)-1]; !strings.Contains(got, "только на курсы спикера Иван Иванов (текст)") {
+		t.Errorf("wrong speaker reply = %q", got)
+	}
+	// обычная фраза - не промокод
+	HandleCallback(bot, callbackUpdate(client, promoEnterData))
+	if handlePromoMessage(bot, client, "а скидки есть?") || promoAwaiting[client] {
+		t.Error("plain text taken as promo code")
+	}
+
+	// подходящий курс: код применяется, цена со скидкой уходит в сделку
+	HandleCallback(bot, callbackUpdate(client, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(client, promoEnterData))
+	HandleMessage(bot, messageUpdate(client, "spring"))
+	texts = sentTexts(tg, client)
+	if got := texts[len(texts)-1]; !strings.Contains(got, "Промокод SPRING применён: скидка 10%\nСтоимость: 25 000 ₽ → 22 500 ₽") {
+		t.Fatalf("applied reply = %q", got)
+	}
+	// единственное использование занято сразу, до передачи заявки
+	HandleCallback(bot, callbackUpdate(other, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(other, promoEnterData))
+	HandleMessage(bot, messageUpdate(other, "SPRING"))
+	texts = sentTexts(tg, other)
+	if got := texts[len(texts)-1]; !strings.Contains(got, "использовали максимальное число раз") {
+		t.Errorf("reserved limit reply = %q", got)
+	}
+	HandleCallback(bot, callbackUpdate(client, "book_course"))
+	HandleCallback(bot, callbackUpdate(client, consentAcceptData))
+	HandleMessage(bot, contactUpdate(client, "79991234567"))
+
+	items := bitrix.Items()
+	if len(items) != 1 || fmt.Sprint(items[0].Fields["opportunity"]) != "22500" {
+		t.Fatalf("items = %+v", items)
+	}
+	if comments := bitrix.Comments(); len(comments) != 1 ||
+		!strings.Contains(comments[0].Comment, "Промокод: SPRING (−10%)\nСтоимость: 25 000 ₽ → 22 500 ₽") {
+		t.Errorf("comments = %+v", comments)
+	}
+
+	// лимит исчерпан
+	HandleCallback(bot, callbackUpdate(other, courseData(0, 0)))
+	HandleCallback(bot, callbackUpdate(other, promoEnterData))
+	HandleMessage(bot, messageUpdate(other, "SPRING"))
+	texts = sentTexts(tg, other)
+	if got := texts[len(texts)-1]; !strings.Contains(got, "использовали максимальное число раз") {
+		t.Errorf("limit reply = %q", got)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// источник пользователей, пришедших по приглашению без других меток
+	referralSource = "referral"
+
+	referralInviteTemplate = "🤝 Приглашайте друзей на курсы по личной ссылке:\n%s\n\nСколько друзей пришло по ней, покажет команда /invite"
+	referralNoBooking      = "Личная ссылка для приглашения друзей появится после записи на курс 😉"
+)
+
+// referralCode - код приглашения клиента в параметре /start. Код случайный и хранится в базе,
+// чтобы по chat ID нельзя было подделать чужую ссылку
+func referralCode(chatID int64) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return db.EnsureReferralCode(dbConn, chatID, hex.EncodeToString(buf))
+}
+
+// referralLink - личная ссылка клиента для приглашения друзей
+func referralLink(bot tools.Sender, chatID int64) (string, error) {
+	code, err := referralCode(chatID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://t.me/%s?start=ref-%s", botUsername(bot), code), nil
+}
+
+// referralReward читает REFERRAL_REWARD - описание награды, и REFERRAL_REWARD_AFTER - сколько приглашённых
+// должны записаться на курс, чтобы её получить. Пустая награда - программа без наград
+func referralReward() (reward string, after int) {
+	reward = strings.TrimSpace(os.Getenv("REFERRAL_REWARD"))
+	after = 1
+	if raw := strings.TrimSpace(os.Getenv("REFERRAL_REWARD_AFTER")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			log.Printf("referral: invalid REFERRAL_REWARD_AFTER %q, using 1", raw)
+		} else {
+			after = n
+		}
+	}
+	return reward, after
+}
+
+// saveReferral засчитывает приглашение по коду из ссылки /start
+func saveReferral(chatID int64, code string) {
+	referrer, err := db.FindReferralCode(dbConn, code)
+	if err != nil {
+		log.Printf("referral: failed to find code %q from chat %d: %v", code, chatID, err)
+		return
+	}
+	if referrer == 0 {
+		log.Printf("referral: bad code %q from chat %d", code, chatID)
+		return
+	}
+	saved, err := db.SaveReferrer(dbConn, chatID, referrer)
+	if err != nil {
+		log.Printf("referral: failed to save referrer of chat %d: %v", chatID, err)
+		return
+	}
+	if saved {
+		log.Printf("referral: chat %d invited by %d", chatID, referrer)
+	}
+}
+
+// sendReferralInvite присылает записавшемуся клиенту личную ссылку для приглашения друзей
+func sendReferralInvite(bot tools.Sender, chatID int64) {
+	booked, err := db.HasBooking(dbConn, chatID)
+	if err != nil {
+		log.Printf("referral: failed to check booking of chat %d: %v", chatID, err)
+		return
+	}
+	if !booked {
+		return
+	}
+	link, err := referralLink(bot, chatID)
+	if err != nil {
+		log.Printf("referral: failed to make link for chat %d: %v", chatID, err)
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(referralInviteTemplate, link))
+	msg.DisableWebPagePreview = true
+	tools.SendAndLog(bot, msg)
+}
+
+// sendReferralStatus отвечает на /invite: ссылка, число приглашённых и статус награды
+func sendReferralStatus(bot tools.Sender, chatID int64) {
+	booked, err := db.HasBooking(dbConn, chatID)
+	if err != nil {
+		log.Printf("referral: failed to check booking of chat %d: %v", chatID, err)
+	}
+	if !booked {
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, referralNoBooking))
+		return
+	}
+
+	link, err := referralLink(bot, chatID)
+	if err != nil {
+		log.Printf("referral: failed to make link for chat %d: %v", chatID, err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось загрузить приглашения, попробуйте позже."))
+		return
+	}
+	invited, joined, err := db.ReferralStats(dbConn, chatID)
+	if err != nil {
+		log.Printf("referral: failed to load stats of chat %d: %v", chatID, err)
+		tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, "⚠️ Не удалось загрузить приглашения, попробуйте позже."))
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🤝 Ваша ссылка для друзей:\n%s\n\n", link)
+	fmt.Fprintf(&b, "Пришли по ссылке: %d\nЗаписались на курс: %d", invited, joined)
+	if reward, after := referralReward(); reward != "" {
+		if joined >= after {
+			fmt.Fprintf(&b, "\n\n🎁 Награда «%s» ваша! Менеджер свяжется с вами, чтобы её применить.", reward)
+		} else {
+			fmt.Fprintf(&b, "\n\n🎁 До награды «%s» осталось пригласить записавшихся друзей: %d", reward, after-joined)
+		}
+	}
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.DisableWebPagePreview = true
+	tools.SendAndLog(bot, msg)
+}
+
+// referrerDisplayName - кто пригласил клиента, для сделки в CRM; пусто - пришёл сам.
+// Телефон пригласившего в чужую сделку не передаём: это его персональные данные
+func referrerDisplayName(chatID int64) string {
+	referrer, err := db.GetReferrer(dbConn, chatID)
+	if err != nil {
+		log.Printf("referral: failed to load referrer of chat %d: %v", chatID, err)
+		return ""
+	}
+	if referrer == nil {
+		return ""
+	}
+	name := strings.TrimSpace(referrer.Fio)
+	if name == "" {
+		name = "Пользователь Telegram"
+	}
+	return fmt.Sprintf("%s (chat %d)", name, referrer.ChatID)
+}
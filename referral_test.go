@@ -0,0 +1,87 @@
+package main
+
+import (
+	"app/db"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReferralProgram(t *testing.T) {
+	const referrer, friend = 2001, 2002
+	useTestDB(t)
+	useDemoCatalog(t)
+	bitrix, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("REFERRAL_REWARD", "скидка 10% на следующий курс")
+	t.Setenv("REFERRAL_REWARD_AFTER", "1")
+
+	// до записи ссылки нет
+	HandleMessage(bot, messageUpdate(referrer, "/invite"))
+	if texts := sentTexts(tg, referrer); len(texts) != 1 || texts[0] != referralNoBooking {
+		t.Fatalf("invite before booking = %v", texts)
+	}
+
+	book := func(chatID int64, start, phone string) {
+		t.Helper()
+		HandleMessage(bot, messageUpdate(chatID, start))
+		HandleCallback(bot, callbackUpdate(chatID, "book_course"))
+		HandleCallback(bot, callbackUpdate(chatID, consentAcceptData))
+		HandleMessage(bot, contactUpdate(chatID, phone))
+	}
+	book(referrer, "/start sp-mariya-petrova-pdf__c-kazan", "79991234561")
+	texts := sentTexts(tg, referrer)
+	code, err := referralCode(referrer)
+	if err != nil || len(code) != 16 {
+		t.Fatalf("referral code = %q, %v", code, err)
+	}
+	if again, _ := referralCode(referrer); again != code {
+		t.Errorf("referral code changed: %q -> %q", code, again)
+	}
+	link := "https://t.me/fake_bot?start=ref-" + code
+	if !strings.Contains(texts[len(texts)-1], link) {
+		t.Fatalf("invite after booking = %q", texts[len(texts)-1])
+	}
+
+	// код, собранный из chat ID, не засчитывается
+	const stranger = 2003
+	HandleMessage(bot, messageUpdate(stranger, "/start ref-"+strconv.FormatInt(referrer, 36)))
+	if r, err := db.GetReferrer(dbConn, stranger); r != nil || err != nil {
+		t.Errorf("forged referral = %+v, %v", r, err)
+	}
+
+	// давний пользователь без телефона - не новый, приглашение не засчитывается
+	const existing = 2004
+	HandleMessage(bot, messageUpdate(existing, "Здравствуйте"))
+	HandleMessage(bot, messageUpdate(existing, "/start ref-"+code))
+	if r, err := db.GetReferrer(dbConn, existing); r != nil || err != nil {
+		t.Errorf("existing user referral = %+v, %v", r, err)
+	}
+
+	// друг приходит по ссылке и записывается - в сделке виден пригласивший
+	book(friend, "/start ref-"+code+"__sp-mariya-petrova-pdf__c-kazan", "79991234562")
+	if utm, _ := db.GetUserUTM(dbConn, friend); utm.Source != referralSource {
+		t.Errorf("friend source = %+v", utm)
+	}
+	comments := bitrix.Comments()
+	if len(comments) != 2 || !strings.Contains(comments[1].Comment, "Пригласил: Иван Петров (chat 2001)") {
+		t.Errorf("comments = %+v", comments)
+	}
+
+	// повторный переход и самоприглашение не засчитываются
+	if ok, err := db.SaveReferrer(dbConn, friend, referrer); ok || err != nil {
+		t.Errorf("second referral = %v, %v", ok, err)
+	}
+	if ok, err := db.SaveReferrer(dbConn, referrer, referrer); ok || err != nil {
+		t.Errorf("self referral = %v, %v", ok, err)
+	}
+
+	tg.Reset()
+	HandleMessage(bot, messageUpdate(referrer, "/invite"))
+	texts = sentTexts(tg, referrer)
+	if len(texts) != 1 || !strings.Contains(texts[0], "Пришли по ссылке: 1\nЗаписались на курс: 1") ||
+		!strings.Contains(texts[0], "Награда «скидка 10% на следующий курс» ваша") {
+		t.Errorf("invite status = %v", texts)
+	}
+}
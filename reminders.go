@@ -0,0 +1,205 @@
+package main
+
+import (
+	"app/db"
+	tools "app/handlers"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// За сколько до начала курса напоминать по умолчанию
+	defaultReminderOffsets = "7d,1d,3h"
+	// Как часто проверять, не пора ли отправить напоминания
+	schedulerInterval = time.Minute
+)
+
+// reminderOffsets читает REMINDER_OFFSETS - интервалы до начала курса через запятую: 7d, 1d, 3h, 30m.
+// Пустая строка - значения по умолчанию, "off" - напоминания отключены
+func reminderOffsets() []time.Duration {
+	raw := strings.TrimSpace(os.Getenv("REMINDER_OFFSETS"))
+	if raw == "" {
+		raw = defaultReminderOffsets
+	}
+	if strings.EqualFold(raw, "off") {
+		return nil
+	}
+
+	var offsets []time.Duration
+	for _, part := range strings.Split(raw, ",") {
+		d, err := parseOffset(part)
+		if err != nil || d <= 0 {
+			log.Printf("reminders: invalid offset %q in REMINDER_OFFSETS", part)
+			continue
+		}
+		offsets = append(offsets, d)
+	}
+	// от большего к меньшему
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	return offsets
+}
+
+// parseOffset разбирает длительность Go (3h, 30m) с дополнительной единицей d - сутки
+func parseOffset(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// formatOffset - ключ напоминания в базе, совпадает с записью в REMINDER_OFFSETS
+func formatOffset(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// sendDueReminders отправляет напоминания по всем активным броням, для которых наступило время.
+// Если сразу наступило несколько напоминаний (бронь сделана за день до курса), уходит одно - ближайшее к началу,
+// остальные помечаются отправленными
+func sendDueReminders(bot tools.Sender, now time.Time) {
+	offsets := reminderOffsets()
+	if len(offsets) == 0 {
+		return
+	}
+
+	bookings, err := db.GetActiveBookings(dbConn)
+	if err != nil {
+		log.Printf("reminders: failed to load bookings: %v", err)
+		return
+	}
+
+	sessions := catalogSessions()
+	for _, b := range bookings {
+		s, ok := sessions[b.Session]
+		if !ok || s.course.Start.IsZero() || !now.Before(s.course.Start) {
+			continue
+		}
+
+		// для сессии без времени начала напоминания «за 3 часа» до полуночи не имеют смысла
+		dateOnly := s.course.Start.Hour() == 0 && s.course.Start.Minute() == 0
+
+		var due []string
+		for _, offset := range offsets {
+			if now.Before(s.course.Start.Add(-offset)) || dateOnly && offset < 24*time.Hour {
+				continue
+			}
+			key := formatOffset(offset)
+			sent, err := db.ReminderSent(dbConn, b.ChatID, b.Session, key)
+			if err != nil {
+				log.Printf("reminders: failed to check reminder: %v", err)
+				due = nil
+				break
+			}
+			if !sent {
+				due = append(due, key)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		msg := tgbotapi.NewMessage(b.ChatID, reminderText(s.speaker, s.course, now))
+		msg.ParseMode = tgbotapi.ModeHTML
+		if _, err := bot.Send(msg); err != nil {
+			// попробуем на следующей проверке
+			log.Printf("reminders: failed to send reminder to chat %d: %v", b.ChatID, err)
+			continue
+		}
+		for _, key := range due {
+			if err := db.SaveReminderSent(dbConn, b.ChatID, b.Session, key); err != nil {
+				log.Printf("reminders: failed to save reminder: %v", err)
+			}
+		}
+		log.Printf("reminders: sent reminder to chat %d for %s", b.ChatID, b.Session)
+	}
+}
+
+type catalogSession struct {
+	speaker string
+	course  Course
+}
+
+// catalogSessions - сессии текущего каталога по ключу брони
+func catalogSessions() map[string]catalogSession {
+	sessions := make(map[string]catalogSession)
+	for _, s := range Speakers {
+		for _, c := range s.Courses {
+			sessions[sessionKey(s.Name, c.City)] = catalogSession{speaker: s.Name, course: c}
+		}
+	}
+	return sessions
+}
+
+// reminderText собирает напоминание: когда и где курс, что взять с собой
+func reminderText(speakerName string, c Course, now time.Time) string {
+	var b strings.Builder
+
+	title := c.Title
+	if title == "" {
+		title = speakerName
+	}
+	fmt.Fprintf(&b, "⏰ Напоминаем: курс «%s» начнётся %s!\n\n", html.EscapeString(title), untilText(c.Start.Sub(now)))
+	fmt.Fprintf(&b, "📍 %s\n", html.EscapeString(c.City))
+	if c.Start.Hour() != 0 || c.Start.Minute() != 0 {
+		fmt.Fprintf(&b, "🕙 Начало в %s\n", c.Start.Format("15:04"))
+	}
+	if c.Address != "" {
+		fmt.Fprintf(&b, "🏠 Адрес: %s\n", html.EscapeString(c.Address))
+	}
+
+	if toolsText := strings.TrimSpace(tools.GetToolsText(speakerDir(c.Program))); toolsText != "" {
+		fmt.Fprintf(&b, "\n🧰 Что взять с собой:\n%s\n", html.EscapeString(toolsText))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// untilText - сколько осталось до начала, например «через 2 дня» или «через 3 часа»
+func untilText(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		days := int((d + 12*time.Hour) / (24 * time.Hour))
+		return fmt.Sprintf("через %d %s", days, plural(days, "день", "дня", "дней"))
+	case d >= 20*time.Hour:
+		return "завтра"
+	case d >= time.Hour:
+		hours := int((d + 30*time.Minute) / time.Hour)
+		return fmt.Sprintf("через %d %s", hours, plural(hours, "час", "часа", "часов"))
+	default:
+		return "совсем скоро"
+	}
+}
+
+// plural выбирает форму слова для числа: 1 день, 2 дня, 5 дней
+func plural(n int, one, few, many string) string {
+	switch n := n % 100; {
+	case n >= 11 && n <= 14:
+		return many
+	case n%10 == 1:
+		return one
+	case n%10 >= 2 && n%10 <= 4:
+		return few
+	default:
+		return many
+	}
+}
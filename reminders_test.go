@@ -0,0 +1,78 @@
+package main
+
+import (
+	"app/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReminderOffsets(t *testing.T) {
+	t.Setenv("REMINDER_OFFSETS", "3h, 7d,90m,oops,1d")
+	var got []string
+	for _, d := range reminderOffsets() {
+		got = append(got, formatOffset(d))
+	}
+	if strings.Join(got, ",") != "7d,1d,3h,90m" {
+		t.Errorf("offsets = %v", got)
+	}
+
+	t.Setenv("REMINDER_OFFSETS", "off")
+	if offsets := reminderOffsets(); len(offsets) != 0 {
+		t.Errorf("offsets = %v, want none", offsets)
+	}
+}
+
+func TestSendDueReminders(t *testing.T) {
+	const chatID = 501
+	useTestDB(t)
+	tg, bot := newTestTelegram(t)
+	t.Setenv("REMINDER_OFFSETS", "7d,1d,3h")
+
+	start := time.Date(2026, time.July, 15, 10, 0, 0, 0, time.Local)
+	useCatalog(t, []Speaker{{Name: "Мария", Courses: []Course{
+		{City: "Казань | 15 июля 10:00", Program: "Мария/dummy.pdf", Title: "Колористика", Address: "ул. Баумана, 1", Start: start},
+		{City: "Москва | 20 июля", Start: time.Date(2026, time.July, 20, 0, 0, 0, 0, time.Local)},
+	}}})
+	for _, city := range []string{"Казань | 15 июля 10:00", "Москва | 20 июля"} {
+		if err := db.SaveBooking(dbConn, chatID, sessionKey("Мария", city), "", db.BookingConfirmed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(now time.Time, want ...string) {
+		t.Helper()
+		tg.Reset()
+		sendDueReminders(bot, now)
+		texts := sentTexts(tg, chatID)
+		if len(texts) != len(want) {
+			t.Fatalf("at %s: sent %d reminders, want %d: %v", now, len(texts), len(want), texts)
+		}
+		for i, w := range want {
+			if !strings.Contains(texts[i], w) {
+				t.Errorf("at %s: reminder %q does not contain %q", now, texts[i], w)
+			}
+		}
+	}
+
+	// бронь за 2 дня до курса: 7-дневное напоминание по Казани уже просрочено - одно сообщение вместо двух;
+	// по Москве как раз наступило 7-дневное
+	check(start.Add(-50*time.Hour), "через 2 дня", "через 7 дней")
+	check(start.Add(-49 * time.Hour))
+	check(start.Add(-24*time.Hour), "завтра")
+	check(start.Add(-3*time.Hour), "через 3 часа")
+	// повторная проверка (например после перезапуска) напоминание не дублирует
+	check(start.Add(-2 * time.Hour))
+	check(start.Add(time.Hour))
+}
+
+func TestReminderText(t *testing.T) {
+	c := Course{City: "Казань | 15 июля", Program: "Мария/dummy.pdf", Title: "Колористика", Address: "ул. Баумана, 1",
+		Start: time.Date(2026, time.July, 15, 10, 0, 0, 0, time.Local)}
+	text := reminderText("Мария", c, c.Start.Add(-24*time.Hour))
+	for _, want := range []string{"«Колористика» начнётся завтра", "Казань | 15 июля", "Начало в 10:00", "ул. Баумана, 1", "Что взять с собой"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("reminder %q does not contain %q", text, want)
+		}
+	}
+}
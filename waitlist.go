@@ -0,0 +1,123 @@
+package main
+
+import (
+	"app/catalog"
+	"app/db"
+	tools "app/handlers"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	waitlistButtonText     = "🔔 Сообщить о новых датах"
+	waitlistJoinedTemplate = "Готово! 🔔 Напишем, как только у %s появятся новые даты%s."
+	waitlistNotifyTemplate = "🔔 У %s появились новые даты: %s\nВыберите удобную 👇"
+)
+
+// WaitlistKeyboard - кнопка подписки на новые даты спикера; courseIdx < 0 - в любом городе,
+// иначе - в городе этой сессии. В кнопке catalog.SpeakerID и catalog.SessionID, а не номера в списке
+func WaitlistKeyboard(speakerIdx, courseIdx int) tgbotapi.InlineKeyboardMarkup {
+	speaker := Speakers[speakerIdx]
+	data := "waitlist_" + catalog.SpeakerID(speaker.Name)
+	if courseIdx >= 0 {
+		data += "_" + catalog.SessionID(speaker.Name, speaker.Courses[courseIdx].City)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(waitlistButtonText, data)),
+	)
+}
+
+// joinWaitlist записывает чат в лист ожидания спикера (и города, если подписка с заполненной сессии)
+func joinWaitlist(data string, bot tools.Sender, chatID int64) {
+	speakerID, sessionID, _ := strings.Cut(strings.TrimPrefix(data, "waitlist_"), "_")
+	speakerIdx := findSpeakerByID(speakerID)
+	if speakerIdx < 0 {
+		tools.SendAndLog(bot, staleCatalogMessage(chatID))
+		return
+	}
+	speaker := Speakers[speakerIdx]
+
+	city := ""
+	if sessionID != "" {
+		idx, courseIdx := findSessionByID(sessionID)
+		if idx != speakerIdx {
+			tools.SendAndLog(bot, staleCatalogMessage(chatID))
+			return
+		}
+		city = sessionCity(speaker.Courses[courseIdx].City)
+	}
+
+	if err := db.AddToWaitlist(dbConn, chatID, speaker.Name, city); err != nil {
+		log.Printf("waitlist: failed to add chat %d: %v", chatID, err)
+		return
+	}
+
+	trackFunnel(chatID, funnelDone, "", "")
+
+	where := ""
+	if city != "" {
+		where = " в городе " + city
+	}
+	tools.SendAndLog(bot, tgbotapi.NewMessage(chatID, fmt.Sprintf(waitlistJoinedTemplate, speaker.Name, where)))
+}
+
+// sessionCity возвращает город из подписи сессии "Город | Дата"
+func sessionCity(title string) string {
+	city, _, _ := strings.Cut(title, "|")
+	return strings.TrimSpace(city)
+}
+
+// notifyWaitlist сравнивает каталог до и после перезагрузки и сообщает подписчикам о новых сессиях.
+// Подписка одноразовая: после уведомления она удаляется
+func notifyWaitlist(bot tools.Sender, prev []Speaker) {
+	known := make(map[string]bool)
+	for _, s := range prev {
+		for _, c := range s.Courses {
+			known[sessionKey(s.Name, c.City)] = true
+		}
+	}
+
+	for idx, s := range Speakers {
+		var added []Course
+		for _, c := range s.Courses {
+			if !known[sessionKey(s.Name, c.City)] {
+				added = append(added, c)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		entries, err := db.GetWaitlist(dbConn, s.Name)
+		if err != nil {
+			log.Printf("waitlist: failed to load subscribers of %s: %v", s.Name, err)
+			continue
+		}
+		for _, e := range entries {
+			var titles []string
+			for _, c := range added {
+				if e.City == "" || strings.EqualFold(e.City, sessionCity(c.City)) {
+					titles = append(titles, c.City)
+				}
+			}
+			if len(titles) == 0 {
+				continue
+			}
+
+			msg := tgbotapi.NewMessage(e.ChatID, fmt.Sprintf(waitlistNotifyTemplate, s.Name, strings.Join(titles, ", ")))
+			msg.ReplyMarkup = CourseKeyboard(idx)
+			if _, err := bot.Send(msg); err != nil {
+				// подписку оставляем - попробуем при следующем обновлении каталога
+				log.Printf("waitlist: failed to notify chat %d: %v", e.ChatID, err)
+				continue
+			}
+			if err := db.DeleteWaitlistEntry(dbConn, e.ChatID, e.Speaker, e.City); err != nil {
+				log.Printf("waitlist: failed to delete entry for chat %d: %v", e.ChatID, err)
+			}
+			log.Printf("waitlist: notified chat %d about new sessions of %s", e.ChatID, s.Name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"app/db"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// waitlistData - callback кнопки WaitlistKeyboard
+func waitlistData(speakerIdx, courseIdx int) string {
+	return *WaitlistKeyboard(speakerIdx, courseIdx).InlineKeyboard[0][0].CallbackData
+}
+
+// writeCatalog пишет каталог во временный файл и указывает на него CATALOG_PATH
+func writeCatalog(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CATALOG_PATH", path)
+}
+
+func TestWaitlistNotifiesAboutNewSessions(t *testing.T) {
+	useTestDB(t)
+	_, client := newTestBitrix(t)
+	useTestCRM(t, client)
+	tg, bot := newTestTelegram(t)
+	useCatalog(t, nil)
+
+	path := filepath.Join(t.TempDir(), "courses.yaml")
+	writeCatalog(t, path, `speakers:
+  - name: Мария
+    courses: []
+  - name: Пётр
+    courses:
+      - sessions:
+          - city: Казань
+            date: 1 мая
+            seats: 1
+`)
+	if err := reloadCatalog(bot); err != nil {
+		t.Fatal(err)
+	}
+
+	// у Марии дат нет - кнопка подписки на любой город
+	HandleCallback(bot, callbackUpdate(1, speakerData(0)))
+	sent := tg.Sent(1)
+	if len(sent) != 1 || sent[0].Text() != noSessionsMessage || !strings.Contains(sent[0].ReplyMarkup(), `"`+waitlistData(0, -1)+`"`) {
+		t.Fatalf("no sessions = %+v", sent)
+	}
+	HandleCallback(bot, callbackUpdate(1, waitlistData(0, -1)))
+
+	// у Петра заполнена Казань - подписка на Казань
+	if err := db.SaveBooking(dbConn, 99, sessionKey("Пётр", "Казань | 1 мая"), "", db.BookingConfirmed); err != nil {
+		t.Fatal(err)
+	}
+	tg.Reset()
+	HandleCallback(bot, callbackUpdate(2, courseData(1, 0)))
+	if texts := sentTexts(tg, 2); texts[len(texts)-1] != soldOutMessage {
+		t.Fatalf("sold out = %v", texts)
+	}
+	HandleCallback(bot, callbackUpdate(2, waitlistData(1, 0)))
+	if texts := sentTexts(tg, 2); !strings.Contains(texts[len(texts)-1], "в городе Казань") {
+		t.Errorf("joined = %v", texts)
+	}
+
+	// новые даты: у Марии в Москве, у Петра только в Сочи
+	writeCatalog(t, path, `speakers:
+  - name: Мария
+    courses:
+      - sessions:
+          - city: Москва
+            date: 10 июня
+  - name: Пётр
+    courses:
+      - sessions:
+          - city: Казань
+            date: 1 мая
+            seats: 1
+          - city: Сочи
+            date: 5 мая
+`)
+	tg.Reset()
+	if err := reloadCatalog(bot); err != nil {
+		t.Fatal(err)
+	}
+	sent = tg.Sent(1)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text(), "Москва | 10 июня") || !strings.Contains(sent[0].ReplyMarkup(), courseData(0, 0)) {
+		t.Fatalf("notification = %+v", sent)
+	}
+	if sent := tg.Sent(2); len(sent) != 0 {
+		t.Errorf("Kazan subscriber notified about Sochi: %+v", sent)
+	}
+
+	// подписка одноразовая, подписка на Казань остаётся
+	if entries, _ := db.GetWaitlist(dbConn, "Мария"); len(entries) != 0 {
+		t.Errorf("maria waitlist = %+v", entries)
+	}
+	if entries, _ := db.GetWaitlist(dbConn, "Пётр"); len(entries) != 1 || entries[0].City != "Казань" {
+		t.Errorf("petr waitlist = %+v", entries)
+	}
+}
+
+func TestReloadCatalogKeepsPreviousOnError(t *testing.T) {
+	useTestDB(t)
+	_, bot := newTestTelegram(t)
+	useCatalog(t, []Speaker{{Name: "Мария", Courses: []Course{{City: "Казань"}}}})
+
+	writeCatalog(t, filepath.Join(t.TempDir(), "courses.yaml"), "speakers:\n  - name: Мария\n    courses:\n      - title: без сессий\n")
+	if err := reloadCatalog(bot); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(Speakers) != 1 || Speakers[0].Courses[0].City != "Казань" {
+		t.Errorf("catalog replaced: %+v", Speakers)
+	}
+}
+
+func TestStaleCourseButton(t *testing.T) {
+	useTestDB(t)
+	tg, bot := newTestTelegram(t)
+	useCatalog(t, []Speaker{{Name: "Мария", Courses: []Course{{City: "Казань", Program: "Описание"}, {City: "Сочи"}}}})
+	kazan, sochi, waitlist := courseData(0, 0), courseData(0, 1), waitlistData(0, 1)
+
+	// перед Марией добавили спикера, Сочи убрали: кнопка Казани открывает ту же сессию, остальные устарели
+	useCatalog(t, []Speaker{
+		{Name: "Анна", Courses: []Course{{City: "Казань", Program: "Описание"}}},
+		{Name: "Мария", Courses: []Course{{City: "Москва"}, {City: "Казань", Program: "Описание"}}},
+	})
+	HandleCallback(bot, callbackUpdate(1, kazan))
+	if session := snapshotSession(1); session == nil || session.SpeakerName != "Мария" || session.City != "Казань" {
+		t.Fatalf("old button opened %+v", session)
+	}
+
+	for _, data := range []string{sochi, waitlist, "course_0_5"} {
+		tg.Reset()
+		HandleCallback(bot, callbackUpdate(2, data))
+		if texts := sentTexts(tg, 2); len(texts) != 1 || texts[0] != staleCatalogText {
+			t.Errorf("%s: sent = %v", data, texts)
+		}
+	}
+}